@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"mongo-manager/apikey"
+	"mongo-manager/auth"
+	"mongo-manager/rbac"
+	"net/http"
+)
+
+// requireUser resolves the caller's organization and refuses callers authenticated with an API key,
+// so a leaked key can't mint itself successors or revoke the keys of other services. On failure it
+// writes the error response and returns false.
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	organizationID, ok := RequireOrganization(w, r)
+	if !ok {
+		return "", false
+	}
+	if auth.IsAPIKey(r) {
+		WriteProblem(w, r, http.StatusForbidden, CodeForbidden, "API keys can only be managed by signed-in users")
+		return "", false
+	}
+	return organizationID, true
+}
+
+// IssueAPIKey creates an API key for the caller's organization. The full key is only returned by
+// this call.
+func IssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetIssueAPIKeyRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	grants := rbac.Role{Databases: map[string]map[string][]rbac.Permission{}}
+	for database, collections := range request.Databases {
+		grants.Databases[database] = map[string][]rbac.Permission{}
+		for collection, permissions := range collections {
+			for _, permission := range permissions {
+				grants.Databases[database][collection] = append(grants.Databases[database][collection], rbac.Permission(permission))
+			}
+		}
+	}
+	userID, _ := auth.GetUserID(r)
+
+	issued, err := apikey.Issue(r.Context(), organizationID, request.Name, grants, request.ExpiresAt, userID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusCreated, issued)
+}
+
+// ListAPIKeys lists the API keys of the caller's organization without their secrets
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	organizationID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := apikey.List(r.Context(), organizationID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, keys)
+}
+
+// RevokeAPIKey disables the API key given by id immediately
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		WriteError(w, r, &ValidationError{Fields: []FieldError{{Field: "id", Message: "is required"}}})
+		return
+	}
+
+	organizationID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := apikey.Revoke(r.Context(), organizationID, id)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, key)
+}
+
+// RotateAPIKey issues a replacement for the API key given by id. The old key keeps working for
+// gracePeriod, a day by default.
+func RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	id, gracePeriod, err := GetRotateAPIKeyRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := auth.GetUserID(r)
+
+	issued, err := apikey.Rotate(r.Context(), organizationID, id, gracePeriod, userID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusCreated, issued)
+}
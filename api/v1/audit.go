@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"mongo-manager/audit"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// AuditQuery searches the audit log by user, organization, database, collection and time range.
+// It is routed behind the admin permission, and callers only see their own organization's records
+// unless their organization is configured as an audit admin.
+func AuditQuery(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"GET"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetAuditQueryRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := RequireOrganization(w, r)
+	if !ok {
+		return
+	}
+	if !audit.CanSearchAllOrganizations(organizationID) {
+		if request.OrganizationID != "" && request.OrganizationID != organizationID {
+			WriteError(w, r, tenancy.ErrForbidden)
+			return
+		}
+		request.OrganizationID = organizationID
+	}
+
+	page, err := audit.Query(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, page)
+}
+
+// auditAffected records in the audit log how many documents a successful response returned or
+// wrote. Dry runs write nothing and count as zero.
+func auditAffected(r *http.Request, v interface{}) {
+	var count int64
+	switch result := v.(type) {
+	case *mongodriver.InsertOneResult:
+		count = 1
+	case *mongodriver.InsertManyResult:
+		count = int64(len(result.InsertedIDs))
+	case *mongodriver.UpdateResult:
+		count = result.ModifiedCount + result.UpsertedCount
+	case *mongodriver.DeleteResult:
+		count = result.DeletedCount
+	case types.Page:
+		count = int64(len(result.Items))
+	case bson.M:
+		if result != nil {
+			count = 1
+		}
+	case types.DocumentResult:
+		if result.Document != nil {
+			count = 1
+		}
+	case types.BulkWriteReport:
+		count = result.InsertedCount + result.ModifiedCount + result.DeletedCount + result.UpsertedCount
+	case types.TransactionResult:
+		for _, step := range result.Steps {
+			count += step.ModifiedCount + step.DeletedCount
+			if step.InsertedID != nil || step.UpsertedID != nil {
+				count++
+			}
+		}
+	case types.DryRunResult, types.BulkDryRunReport:
+	default:
+		return
+	}
+	audit.SetAffected(r, count)
+}
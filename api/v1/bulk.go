@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"mongo-manager/auth"
+	"mongo-manager/mongo"
+	"net/http"
+)
+
+// BulkWrite runs mixed write operations against one collection and reports per-operation errors.
+// It responds 207 Multi-Status when some operations failed. With dryRun=true nothing is written and
+// every operation is previewed instead.
+func BulkWrite(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetBulkWriteRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	subject := auth.GetSubject(r)
+	for i := range request.Operations {
+		request.Operations[i].Collection = request.Collection
+		if err := AuthorizeOperation(subject, request.Database, request.Operations[i]); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if !request.DryRun {
+			if err := CheckWriteOperation(subject, request.Database, request.Operations[i]); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+		}
+		if err := ScopeWriteOperation(organizationID, request.Database, &request.Operations[i]); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if err := PolicyWriteOperation(view, &request.Operations[i]); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if !request.DryRun {
+			if err := CheckAffectedOperation(r, request.Database, request.Operations[i]); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+		}
+	}
+
+	if request.DryRun {
+		preview, err := mongo.DryRunBulkWrite(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		for i := range preview.Operations {
+			RedactDryRun(view, &preview.Operations[i])
+		}
+		WriteJSON(w, r, http.StatusOK, preview)
+		return
+	}
+
+	report, err := mongo.BulkWrite(r.Context(), request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if len(report.WriteErrors) > 0 || report.WriteConcernError != "" {
+		status = http.StatusMultiStatus
+	}
+	WriteJSON(w, r, status, report)
+}
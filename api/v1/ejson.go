@@ -0,0 +1,236 @@
+package v1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Extended JSON v2 output modes, selected with the "mode" parameter of the Accept and Content-Type
+// media types, e.g. "application/json; mode=canonical"
+const (
+	ExtJSONCanonical = "canonical"
+	ExtJSONRelaxed   = "relaxed"
+)
+
+// ExtJSONMode returns the Extended JSON mode requested by a media type header.
+// Relaxed is the default because it keeps plain numbers readable for ordinary JSON clients.
+func ExtJSONMode(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(params["mode"], ExtJSONCanonical) {
+			return ExtJSONCanonical
+		}
+	}
+	return ExtJSONRelaxed
+}
+
+// DecodeBody parses the request body as MongoDB Extended JSON v2 into v, so typed values such as
+// {"$oid": ...}, {"$date": ...}, {"$numberLong": ...} and {"$numberDecimal": ...} arrive as their BSON types.
+// Struct fields are matched by their json tags. A Content-Type with mode=canonical only accepts canonical input,
+// otherwise both relaxed and canonical forms are accepted.
+//
+// Decoding is strict: an empty or malformed body, a body larger than MaxBodyBytes, unknown fields and
+// values of the wrong type are all rejected, so a broken filter can never be mistaken for an empty one.
+func DecodeBody(r *http.Request, v interface{}) error {
+	return decodeBody(r, v, true)
+}
+
+// DecodeOptionalBody is DecodeBody for endpoints where the whole body may be omitted.
+// An empty body leaves v untouched; anything else is validated exactly like DecodeBody.
+func DecodeOptionalBody(r *http.Request, v interface{}) error {
+	return decodeBody(r, v, false)
+}
+
+func decodeBody(r *http.Request, v interface{}, required bool) error {
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &APIError{
+				Status: http.StatusRequestEntityTooLarge,
+				Code:   CodePayloadTooLarge,
+				Detail: fmt.Sprintf("the request body exceeds the limit of %d bytes", tooLarge.Limit),
+				Err:    err,
+			}
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if required {
+			return &ValidationError{Fields: []FieldError{{Field: "body", Message: "is required"}}}
+		}
+		return nil
+	}
+
+	canonicalOnly := ExtJSONMode(r.Header.Get("Content-Type")) == ExtJSONCanonical
+
+	var raw bson.Raw
+	if err := bson.UnmarshalExtJSON(data, canonicalOnly, &raw); err != nil {
+		return &ValidationError{Fields: []FieldError{{Field: "body", Message: "is not a valid Extended JSON document: " + err.Error()}}}
+	}
+
+	var errs fieldErrors
+	unknownFields(raw, reflect.TypeOf(v).Elem(), "", &errs)
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	decoder := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	decoder.UseJSONStructTags()
+	if err := decoder.Decode(v); err != nil {
+		field := "body"
+		var decodeErr *bson.DecodeError
+		if errors.As(err, &decodeErr) {
+			field = strings.Join(decodeErr.Keys(), ".")
+			err = decodeErr.Unwrap()
+		}
+		return &ValidationError{Fields: []FieldError{{Field: field, Message: "has the wrong type: " + err.Error()}}}
+	}
+	return nil
+}
+
+// MarshalExtJSON encodes v as Extended JSON in the mode requested by the Accept header
+func MarshalExtJSON(r *http.Request, v interface{}) ([]byte, error) {
+	canonical := ExtJSONMode(r.Header.Get("Accept")) == ExtJSONCanonical
+
+	v = responseValue(v)
+	if items, ok := v.(bson.A); ok {
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encoded, err := bson.MarshalExtJSON(item, canonical, false)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(encoded)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	}
+
+	return bson.MarshalExtJSON(v, canonical, false)
+}
+
+// WriteJSON writes v as an Extended JSON response with the given status code.
+//
+// Parameters:
+//   - w: Response writer
+//   - r: HTTP request, whose Accept header selects canonical or relaxed output
+//   - status: HTTP status code
+//   - v: Document, struct or slice of documents to encode
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	auditAffected(r, v)
+	writeExtJSON(w, r, status, "application/json", v)
+}
+
+func writeExtJSON(w http.ResponseWriter, r *http.Request, status int, mediaType string, v interface{}) {
+	body, err := MarshalExtJSON(r, v)
+	if err != nil {
+		log.Printf("[ERROR] Could not encode response for %s %s: %v", r.Method, r.URL.Path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("%s; mode=%s", mediaType, ExtJSONMode(r.Header.Get("Accept"))))
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writeExtJSONLine writes a single document followed by a newline, for NDJSON streams
+func writeExtJSONLine(w io.Writer, r *http.Request, v interface{}) error {
+	body, err := MarshalExtJSON(r, v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// responseValue converts structs into ordered documents keyed the way encoding/json would key
+// them, so responses such as *mongo.InsertOneResult keep their field names ("InsertedID",
+// "MatchedCount", ...) while their values are encoded as Extended JSON. Structs nested in fields
+// and slices are converted as well; BSON value types and maps are passed through untouched.
+func responseValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if isBSONValueType(value.Type()) {
+			return value.Interface()
+		}
+		return structDocument(value)
+	case reflect.Slice:
+		if value.Type() == reflect.TypeOf(bson.D{}) || value.Type().Elem().Kind() == reflect.Uint8 {
+			return value.Interface()
+		}
+		items := make(bson.A, value.Len())
+		for i := range items {
+			items[i] = responseValue(value.Index(i).Interface())
+		}
+		return items
+	}
+	return value.Interface()
+}
+
+func structDocument(value reflect.Value) bson.D {
+	doc := bson.D{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, options, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+			omitEmpty = strings.Contains(options, "omitempty")
+		}
+
+		fieldValue := value.Field(i)
+		if omitEmpty && fieldValue.IsZero() {
+			continue
+		}
+		doc = append(doc, bson.E{Key: name, Value: responseValue(fieldValue.Interface())})
+	}
+	return doc
+}
+
+// isBSONValueType reports whether a struct type already has a native BSON encoding, such as
+// bson.Decimal128, bson.Binary or time.Time
+func isBSONValueType(t reflect.Type) bool {
+	return t.PkgPath() == reflect.TypeOf(bson.D{}).PkgPath() || t == reflect.TypeOf(time.Time{})
+}
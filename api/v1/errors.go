@@ -0,0 +1,220 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mongo-manager/apikey"
+	"mongo-manager/auth"
+	"mongo-manager/fieldpolicy"
+	"mongo-manager/mongo"
+	"mongo-manager/protection"
+	"mongo-manager/rbac"
+	"mongo-manager/requestid"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/topology"
+)
+
+// Code is a machine-readable error code clients can branch on
+type Code string
+
+const (
+	CodeBadRequest           Code = "bad_request"
+	CodeInvalidRequest       Code = "invalid_request"
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeInvalidObjectID      Code = "invalid_object_id"
+	CodeInvalidFilter        Code = "invalid_filter"
+	CodeInvalidPagination    Code = "invalid_pagination"
+	CodeInvalidUpdate        Code = "invalid_update"
+	CodeInvalidOperation     Code = "invalid_operation"
+	CodeInvalidPipeline      Code = "invalid_pipeline"
+	CodeValidationFailed     Code = "validation_failed"
+	CodeConfirmationRequired Code = "confirmation_required"
+	CodeThresholdExceeded    Code = "threshold_exceeded"
+	CodeDuplicateKey         Code = "duplicate_key"
+	CodeNotFound             Code = "not_found"
+	CodeWriteConflict        Code = "write_conflict"
+	CodeTimeout              Code = "timeout"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotMember            Code = auth.CodeNotMember
+	CodeOrganizationRequired Code = auth.CodeOrganizationRequired
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeServiceUnavailable   Code = "service_unavailable"
+	CodeInternal             Code = "internal_error"
+)
+
+// MongoDB server error codes used for classification
+const (
+	mongoCodeUnauthorized              = 13
+	mongoCodeWriteConflict             = 112
+	mongoCodeDocumentValidationFailure = 121
+)
+
+// Problem is an RFC 7807 problem details body. Detail is always safe to show to clients;
+// the underlying error is only logged.
+type Problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Code       Code   `json:"code"`
+	Detail     string `json:"detail,omitempty"`
+	RequestID  string `json:"requestId,omitempty"`
+	FailedStep *int   `json:"failedStep,omitempty"`
+	// Errors lists the individual fields that failed request validation
+	Errors []FieldError `json:"errors,omitempty"`
+	// Impact previews the documents a refused write would have affected
+	Impact *types.ImpactPreview `json:"impact,omitempty"`
+	// Permission names the permission the caller was missing
+	Permission rbac.Permission `json:"permission,omitempty"`
+	// Field names the field a field policy kept the caller from reading or writing
+	Field string `json:"field,omitempty"`
+}
+
+// APIError is an error that already carries its HTTP classification
+type APIError struct {
+	Status     int
+	Code       Code
+	Detail     string
+	Fields     []FieldError
+	Impact     *types.ImpactPreview
+	Permission rbac.Permission
+	Field      string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Classify maps any error returned by the mongo, tenancy or driver packages onto the error taxonomy.
+// Client errors keep their message as detail, server errors get a generic detail so internals never leak.
+func Classify(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "the request is invalid", Fields: validationErr.Fields, Err: err}
+	}
+
+	var thresholdErr *protection.ThresholdError
+	if errors.As(err, &thresholdErr) {
+		return &APIError{Status: http.StatusConflict, Code: CodeThresholdExceeded, Detail: thresholdErr.Error() + ", narrow the filter or confirm as an admin", Impact: &thresholdErr.Preview, Err: err}
+	}
+
+	var permissionErr *rbac.PermissionError
+	if errors.As(err, &permissionErr) {
+		return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: permissionErr.Error(), Permission: permissionErr.Permission, Err: err}
+	}
+
+	var fieldErr *fieldpolicy.FieldError
+	if errors.As(err, &fieldErr) {
+		return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: fieldErr.Error(), Field: fieldErr.Field, Err: err}
+	}
+
+	switch {
+	case errors.Is(err, mongo.ErrInvalidObjectID), errors.Is(err, bson.ErrInvalidHex):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidObjectID, Detail: err.Error(), Err: err}
+	case errors.Is(err, mongo.ErrInvalidFilter):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidFilter, Detail: err.Error(), Err: err}
+	case errors.Is(err, mongo.ErrInvalidPagination):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidPagination, Detail: err.Error(), Err: err}
+	case errors.Is(err, mongo.ErrInvalidUpdate):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidUpdate, Detail: err.Error(), Err: err}
+	case errors.Is(err, mongo.ErrInvalidOperation):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidOperation, Detail: err.Error(), Err: err}
+	case errors.Is(err, tenancy.ErrInvalidPipeline):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidPipeline, Detail: err.Error(), Err: err}
+	case errors.Is(err, protection.ErrConfirmationRequired):
+		return &APIError{Status: http.StatusPreconditionRequired, Code: CodeConfirmationRequired, Detail: err.Error(), Err: err}
+	case errors.Is(err, tenancy.ErrForbidden), errors.Is(err, protection.ErrAdminRequired), errors.Is(err, fieldpolicy.ErrRestricted):
+		return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: err.Error(), Err: err}
+	case errors.Is(err, fieldpolicy.ErrUnavailable):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: CodeServiceUnavailable, Detail: err.Error(), Err: err}
+	case errors.Is(err, apikey.ErrInvalidKey):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidOperation, Detail: err.Error(), Err: err}
+	case errors.Is(err, apikey.ErrNotFound):
+		return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: err.Error(), Err: err}
+	case errors.Is(err, mongodriver.ErrNoDocuments):
+		return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "no document matched the request", Err: err}
+	case mongodriver.IsDuplicateKeyError(err):
+		return &APIError{Status: http.StatusConflict, Code: CodeDuplicateKey, Detail: "a document with the same unique key already exists", Err: err}
+	case hasServerErrorCode(err, mongoCodeDocumentValidationFailure):
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeValidationFailed, Detail: "the document failed the collection's schema validation", Err: err}
+	case hasServerErrorCode(err, mongoCodeWriteConflict), hasErrorLabel(err, "TransientTransactionError"):
+		return &APIError{Status: http.StatusConflict, Code: CodeWriteConflict, Detail: "the operation conflicted with a concurrent write, retry the request", Err: err}
+	case hasServerErrorCode(err, mongoCodeUnauthorized):
+		return &APIError{Status: http.StatusForbidden, Code: CodeUnauthorized, Detail: "the database rejected the operation as unauthorized", Err: err}
+	case errors.As(err, &topology.ServerSelectionError{}), mongodriver.IsNetworkError(err), errors.Is(err, mongodriver.ErrClientDisconnected):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: CodeServiceUnavailable, Detail: "the database is currently unavailable", Err: err}
+	case mongodriver.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Status: http.StatusGatewayTimeout, Code: CodeTimeout, Detail: "the operation timed out", Err: err}
+	}
+
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "an unexpected error occurred", Err: err}
+}
+
+func hasServerErrorCode(err error, code int) bool {
+	var serverErr mongodriver.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(code)
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongodriver.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// NewProblem builds the problem details body for an already classified error
+func NewProblem(r *http.Request, apiErr *APIError) Problem {
+	return Problem{
+		Type:       "urn:mongo-manager:problem:" + string(apiErr.Code),
+		Title:      http.StatusText(apiErr.Status),
+		Status:     apiErr.Status,
+		Code:       apiErr.Code,
+		Detail:     apiErr.Detail,
+		RequestID:  requestid.Get(r),
+		Errors:     apiErr.Fields,
+		Impact:     apiErr.Impact,
+		Permission: apiErr.Permission,
+		Field:      apiErr.Field,
+	}
+}
+
+// WriteProblem writes a problem details response for the given status, code and client-safe detail
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, code Code, detail string) {
+	writeProblemBody(w, r, NewProblem(r, &APIError{Status: status, Code: code, Detail: detail}))
+}
+
+// WriteError classifies err and writes it as a problem details response.
+// Server side failures are logged with the request ID so they can be correlated with the response.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := Classify(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("[ERROR] %s %s (request %s): %v", r.Method, r.URL.Path, requestid.Get(r), err)
+	}
+	writeProblemBody(w, r, NewProblem(r, apiErr))
+}
+
+func writeProblemBody(w http.ResponseWriter, r *http.Request, problem Problem) {
+	writeExtJSON(w, r, problem.Status, "application/problem+json", problem)
+}
+
+// WriteMethodNotAllowed rejects a request made with the wrong HTTP method
+func WriteMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method "+r.Method+" is not allowed on this endpoint")
+}
@@ -0,0 +1,199 @@
+package v1
+
+import (
+	"mongo-manager/audit"
+	"mongo-manager/auth"
+	"mongo-manager/mongo"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"net/http"
+)
+
+func FindOneAndUpdate(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"PUT"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetFindOneAndUpdateRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUpsert(w, r, request.Database, request.Collection, request.Upsert) {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, request.Sort); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if err := view.CheckUpdate(request.Data, request.Update); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Projection = view.Projection(request.Projection)
+	tenancy.StripDocument(request.Data)
+	request.Update = tenancy.ScopeUpdate(organizationID, request.Update)
+	request.Actor, _ = auth.GetUserID(r)
+
+	doc, err := mongo.FindOneAndUpdate(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	view.Redact(doc)
+
+	WriteJSON(w, r, http.StatusOK, types.DocumentResult{Document: doc})
+}
+
+func FindOneAndReplace(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"PUT"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetFindOneAndReplaceRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUpsert(w, r, request.Database, request.Collection, request.Upsert) {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, request.Sort); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if err := view.CheckReplacement(); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Projection = view.Projection(request.Projection)
+	tenancy.StampDocument(organizationID, request.Replacement)
+	view.StampDocument(request.Replacement)
+	request.Actor, _ = auth.GetUserID(r)
+
+	doc, err := mongo.FindOneAndReplace(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	view.Redact(doc)
+
+	WriteJSON(w, r, http.StatusOK, types.DocumentResult{Document: doc})
+}
+
+func FindOneAndDelete(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetFindOneAndDeleteRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, request.Sort); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Projection = view.Projection(request.Projection)
+	request.Actor, _ = auth.GetUserID(r)
+
+	doc, err := mongo.FindOneAndDelete(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	view.Redact(doc)
+
+	WriteJSON(w, r, http.StatusOK, types.DocumentResult{Document: doc})
+}
+
+func ReplaceOne(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"PUT"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetReplaceOneRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUpsert(w, r, request.Database, request.Collection, request.Upsert) {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+	tenancy.StampDocument(organizationID, request.Replacement)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, nil); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if err := view.CheckReplacement(); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	view.StampDocument(request.Replacement)
+	request.Actor, _ = auth.GetUserID(r)
+
+	result, err := mongo.ReplaceOne(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, result)
+}
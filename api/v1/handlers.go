@@ -1,189 +1,488 @@
 package v1
 
 import (
-	"encoding/json"
+	"log"
+	"mongo-manager/audit"
+	"mongo-manager/auth"
+	"mongo-manager/fieldpolicy"
 	"mongo-manager/mongo"
+	"mongo-manager/protection"
+	"mongo-manager/rbac"
+	"mongo-manager/tenancy"
 	"net/http"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 func GetAll(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"POST"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, request.Sort); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Projection = view.Projection(request.Projection)
+
+	page, err := mongo.GetAll(request)
+
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	for _, doc := range page.Items {
+		view.Redact(doc)
+	}
+
+	WriteJSON(w, r, http.StatusOK, page)
+}
+
+// Stream writes every document matching the filter as newline-delimited JSON instead of buffering a page
+func Stream(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, request.Sort); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Projection = view.Projection(request.Projection)
+
+	WriteNDJSON(w, r, func(emit func(bson.M) error) error {
+		return mongo.Stream(r.Context(), request, func(doc bson.M) error {
+			view.Redact(doc)
+			return emit(doc)
+		})
+	})
+}
+
+// Aggregate runs an aggregation pipeline scoped to the caller's organization and streams the results as NDJSON
+func Aggregate(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
 		return
 	}
 
-	request := GetRequest(r)
+	request, err := GetAggregateRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
 
-	docs, err := mongo.GetAll(request)
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
 
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	// Every collection the stages read needs the same read permission as the one the route checked
+	subject := auth.GetSubject(r)
+	pipeline, err := view.ScopePipeline(request.Pipeline, func(collection string) (fieldpolicy.View, error) {
+		if err := subject.Check(request.Database, collection, rbac.Read); err != nil {
+			log.Printf("[RBAC] Denied %s %s on %s.%s for %s %s", subject.Name, rbac.Read, request.Database, collection, r.Method, r.URL.Path)
+			return fieldpolicy.View{}, err
+		}
+		return ResolvePolicy(r, request.Database, collection)
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(docs)
+	pipeline, err = tenancy.ScopePipeline(organizationID, request.Database, pipeline, func(collection string) error {
+		return authorizeNamespace(organizationID, request.Database, collection)
+	})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Pipeline = pipeline
+
+	WriteNDJSON(w, r, func(emit func(bson.M) error) error {
+		return mongo.Aggregate(r.Context(), request, emit)
+	})
 }
 
 func GetOne(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"POST"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetOneRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
 		return
 	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
 
-	request := GetOneRequest(r)
-	if request.Database == "" || request.Collection == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection and filter are required"})
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, nil); err != nil {
+		WriteError(w, r, err)
 		return
 	}
+	request.Filter = view.ScopeFilter(request.Filter)
 
 	doc, err := mongo.GetOne(request)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
+	view.Redact(doc)
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(doc)
+	WriteJSON(w, r, http.StatusOK, doc)
 }
 
 func InsertOne(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"POST"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetInsertOneRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
-	request := GetInsertOneRequest(r)
-	if request.Database == "" || request.Collection == "" || request.Data == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection and data are required"})
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
 		return
 	}
+	tenancy.StampDocument(organizationID, request.Data)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckDocument(request.Data); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	view.StampDocument(request.Data)
 
 	result, err := mongo.InsertOne(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
 
 func InsertMany(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"POST"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
 		return
 	}
 
-	request := GetInsertManyRequest(r)
-	if request.Database == "" || request.Collection == "" || request.Data == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection and data are required"})
+	request, err := GetInsertManyRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	for _, doc := range request.Data {
+		if err := view.CheckDocument(doc); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		tenancy.StampDocument(organizationID, doc)
+		view.StampDocument(doc)
+	}
+
 	result, err := mongo.InsertMany(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
 
 func UpdateOne(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"PUT"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
 		return
 	}
 
-	request := GetUpdateOneRequest(r)
-	if request.Database == "" || request.Collection == "" || request.ObjectId == "" || request.Data == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection, objectId and data are required"})
+	request, err := GetUpdateOneRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUpsert(w, r, request.Database, request.Collection, request.Upsert) {
+		return
+	}
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckUpdate(request.Data, request.Update); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Scope = view.ScopeFilter(tenancy.ScopeFilter(organizationID, nil))
+	tenancy.StripDocument(request.Data)
+	request.Update = tenancy.ScopeUpdate(organizationID, request.Update)
+	request.Actor, _ = auth.GetUserID(r)
+
+	if request.DryRun {
+		preview, err := mongo.DryRunUpdateOne(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		RedactDryRun(view, &preview)
+		WriteJSON(w, r, http.StatusOK, preview)
 		return
 	}
 
 	result, err := mongo.UpdateOne(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
 
 func UpdateMany(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"PUT"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetUpdateManyRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUpsert(w, r, request.Database, request.Collection, request.Upsert) {
 		return
 	}
+	// Dry runs write nothing, so they are how callers inspect a collection-wide write before confirming it
+	if !request.DryRun {
+		if err := protection.CheckFilter(request.Filter, request.ConfirmAll, IsAdmin(r, request.Database, request.Collection)); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
 
-	request := GetUpdateManyRequest(r)
-	if request.Database == "" || request.Collection == "" || request.Data == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection and data are required"})
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, nil); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if err := view.CheckUpdate(request.Data, request.Update); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	tenancy.StripDocument(request.Data)
+	request.Update = tenancy.ScopeUpdate(organizationID, request.Update)
+	request.Actor, _ = auth.GetUserID(r)
+
+	if request.DryRun {
+		preview, err := mongo.DryRunUpdateMany(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		RedactDryRun(view, &preview)
+		WriteJSON(w, r, http.StatusOK, preview)
+		return
+	}
+
+	if err := CheckAffectedDocuments(r, request.Database, request.Collection, request.Filter, request.Collation, request.ConfirmAll); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
 	result, err := mongo.UpdateMany(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
 
 func DeleteOne(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"DELETE"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
 		return
 	}
 
-	request := GetDeleteOneRequest(r)
-	if request.Database == "" || request.Collection == "" || request.ObjectId == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database, collection and objectId are required"})
+	request, err := GetDeleteOneRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	request.Scope = view.ScopeFilter(tenancy.ScopeFilter(organizationID, nil))
+	request.Actor, _ = auth.GetUserID(r)
+
+	if request.DryRun {
+		preview, err := mongo.DryRunDeleteOne(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		WriteJSON(w, r, http.StatusOK, preview)
 		return
 	}
 
 	result, err := mongo.DeleteOne(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Error deleting document: " + err.Error()})
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
 
 func DeleteMany(w http.ResponseWriter, r *http.Request) {
 	if !VerifyMethod(r, []string{"DELETE"}) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetDeleteManyRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	// Dry runs write nothing, so they are how callers inspect a collection-wide delete before confirming it
+	if !request.DryRun {
+		if err := protection.CheckFilter(request.Filter, request.ConfirmAll, IsAdmin(r, request.Database, request.Collection)); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	view, ok := AuthorizePolicy(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if err := view.CheckQuery(request.Filter, nil); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	request.Filter = view.ScopeFilter(request.Filter)
+	request.Actor, _ = auth.GetUserID(r)
+
+	if request.DryRun {
+		preview, err := mongo.DryRunDeleteMany(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		WriteJSON(w, r, http.StatusOK, preview)
 		return
 	}
 
-	request := GetDeleteManyRequest(r)
-	if request.Database == "" || request.Collection == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database and collection are required"})
+	if err := CheckAffectedDocuments(r, request.Database, request.Collection, request.Filter, nil, request.ConfirmAll); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
 	result, err := mongo.DeleteMany(request)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	WriteJSON(w, r, http.StatusOK, result)
 }
@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"mongo-manager/audit"
+	"mongo-manager/auth"
+	"mongo-manager/mongo"
+	"mongo-manager/rbac"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"net/http"
+)
+
+// History returns a page of the recorded revisions of the document given by objectId
+func History(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, objectId, err := GetHistoryRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUnrestricted(w, r, request.Database, request.Collection) {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	page, err := mongo.ListHistory(request, objectId)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, page)
+}
+
+// Revert restores the document given by objectId to the version recorded in a revision
+func Revert(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetRevertRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUnrestricted(w, r, request.Database, request.Collection) {
+		return
+	}
+	request.Scope = tenancy.ScopeFilter(organizationID, nil)
+	request.Actor, _ = auth.GetUserID(r)
+	request.OrganizationID = organizationID
+	// The route checks update; recreating a deleted document inserts it
+	request.AuthorizeRecreate = func() error {
+		return auth.GetSubject(r).Check(request.Database, request.Collection, rbac.Insert)
+	}
+
+	doc, err := mongo.Revert(r.Context(), request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, types.DocumentResult{Document: doc})
+}
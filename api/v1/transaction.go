@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"mongo-manager/auth"
+	"mongo-manager/fieldpolicy"
+	"mongo-manager/mongo"
+	"net/http"
+)
+
+// Transaction runs an ordered list of write operations across collections of one database atomically
+func Transaction(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetTransactionRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := RequireOrganization(w, r)
+	if !ok {
+		return
+	}
+	subject := auth.GetSubject(r)
+	views := make([]fieldpolicy.View, len(request.Operations))
+	for i := range request.Operations {
+		err := AuthorizeOperation(subject, request.Database, request.Operations[i])
+		if err == nil {
+			err = CheckWriteOperation(subject, request.Database, request.Operations[i])
+		}
+		if err == nil {
+			err = ScopeWriteOperation(organizationID, request.Database, &request.Operations[i])
+		}
+		if err == nil {
+			views[i], err = ResolvePolicy(r, request.Database, request.Operations[i].Collection)
+		}
+		if err == nil {
+			err = PolicyWriteOperation(views[i], &request.Operations[i])
+		}
+		if err == nil {
+			err = CheckAffectedOperation(r, request.Database, request.Operations[i])
+		}
+		if err != nil {
+			log.Printf("Transaction step %d on %s.%s refused for organization %s: %v", i, request.Database, request.Operations[i].Collection, organizationID, err)
+			problem := NewProblem(r, Classify(err))
+			problem.FailedStep = &i
+			writeProblemBody(w, r, problem)
+			return
+		}
+	}
+
+	request.Actor, _ = auth.GetUserID(r)
+
+	result, err := mongo.RunTransaction(r.Context(), request)
+
+	var stepErr *mongo.StepError
+	if errors.As(err, &stepErr) {
+		log.Printf("Transaction on %s rolled back: %v", request.Database, err)
+		problem := NewProblem(r, Classify(stepErr.Err))
+		problem.FailedStep = &stepErr.Index
+		writeProblemBody(w, r, problem)
+		return
+	}
+
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	for _, step := range result.Steps {
+		views[step.Index].Redact(step.Document)
+	}
+
+	WriteJSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,113 @@
+package v1
+
+import (
+	"mongo-manager/audit"
+	"mongo-manager/mongo"
+	"mongo-manager/protection"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"net/http"
+)
+
+// ListTrash returns a page of the soft-deleted documents of a collection
+func ListTrash(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	audit.SetFilter(r, request.Filter)
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUnrestricted(w, r, request.Database, request.Collection) {
+		return
+	}
+	request.Filter = tenancy.ScopeFilter(organizationID, request.Filter)
+
+	page, err := mongo.ListTrash(request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, page)
+}
+
+// RestoreFromTrash moves the latest trashed version of the document given by objectId back into its collection
+func RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"POST"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetTrashRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUnrestricted(w, r, request.Database, request.Collection) {
+		return
+	}
+	request.Scope = tenancy.ScopeFilter(organizationID, nil)
+	request.OrganizationID = organizationID
+
+	doc, err := mongo.RestoreFromTrash(r.Context(), request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, types.DocumentResult{Document: doc})
+}
+
+// PurgeTrash permanently deletes the trashed versions of the document given by objectId.
+// Without objectId it empties the organization's trash for the collection, which like other
+// collection-wide deletes needs confirmAll from an admin.
+func PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if !VerifyMethod(r, []string{"DELETE"}) {
+		WriteMethodNotAllowed(w, r)
+		return
+	}
+
+	request, err := GetTrashRequest(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	organizationID, ok := AuthorizeTenant(w, r, request.Database, request.Collection)
+	if !ok {
+		return
+	}
+	if !AuthorizeUnrestricted(w, r, request.Database, request.Collection) {
+		return
+	}
+	if request.ObjectId == "" {
+		if err := protection.CheckFilter(nil, request.ConfirmAll, IsAdmin(r, request.Database, request.Collection)); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+	request.Scope = tenancy.ScopeFilter(organizationID, nil)
+
+	result, err := mongo.PurgeTrash(r.Context(), request)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	WriteJSON(w, r, http.StatusOK, result)
+}
@@ -1,13 +1,28 @@
 package v1
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
+	"mongo-manager/apikey"
+	"mongo-manager/audit"
+	"mongo-manager/auth"
+	"mongo-manager/fieldpolicy"
+	"mongo-manager/mongo"
+	"mongo-manager/protection"
+	"mongo-manager/rbac"
+	"mongo-manager/tenancy"
 	"mongo-manager/types"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// NDJSONFlushInterval is the number of documents written between explicit flushes of a stream
+const NDJSONFlushInterval = 100
+
 // VerifyMethod checks if the HTTP request method is in the list of allowed methods.
 // This function is used to ensure endpoints only accept the correct HTTP methods.
 //
@@ -31,150 +46,691 @@ func VerifyMethod(r *http.Request, allowedMethods []string) bool {
 	return false
 }
 
-func GetRequest(r *http.Request) types.Request {
+// RequireOrganization resolves the caller's organization from the request context.
+// When it is missing it writes a 401 response and returns false.
+func RequireOrganization(w http.ResponseWriter, r *http.Request) (string, bool) {
+	organizationID, ok := auth.GetOrganizationID(r)
+	if !ok || organizationID == "" {
+		WriteProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "organization ID is missing from the request")
+		return "", false
+	}
+	return organizationID, true
+}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+// AuthorizeTenant resolves the caller's organization from the request context and checks that it
+// is allowed to access the requested database and collection. On failure it writes the error
+// response itself and returns false.
+//
+// Returns:
+//   - string: The organization ID of the caller
+//   - bool: True if the caller may proceed, false if a response has already been written
+func AuthorizeTenant(w http.ResponseWriter, r *http.Request, database string, collection string) (string, bool) {
+	organizationID, ok := RequireOrganization(w, r)
+	if !ok {
+		return "", false
+	}
 
-	var requestBody types.Request
-	json.NewDecoder(r.Body).Decode(&requestBody)
+	if err := authorizeNamespace(organizationID, database, collection); err != nil {
+		log.Printf("[TENANCY] Denied organization %s access to %s.%s", organizationID, database, collection)
+		WriteError(w, r, err)
+		return "", false
+	}
 
-	return types.Request{
-		Database:   database,
-		Collection: collection,
-		Filter:     requestBody.Filter,
+	return organizationID, true
+}
+
+// authorizeNamespace applies the tenancy rules and keeps the audit log, the API keys and the trash and
+// history collections out of reach of the generic endpoints, whatever the tenancy config grants, so they
+// can't be altered through the API. Aggregations apply it to every collection their stages read as well.
+func authorizeNamespace(organizationID string, database string, collection string) error {
+	if audit.IsAuditNamespace(database, collection) || apikey.IsKeyNamespace(database, collection) ||
+		mongo.IsTrashCollection(collection) || mongo.IsHistoryCollection(collection) {
+		return tenancy.ErrForbidden
 	}
+	return tenancy.Authorize(organizationID, database, collection)
 }
 
-func GetOneRequest(r *http.Request) types.Request {
+// ScopeWriteOperation authorizes a transaction or bulk write step for the organization and
+// scopes its filter, documents and update the same way the single-operation endpoints do
+func ScopeWriteOperation(organizationID string, database string, operation *types.WriteOperation) error {
+	if err := authorizeNamespace(organizationID, database, operation.Collection); err != nil {
+		return err
+	}
+	operation.Filter = tenancy.ScopeFilter(organizationID, operation.Filter)
+	tenancy.StampDocument(organizationID, operation.Document)
+	tenancy.StampDocument(organizationID, operation.Replacement)
+	operation.Update = tenancy.ScopeUpdate(organizationID, operation.Update)
+	return nil
+}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+// ResolvePolicy returns the caller's field policy view of a collection. API keys have no role, so
+// only the policies granted to everyone apply to them.
+func ResolvePolicy(r *http.Request, database string, collection string) (fieldpolicy.View, error) {
+	var caller fieldpolicy.Caller
+	caller.UserID, _ = auth.GetUserID(r)
+	caller.OrganizationID, _ = auth.GetOrganizationID(r)
+	if !auth.IsAPIKey(r) {
+		caller.Role, _ = auth.GetRole(r)
+	}
+	return fieldpolicy.Resolve(caller, database, collection)
+}
 
-	var requestBody types.Request
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
+// AuthorizePolicy resolves the caller's field policy view of the collection. On failure it writes
+// the error response itself and returns false.
+func AuthorizePolicy(w http.ResponseWriter, r *http.Request, database string, collection string) (fieldpolicy.View, bool) {
+	view, err := ResolvePolicy(r, database, collection)
 	if err != nil {
-		return types.Request{}
+		WriteError(w, r, err)
+		return fieldpolicy.View{}, false
 	}
+	return view, true
+}
 
-	if requestBody.Filter == nil {
-		return types.Request{}
+// AuthorizeUnrestricted refuses callers a field policy restricts on the collection, for endpoints
+// that read or write whole past versions of documents: the trash and revision history. On failure
+// it writes the error response itself and returns false.
+func AuthorizeUnrestricted(w http.ResponseWriter, r *http.Request, database string, collection string) bool {
+	view, ok := AuthorizePolicy(w, r, database, collection)
+	if !ok {
+		return false
 	}
+	if view.Restricted() {
+		WriteError(w, r, fmt.Errorf("%w: the trash and revision history hold whole documents", fieldpolicy.ErrRestricted))
+		return false
+	}
+	return true
+}
 
-	return types.Request{
-		Database:   database,
-		Collection: collection,
-		Filter:     requestBody.Filter,
+// PolicyWriteOperation applies the caller's field policy view of its collection to a transaction
+// or bulk write step, the same way the single-operation endpoints do. It must run after the step
+// was scoped to the organization.
+func PolicyWriteOperation(view fieldpolicy.View, operation *types.WriteOperation) error {
+	if err := view.CheckQuery(operation.Filter, operation.Sort); err != nil {
+		return err
+	}
+	switch operation.Type {
+	case mongo.OperationInsertOne:
+		if err := view.CheckDocument(operation.Document); err != nil {
+			return err
+		}
+		view.StampDocument(operation.Document)
+	case mongo.OperationReplaceOne:
+		if err := view.CheckReplacement(); err != nil {
+			return err
+		}
+		view.StampDocument(operation.Replacement)
+	case mongo.OperationUpdateOne, mongo.OperationUpdateMany, mongo.OperationFindOneAndUpdate:
+		if err := view.CheckUpdate(nil, operation.Update); err != nil {
+			return err
+		}
+	}
+	operation.Filter = view.ScopeFilter(operation.Filter)
+	if operation.Type == mongo.OperationFindOneAndUpdate {
+		operation.Projection = view.Projection(operation.Projection)
 	}
+	return nil
 }
 
-func GetInsertOneRequest(r *http.Request) types.InsertOneRequest {
+// RedactDryRun removes the hidden fields from the changes of a dry run and masks the masked ones
+func RedactDryRun(view fieldpolicy.View, result *types.DryRunResult) {
+	for i := range result.Changes {
+		fields := []types.FieldChange{}
+		for _, change := range result.Changes[i].Fields {
+			before, visible := view.RedactValue(change.Path, change.Before)
+			if !visible {
+				continue
+			}
+			change.Before = before
+			change.After, _ = view.RedactValue(change.Path, change.After)
+			fields = append(fields, change)
+		}
+		result.Changes[i].Fields = fields
+	}
+}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+// RequirePermission wraps a handler so it only runs when the caller holds permission on the
+// database and collection named in the query string. Routes registered with rbac.PerOperation are
+// passed through and their handler checks each operation instead.
+func RequirePermission(permission rbac.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if permission != rbac.PerOperation {
+			subject := auth.GetSubject(r)
+			database, collection := r.URL.Query().Get("database"), r.URL.Query().Get("collection")
+			if err := subject.Check(database, collection, permission); err != nil {
+				log.Printf("[RBAC] Denied %s %s on %s.%s for %s %s", subject.Name, permission, database, collection, r.Method, r.URL.Path)
+				WriteError(w, r, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	var requestBody types.InsertOneRequest
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
+// AuthorizeUpsert checks that a caller asking for an upsert may also insert, since an upsert that
+// matches nothing creates a document. On failure it writes the error response and returns false.
+func AuthorizeUpsert(w http.ResponseWriter, r *http.Request, database string, collection string, upsert bool) bool {
+	if !upsert {
+		return true
+	}
+	if err := auth.GetSubject(r).Check(database, collection, rbac.Insert); err != nil {
+		WriteError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// IsAdmin reports whether the caller holds the admin permission on the collection, which lets them
+// confirm collection-wide writes
+func IsAdmin(r *http.Request, database string, collection string) bool {
+	return auth.GetSubject(r).Allowed(database, collection, rbac.Admin)
+}
+
+// AuthorizeOperation checks that the subject holds the permissions a transaction or bulk write step needs
+// on its collection: insert, update or delete depending on its type, plus insert for upserts
+func AuthorizeOperation(subject rbac.Subject, database string, operation types.WriteOperation) error {
+	var permission rbac.Permission
+	switch operation.Type {
+	case mongo.OperationInsertOne:
+		permission = rbac.Insert
+	case mongo.OperationDeleteOne, mongo.OperationDeleteMany:
+		permission = rbac.Delete
+	default:
+		permission = rbac.Update
+	}
+	if err := subject.Check(database, operation.Collection, permission); err != nil {
+		return err
+	}
+	if operation.Upsert && permission == rbac.Update {
+		return subject.Check(database, operation.Collection, rbac.Insert)
+	}
+	return nil
+}
+
+// CheckWriteOperation refuses updateMany and deleteMany steps whose filter matches every document
+// unless they are confirmed by an admin. Like protection.CheckFilter it must run before the
+// operation is scoped to the organization.
+func CheckWriteOperation(subject rbac.Subject, database string, operation types.WriteOperation) error {
+	if operation.Type != mongo.OperationUpdateMany && operation.Type != mongo.OperationDeleteMany {
+		return nil
+	}
+	admin := subject.Allowed(database, operation.Collection, rbac.Admin)
+	return protection.CheckFilter(operation.Filter, operation.ConfirmAll, admin)
+}
+
+// CheckAffectedDocuments previews how many documents an update-many or delete-many would touch
+// and refuses it when that exceeds the protection threshold. filter must already be scoped to the
+// caller's organization so only their documents are counted.
+func CheckAffectedDocuments(r *http.Request, database string, collection string, filter bson.D, collation *types.Collation, confirmAll bool) error {
+	if protection.MaxAffectedDocuments() == 0 {
+		return nil
+	}
+	preview, err := mongo.PreviewImpact(r.Context(), database, collection, filter, collation)
 	if err != nil {
-		return types.InsertOneRequest{}
+		return err
 	}
+	return protection.CheckThreshold(preview, confirmAll, IsAdmin(r, database, collection))
+}
 
-	return types.InsertOneRequest{
-		Database:   database,
-		Collection: collection,
-		Data:       requestBody.Data,
+// CheckAffectedOperation applies CheckAffectedDocuments to the updateMany and deleteMany steps of a
+// transaction or bulk write. Like CheckAffectedDocuments it must run once the step is scoped.
+func CheckAffectedOperation(r *http.Request, database string, operation types.WriteOperation) error {
+	if operation.Type != mongo.OperationUpdateMany && operation.Type != mongo.OperationDeleteMany {
+		return nil
 	}
+	return CheckAffectedDocuments(r, database, operation.Collection, operation.Filter, operation.Collation, operation.ConfirmAll)
 }
 
-func GetInsertManyRequest(r *http.Request) types.InsertManyRequest {
+// WriteNDJSON streams the documents handed to emit as newline-delimited Extended JSON.
+// The response headers are only sent with the first document, so errors raised before anything
+// was written still produce a proper status code. Errors after that point are reported as a final
+// problem details line because the status has already been sent.
+//
+// Parameters:
+//   - w: Response writer to stream to
+//   - r: HTTP request, whose context is cancelled when the client disconnects
+//   - produce: Function that calls emit once per document and returns when the source is exhausted
+func WriteNDJSON(w http.ResponseWriter, r *http.Request, produce func(emit func(bson.M) error) error) {
+	rc := http.NewResponseController(w)
+	started := false
+	written := 0
+
+	start := func() {
+		started = true
+		// Streams can legitimately outlive the server WriteTimeout, so lift the deadline for this response
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("[STREAM] Could not clear write deadline for %s %s: %v", r.Method, r.URL.Path, err)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	err := produce(func(doc bson.M) error {
+		if !started {
+			start()
+		}
+		if err := writeExtJSONLine(w, r, doc); err != nil {
+			return err
+		}
+		written++
+		if written%NDJSONFlushInterval == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+	audit.SetAffected(r, int64(written))
+
+	if r.Context().Err() != nil {
+		log.Printf("[STREAM] Client disconnected from %s %s after %d documents", r.Method, r.URL.Path, written)
+		return
+	}
 
-	var requestBody types.InsertManyRequest
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
 	if err != nil {
-		return types.InsertManyRequest{}
+		log.Printf("[STREAM] Error streaming %s %s after %d documents: %v", r.Method, r.URL.Path, written, err)
+		if !started {
+			WriteError(w, r, err)
+			return
+		}
+		writeExtJSONLine(w, r, NewProblem(r, Classify(err)))
 	}
 
-	return types.InsertManyRequest{
-		Database:   database,
-		Collection: collection,
-		Data:       requestBody.Data,
+	if !started {
+		start()
 	}
+	rc.Flush()
 }
 
-func GetUpdateOneRequest(r *http.Request) types.UpdateOneRequest {
+// GetRequest parses a find request for get-all and stream. The body is optional, since listing a
+// whole collection is legitimate, but a body that is present must be valid: a malformed filter is
+// rejected instead of silently matching every document.
+func GetRequest(r *http.Request) (types.Request, error) {
+	var request types.Request
+	if err := DecodeOptionalBody(r, &request); err != nil {
+		return types.Request{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.nonNegative("limit", request.Limit)
+	errs.nonNegative("skip", request.Skip)
+	errs.inspect("filter", request.Filter)
+	errs.inspect("sort", request.Sort)
+	errs.inspect("projection", request.Projection)
+	return request, errs.err()
+}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
-	objectId := r.URL.Query().Get("objectId")
+func GetOneRequest(r *http.Request) (types.Request, error) {
+	var requestBody types.Request
+	if err := DecodeBody(r, &requestBody); err != nil {
+		return types.Request{}, err
+	}
 
-	var requestBody types.UpdateOneRequest
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
-	if err != nil {
-		return types.UpdateOneRequest{}
+	request := types.Request{
+		Database:   r.URL.Query().Get("database"),
+		Collection: r.URL.Query().Get("collection"),
+		Filter:     requestBody.Filter,
 	}
 
-	request := types.UpdateOneRequest{
-		Database:   database,
-		Collection: collection,
-		ObjectId:   objectId,
-		Data:       requestBody.Data,
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("filter", request.Filter != nil)
+	errs.inspect("filter", request.Filter)
+	return request, errs.err()
+}
+
+func GetInsertOneRequest(r *http.Request) (types.InsertOneRequest, error) {
+	var request types.InsertOneRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.InsertOneRequest{}, err
 	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
 
-	log.Printf("Request: %+v", request)
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("data", request.Data != nil)
+	return request, errs.err()
+}
+
+func GetInsertManyRequest(r *http.Request) (types.InsertManyRequest, error) {
+	var request types.InsertManyRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.InsertManyRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("data", len(request.Data) > 0)
+	for i, doc := range request.Data {
+		if doc == nil {
+			errs.add(fmt.Sprintf("data[%d]", i), "must be a document")
+		}
+	}
+	return request, errs.err()
+}
 
-	return request
+func GetUpdateOneRequest(r *http.Request) (types.UpdateOneRequest, error) {
+	var request types.UpdateOneRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.UpdateOneRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+	request.ObjectId = r.URL.Query().Get("objectId")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("objectId", request.ObjectId != "")
+	errs.requireUpdate(request.Data, request.Update)
+	errs.inspectUpdate("update", request.Update)
+	errs.inspect("arrayFilters", request.ArrayFilters)
+	request.DryRun = errs.boolQuery(r, "dryRun")
+	return request, errs.err()
+}
 
+func GetUpdateManyRequest(r *http.Request) (types.UpdateManyRequest, error) {
+	var request types.UpdateManyRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.UpdateManyRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.requireUpdate(request.Data, request.Update)
+	errs.inspect("filter", request.Filter)
+	errs.inspectUpdate("update", request.Update)
+	errs.inspect("arrayFilters", request.ArrayFilters)
+	request.DryRun = errs.boolQuery(r, "dryRun")
+	return request, errs.err()
 }
 
-func GetUpdateManyRequest(r *http.Request) types.UpdateManyRequest {
+func GetDeleteOneRequest(r *http.Request) (types.DeleteOneRequest, error) {
+	request := types.DeleteOneRequest{
+		Database:   r.URL.Query().Get("database"),
+		Collection: r.URL.Query().Get("collection"),
+		ObjectId:   r.URL.Query().Get("objectId"),
+	}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("objectId", request.ObjectId != "")
+	request.DryRun = errs.boolQuery(r, "dryRun")
+	return request, errs.err()
+}
 
-	var requestBody types.UpdateManyRequest
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
-	if err != nil {
-		return types.UpdateManyRequest{}
+func GetDeleteManyRequest(r *http.Request) (types.DeleteManyRequest, error) {
+	var request types.DeleteManyRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.DeleteManyRequest{}, err
 	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.inspect("filter", request.Filter)
+	request.DryRun = errs.boolQuery(r, "dryRun")
+	return request, errs.err()
+}
 
-	return types.UpdateManyRequest{
-		Database:   database,
-		Collection: collection,
-		Filter:     requestBody.Filter,
-		Data:       requestBody.Data,
+func GetAggregateRequest(r *http.Request) (types.AggregateRequest, error) {
+	var request types.AggregateRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.AggregateRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("pipeline", request.Pipeline != nil)
+	errs.inspect("pipeline", request.Pipeline)
+	errs.nonNegative("maxTimeMS", request.MaxTimeMS)
+	return request, errs.err()
+}
+
+func GetFindOneAndUpdateRequest(r *http.Request) (types.FindOneAndUpdateRequest, error) {
+	var request types.FindOneAndUpdateRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.FindOneAndUpdateRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.requireUpdate(request.Data, request.Update)
+	errs.inspect("filter", request.Filter)
+	errs.inspectUpdate("update", request.Update)
+	errs.inspect("arrayFilters", request.ArrayFilters)
+	errs.inspect("sort", request.Sort)
+	errs.inspect("projection", request.Projection)
+	return request, errs.err()
+}
+
+func GetFindOneAndReplaceRequest(r *http.Request) (types.FindOneAndReplaceRequest, error) {
+	var request types.FindOneAndReplaceRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.FindOneAndReplaceRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("replacement", request.Replacement != nil)
+	errs.inspect("filter", request.Filter)
+	errs.inspect("sort", request.Sort)
+	errs.inspect("projection", request.Projection)
+	return request, errs.err()
+}
+
+func GetFindOneAndDeleteRequest(r *http.Request) (types.FindOneAndDeleteRequest, error) {
+	var request types.FindOneAndDeleteRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.FindOneAndDeleteRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.inspect("filter", request.Filter)
+	errs.inspect("sort", request.Sort)
+	errs.inspect("projection", request.Projection)
+	return request, errs.err()
+}
+
+func GetReplaceOneRequest(r *http.Request) (types.ReplaceOneRequest, error) {
+	var request types.ReplaceOneRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.ReplaceOneRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("replacement", request.Replacement != nil)
+	errs.inspect("filter", request.Filter)
+	return request, errs.err()
+}
+
+func GetTransactionRequest(r *http.Request) (types.TransactionRequest, error) {
+	var request types.TransactionRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.TransactionRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+
+	var errs fieldErrors
+	errs.database("database", request.Database)
+	errs.require("operations", len(request.Operations) > 0)
+	for i, operation := range request.Operations {
+		errs.require(fmt.Sprintf("operations[%d].type", i), operation.Type != "")
+		errs.collection(fmt.Sprintf("operations[%d].collection", i), request.Database, operation.Collection)
+		errs.inspectOperation(fmt.Sprintf("operations[%d]", i), operation)
+	}
+	return request, errs.err()
+}
+
+func GetBulkWriteRequest(r *http.Request) (types.BulkWriteRequest, error) {
+	var request types.BulkWriteRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.BulkWriteRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("operations", len(request.Operations) > 0)
+	for i, operation := range request.Operations {
+		errs.require(fmt.Sprintf("operations[%d].type", i), operation.Type != "")
+		if operation.Collection != "" && operation.Collection != request.Collection {
+			errs.add(fmt.Sprintf("operations[%d].collection", i), "must be omitted or match the collection query parameter")
+		}
+		errs.inspectOperation(fmt.Sprintf("operations[%d]", i), operation)
 	}
+	request.DryRun = errs.boolQuery(r, "dryRun")
+	return request, errs.err()
 }
 
-func GetDeleteOneRequest(r *http.Request) types.DeleteOneRequest {
+// GetTrashRequest parses a trash restore or purge request. objectId is optional here and the body,
+// which may only carry confirmAll, may be omitted.
+func GetTrashRequest(r *http.Request) (types.TrashRequest, error) {
+	var request types.TrashRequest
+	if err := DecodeOptionalBody(r, &request); err != nil {
+		return types.TrashRequest{}, err
+	}
+	request.Database = r.URL.Query().Get("database")
+	request.Collection = r.URL.Query().Get("collection")
+	request.ObjectId = r.URL.Query().Get("objectId")
+
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	return request, errs.err()
+}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+// GetHistoryRequest parses a revision history listing. objectId selects the document and the optional
+// body pages through its revisions like a get-all request.
+func GetHistoryRequest(r *http.Request) (types.Request, string, error) {
+	request, err := GetRequest(r)
 	objectId := r.URL.Query().Get("objectId")
 
-	return types.DeleteOneRequest{
-		Database:   database,
-		Collection: collection,
-		ObjectId:   objectId,
+	var errs fieldErrors
+	if validationErr, ok := err.(*ValidationError); ok {
+		errs = validationErr.Fields
+	} else if err != nil {
+		return types.Request{}, "", err
 	}
+	errs.require("objectId", objectId != "")
+	return request, objectId, errs.err()
 }
 
-func GetDeleteManyRequest(r *http.Request) types.DeleteManyRequest {
+func GetRevertRequest(r *http.Request) (types.RevertRequest, error) {
+	request := types.RevertRequest{
+		Database:   r.URL.Query().Get("database"),
+		Collection: r.URL.Query().Get("collection"),
+		ObjectId:   r.URL.Query().Get("objectId"),
+	}
 
-	database := r.URL.Query().Get("database")
-	collection := r.URL.Query().Get("collection")
+	var errs fieldErrors
+	errs.namespace(request.Database, request.Collection)
+	errs.require("objectId", request.ObjectId != "")
+	revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+	if err != nil || revision < 1 {
+		errs.add("revision", "must be a positive revision number")
+	}
+	request.Revision = revision
+	return request, errs.err()
+}
 
-	var requestBody types.DeleteManyRequest
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
-	if err != nil {
-		return types.DeleteManyRequest{}
+// GetAuditQueryRequest parses the audit log search criteria, which all come from the query string.
+// from and to are RFC 3339 timestamps.
+func GetAuditQueryRequest(r *http.Request) (types.AuditQueryRequest, error) {
+	query := r.URL.Query()
+	request := types.AuditQueryRequest{
+		UserID:         query.Get("userId"),
+		OrganizationID: query.Get("organizationId"),
+		Database:       query.Get("database"),
+		Collection:     query.Get("collection"),
+		Cursor:         query.Get("cursor"),
 	}
 
-	return types.DeleteManyRequest{
-		Database:   database,
-		Collection: collection,
-		Filter:     requestBody.Filter,
+	var errs fieldErrors
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"from", &request.From}, {"to", &request.To}} {
+		if value := query.Get(bound.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs.add(bound.name, "must be an RFC 3339 timestamp")
+				continue
+			}
+			*bound.target = &parsed
+		}
+	}
+	if request.From != nil && request.To != nil && !request.From.Before(*request.To) {
+		errs.add("to", "must be after from")
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs.add("limit", "must be an integer")
+		}
+		errs.nonNegative("limit", limit)
+		request.Limit = limit
+	}
+	return request, errs.err()
+}
+
+// GetIssueAPIKeyRequest parses and validates an API key issue request
+func GetIssueAPIKeyRequest(r *http.Request) (types.IssueAPIKeyRequest, error) {
+	var request types.IssueAPIKeyRequest
+	if err := DecodeBody(r, &request); err != nil {
+		return types.IssueAPIKeyRequest{}, err
+	}
+
+	var errs fieldErrors
+	errs.require("name", request.Name != "")
+	errs.require("databases", len(request.Databases) > 0)
+	for database, collections := range request.Databases {
+		for collection, permissions := range collections {
+			for i, permission := range permissions {
+				if !rbac.ValidPermission(rbac.Permission(permission)) {
+					errs.add(fmt.Sprintf("databases.%s.%s[%d]", database, collection, i), "%q is not a permission", permission)
+				}
+			}
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		errs.add("expiresAt", "must be in the future")
+	}
+	return request, errs.err()
+}
+
+// GetRotateAPIKeyRequest parses the key ID and the optional gracePeriod, a duration such as "1h",
+// of an API key rotation
+func GetRotateAPIKeyRequest(r *http.Request) (string, time.Duration, error) {
+	id := r.URL.Query().Get("id")
+	gracePeriod := apikey.DefaultRotationGracePeriod
+
+	var errs fieldErrors
+	errs.require("id", id != "")
+	if value := r.URL.Query().Get("gracePeriod"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			errs.add("gracePeriod", "must be a non-negative duration such as \"1h\"")
+		}
+		gracePeriod = parsed
 	}
+	return id, gracePeriod, errs.err()
 }
@@ -0,0 +1,261 @@
+package v1
+
+import (
+	"fmt"
+	"log"
+	"mongo-manager/inspector"
+	"mongo-manager/types"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DefaultMaxBodyBytes is the request body limit used when MAX_BODY_BYTES is not set.
+// It matches MongoDB's 16 MiB document limit so any single document can be sent.
+const DefaultMaxBodyBytes int64 = 16 << 20
+
+// MaxBodyBytes is the largest request body accepted, configured with MAX_BODY_BYTES
+var MaxBodyBytes = loadMaxBodyBytes()
+
+// Limits on MongoDB names, see https://www.mongodb.com/docs/manual/reference/limits/#naming-restrictions
+const (
+	maxDatabaseNameLength  = 63
+	maxNamespaceLength     = 255
+	invalidDatabaseNameSet = "/\\. \"$*<>:|?\x00"
+)
+
+// queryParameters are request fields that come from the URL and are rejected when sent in the body
+var queryParameters = map[string]bool{"database": true, "collection": true, "objectId": true, "dryRun": true}
+
+func loadMaxBodyBytes() int64 {
+	configured := os.Getenv("MAX_BODY_BYTES")
+	if configured == "" {
+		return DefaultMaxBodyBytes
+	}
+	limit, err := strconv.ParseInt(configured, 10, 64)
+	if err != nil || limit <= 0 {
+		log.Printf("Warning: ignoring invalid MAX_BODY_BYTES %q, using %d", configured, DefaultMaxBodyBytes)
+		return DefaultMaxBodyBytes
+	}
+	return limit
+}
+
+// FieldError describes a problem with a single request field. Field is the path of the field in
+// the request, e.g. "filter" or "operations[2].type", or the name of a query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request is malformed. It lists every field that failed so
+// clients can fix them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// fieldErrors collects field errors while a request is validated
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *fieldErrors) require(field string, present bool) {
+	if !present {
+		e.add(field, "is required")
+	}
+}
+
+func (e *fieldErrors) nonNegative(field string, value int64) {
+	if value < 0 {
+		e.add(field, "must not be negative")
+	}
+}
+
+// boolQuery parses an optional boolean query parameter such as dryRun
+func (e *fieldErrors) boolQuery(r *http.Request, name string) bool {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.add(name, "must be true or false")
+	}
+	return parsed
+}
+
+// requireUpdate checks that an update request carries either shorthand data or an update document
+func (e *fieldErrors) requireUpdate(data map[string]interface{}, update interface{}) {
+	if data == nil && update == nil {
+		e.add("update", "data or update is required")
+	}
+}
+
+// inspect runs a filter, sort, projection or pipeline through the query inspector and reports the
+// offending path within field, such as "filter.$or[1].$where"
+func (e *fieldErrors) inspect(field string, value interface{}) {
+	e.violation(field, inspector.Inspect(value))
+}
+
+// inspectUpdate runs an update document or pipeline through the query inspector
+func (e *fieldErrors) inspectUpdate(field string, update interface{}) {
+	e.violation(field, inspector.InspectUpdate(update))
+}
+
+// inspectOperation runs the filter, update, array filters, sort and projection of a transaction or
+// bulk write step through the query inspector
+func (e *fieldErrors) inspectOperation(field string, operation types.WriteOperation) {
+	e.inspect(field+".filter", operation.Filter)
+	e.inspectUpdate(field+".update", operation.Update)
+	e.inspect(field+".arrayFilters", operation.ArrayFilters)
+	e.inspect(field+".sort", operation.Sort)
+	e.inspect(field+".projection", operation.Projection)
+}
+
+func (e *fieldErrors) violation(field string, err error) {
+	violation, ok := err.(*inspector.Violation)
+	if !ok {
+		return
+	}
+	switch {
+	case violation.Path == "":
+	case strings.HasPrefix(violation.Path, "["):
+		field += violation.Path
+	default:
+		field += "." + violation.Path
+	}
+	e.add(field, "%s", violation.Message)
+}
+
+// database checks a database name against MongoDB's naming rules
+func (e *fieldErrors) database(field string, name string) {
+	switch {
+	case name == "":
+		e.add(field, "is required")
+	case len(name) > maxDatabaseNameLength:
+		e.add(field, "must be at most %d bytes long", maxDatabaseNameLength)
+	case strings.ContainsAny(name, invalidDatabaseNameSet):
+		e.add(field, "must not contain any of / \\ . \" $ * < > : | ? spaces or null characters")
+	}
+}
+
+// collection checks a collection name against MongoDB's naming rules. The database name is
+// needed because the limit applies to the full "database.collection" namespace.
+func (e *fieldErrors) collection(field string, database string, name string) {
+	switch {
+	case name == "":
+		e.add(field, "is required")
+	case strings.ContainsAny(name, "$\x00"):
+		e.add(field, "must not contain $ or null characters")
+	case strings.HasPrefix(name, "system."):
+		e.add(field, "must not start with the reserved \"system.\" prefix")
+	case len(database)+1+len(name) > maxNamespaceLength:
+		e.add(field, "the namespace %s.%s exceeds %d bytes", database, name, maxNamespaceLength)
+	}
+}
+
+// namespace validates the database and collection query parameters
+func (e *fieldErrors) namespace(database string, collection string) {
+	e.database("database", database)
+	e.collection("collection", database, collection)
+}
+
+// err returns the collected errors as a *ValidationError, or nil when there are none
+func (e fieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: e}
+}
+
+// unknownFields reports every key in doc that is not a field of the struct type t, descending
+// into nested structs and slices of structs. Free-form fields such as filters and documents are
+// not inspected. Query parameters are reported when they appear at the top level of the body.
+func unknownFields(doc bson.Raw, t reflect.Type, path string, errs *fieldErrors) {
+	fields := jsonFields(t)
+	elements, _ := doc.Elements()
+	for _, element := range elements {
+		key := element.Key()
+		field := key
+		if path != "" {
+			field = path + "." + key
+		}
+
+		if path == "" && queryParameters[key] {
+			errs.add(field, "must be passed as a query parameter, not in the body")
+			continue
+		}
+		fieldType, ok := fields[key]
+		if !ok {
+			errs.add(field, "is not a recognized field")
+			continue
+		}
+
+		fieldType = structType(fieldType)
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			if nested, ok := element.Value().DocumentOK(); ok {
+				unknownFields(nested, fieldType, field, errs)
+			}
+		case fieldType.Kind() == reflect.Slice && structType(fieldType.Elem()).Kind() == reflect.Struct:
+			items, ok := element.Value().ArrayOK()
+			if !ok {
+				continue
+			}
+			values, _ := items.Values()
+			for i, item := range values {
+				if nested, ok := item.DocumentOK(); ok {
+					unknownFields(nested, structType(fieldType.Elem()), fmt.Sprintf("%s[%d]", field, i), errs)
+				}
+			}
+		}
+	}
+}
+
+// jsonFields maps the json names of a struct's exported fields to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// structType dereferences pointers and hides BSON value types such as bson.E, which are
+// free-form values rather than request structures
+func structType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct && isBSONValueType(t) {
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+	return t
+}
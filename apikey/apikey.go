@@ -0,0 +1,252 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/auth"
+	"mongo-manager/mongo"
+	"mongo-manager/rbac"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Header is the HTTP header machine clients send their API key in
+const Header = auth.APIKeyHeader
+
+// Prefix starts every API key, so keys are recognizable in configs and secret scanners
+const Prefix = "mm_"
+
+// DefaultRotationGracePeriod is how long a rotated key keeps working when no grace period is given
+const DefaultRotationGracePeriod = 24 * time.Hour
+
+// DefaultDatabase and DefaultCollection name where keys are stored when API_KEYS_DATABASE and
+// API_KEYS_COLLECTION are not set
+const (
+	DefaultDatabase   = "auth"
+	DefaultCollection = "api_keys"
+)
+
+var (
+	// ErrInvalidKey is returned for keys that are malformed, unknown, revoked or expired
+	ErrInvalidKey = auth.ErrInvalidAPIKey
+	// ErrNotFound is returned when a key ID doesn't belong to the organization
+	ErrNotFound = errors.New("API key not found")
+)
+
+// Key is a stored API key. Only the SHA-256 hash of the secret is kept; the full key is returned
+// once, when it is issued. Grants lists what the key may do, in the same shape as an RBAC role.
+type Key struct {
+	ID             string     `bson:"_id" json:"id"`
+	Hash           string     `bson:"hash" json:"-"`
+	Name           string     `bson:"name" json:"name"`
+	OrganizationID string     `bson:"organizationId" json:"organizationId"`
+	Grants         rbac.Role  `bson:"grants" json:"grants"`
+	CreatedBy      string     `bson:"createdBy" json:"createdBy"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	// RotatedFrom is the ID of the key this one replaced
+	RotatedFrom string `bson:"rotatedFrom,omitempty" json:"rotatedFrom,omitempty"`
+}
+
+// Subject returns the RBAC subject for the key's grants
+func (k Key) Subject() rbac.Subject {
+	return rbac.Subject{Name: fmt.Sprintf("API key %q", k.ID), Grants: k.Grants}
+}
+
+// UserID is the identity requests made with the key are attributed to
+func (k Key) UserID() string {
+	return "apikey:" + k.ID
+}
+
+// Issued is returned when a key is issued or rotated. Secret is the full key and is never
+// shown again.
+type Issued struct {
+	Secret string `json:"key"`
+	Key    Key    `json:"metadata"`
+}
+
+var database, collection = DefaultDatabase, DefaultCollection
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if configured := os.Getenv("API_KEYS_DATABASE"); configured != "" {
+		database = configured
+	}
+	if configured := os.Getenv("API_KEYS_COLLECTION"); configured != "" {
+		collection = configured
+	}
+}
+
+// IsKeyNamespace reports whether database and collection hold the API keys, which the generic
+// endpoints, and the aggregation stages reading other collections, must never read or modify
+func IsKeyNamespace(db, coll string) bool {
+	return db == database && coll == collection
+}
+
+func keys() *mongodriver.Collection {
+	return mongo.Client.Database(database).Collection(collection)
+}
+
+// Issue creates a key for the organization with the given grants
+func Issue(ctx context.Context, organizationID, name string, grants rbac.Role, expiresAt *time.Time, createdBy string) (Issued, error) {
+	return issue(ctx, Key{
+		Name:           name,
+		OrganizationID: organizationID,
+		Grants:         grants,
+		CreatedBy:      createdBy,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+func issue(ctx context.Context, key Key) (Issued, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return Issued{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return Issued{}, err
+	}
+
+	key.ID = hex.EncodeToString(id)
+	key.CreatedAt = time.Now().UTC()
+	full := Prefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hash(full)
+
+	if _, err := keys().InsertOne(ctx, key); err != nil {
+		log.Printf("Error storing API key: %v", err)
+		return Issued{}, err
+	}
+	return Issued{Secret: full, Key: key}, nil
+}
+
+// List returns the organization's keys, newest first, including revoked and expired ones
+func List(ctx context.Context, organizationID string) ([]Key, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := keys().Find(ctx, bson.D{{Key: "organizationId", Value: organizationID}}, opts)
+	if err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		return nil, err
+	}
+	result := []Key{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Revoke disables a key of the organization immediately
+func Revoke(ctx context.Context, organizationID, id string) (Key, error) {
+	now := time.Now().UTC()
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "organizationId", Value: organizationID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: now}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var key Key
+	if err := keys().FindOneAndUpdate(ctx, filter, update, opts).Decode(&key); err != nil {
+		if errors.Is(err, mongodriver.ErrNoDocuments) {
+			return Key{}, ErrNotFound
+		}
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// Rotate issues a replacement for a key with the same name, grants and expiry, and lets the old
+// key keep working for gracePeriod so clients can switch over without downtime
+func Rotate(ctx context.Context, organizationID, id string, gracePeriod time.Duration, rotatedBy string) (Issued, error) {
+	var old Key
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "organizationId", Value: organizationID}}
+	if err := keys().FindOne(ctx, filter).Decode(&old); err != nil {
+		if errors.Is(err, mongodriver.ErrNoDocuments) {
+			return Issued{}, ErrNotFound
+		}
+		return Issued{}, err
+	}
+	if !old.active(time.Now()) {
+		return Issued{}, fmt.Errorf("%w: key %s is revoked or expired", ErrInvalidKey, id)
+	}
+
+	issued, err := issue(ctx, Key{
+		Name:           old.Name,
+		OrganizationID: old.OrganizationID,
+		Grants:         old.Grants,
+		CreatedBy:      rotatedBy,
+		ExpiresAt:      old.ExpiresAt,
+		RotatedFrom:    old.ID,
+	})
+	if err != nil {
+		return Issued{}, err
+	}
+
+	retireAt := time.Now().UTC().Add(gracePeriod)
+	if old.ExpiresAt == nil || retireAt.Before(*old.ExpiresAt) {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: retireAt}}}}
+		if _, err := keys().UpdateOne(ctx, filter, update); err != nil {
+			log.Printf("Error retiring rotated API key %s: %v", id, err)
+			return Issued{}, err
+		}
+	}
+	return issued, nil
+}
+
+// Identify authenticates a key for auth.APIKeyMiddleware; main registers it with auth.SetKeyAuthenticator
+func Identify(ctx context.Context, full string) (auth.KeyIdentity, error) {
+	key, err := Authenticate(ctx, full)
+	if err != nil {
+		return auth.KeyIdentity{}, err
+	}
+	return auth.KeyIdentity{ID: key.ID, OrganizationID: key.OrganizationID, UserID: key.UserID(), Subject: key.Subject()}, nil
+}
+
+// Authenticate resolves a full API key to its stored key, refusing unknown, revoked and expired keys
+func Authenticate(ctx context.Context, full string) (Key, error) {
+	rest, ok := strings.CutPrefix(full, Prefix)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok || id == "" {
+		return Key{}, ErrInvalidKey
+	}
+
+	var key Key
+	if err := keys().FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&key); err != nil {
+		if errors.Is(err, mongodriver.ErrNoDocuments) {
+			return Key{}, ErrInvalidKey
+		}
+		return Key{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(full)), []byte(key.Hash)) != 1 || !key.active(time.Now()) {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func (k Key) active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// hash returns the stored form of a key. Keys carry 256 random bits, so a plain SHA-256 is enough
+// and keeps lookups cheap, unlike the slow hashes needed for passwords.
+func hash(full string) string {
+	sum := sha256.Sum256([]byte(full))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"mongo-manager/auth"
+	"mongo-manager/requestid"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DefaultDatabase and DefaultCollection name the audit log when AUDIT_DATABASE and
+// AUDIT_COLLECTION are not set
+const (
+	DefaultDatabase   = "audit"
+	DefaultCollection = "audit_log"
+)
+
+// Redacted replaces every literal value of a filter recorded in the audit log
+const Redacted = "[redacted]"
+
+// Record is one audited v1 call. Filter is the filter sent by the client with every value
+// replaced by Redacted, and AffectedCount is the number of documents returned or written,
+// when the endpoint reports one.
+type Record struct {
+	ID             bson.ObjectID `bson:"_id" json:"_id"`
+	Timestamp      time.Time     `bson:"timestamp" json:"timestamp"`
+	RequestID      string        `bson:"requestId" json:"requestId"`
+	UserID         string        `bson:"userId" json:"userId"`
+	OrganizationID string        `bson:"organizationId" json:"organizationId"`
+	Method         string        `bson:"method" json:"method"`
+	Endpoint       string        `bson:"endpoint" json:"endpoint"`
+	Database       string        `bson:"database,omitempty" json:"database,omitempty"`
+	Collection     string        `bson:"collection,omitempty" json:"collection,omitempty"`
+	ObjectID       string        `bson:"objectId,omitempty" json:"objectId,omitempty"`
+	Filter         bson.D        `bson:"filter,omitempty" json:"filter,omitempty"`
+	AffectedCount  *int64        `bson:"affectedCount,omitempty" json:"affectedCount,omitempty"`
+	Status         int           `bson:"status" json:"status"`
+	LatencyMs      int64         `bson:"latencyMs" json:"latencyMs"`
+}
+
+// RecordKey is the context key for the record of the request being audited
+type RecordKey struct{}
+
+// Config names the collection records are appended to and, optionally, a file that receives a
+// copy of every record as a JSON line. AdminOrganizations lists the organizations whose admins may
+// search the records of every organization.
+type Config struct {
+	Database           string
+	Collection         string
+	File               string
+	AdminOrganizations []string
+}
+
+var config = Config{Database: DefaultDatabase, Collection: DefaultCollection}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if database := os.Getenv("AUDIT_DATABASE"); database != "" {
+		config.Database = database
+	}
+	if collection := os.Getenv("AUDIT_COLLECTION"); collection != "" {
+		config.Collection = collection
+	}
+	config.File = os.Getenv("AUDIT_LOG_FILE")
+	for _, organizationID := range strings.Split(os.Getenv("AUDIT_ADMIN_ORGANIZATIONS"), ",") {
+		if organizationID = strings.TrimSpace(organizationID); organizationID != "" {
+			config.AdminOrganizations = append(config.AdminOrganizations, organizationID)
+		}
+	}
+
+	start()
+}
+
+// IsAuditNamespace reports whether database and collection hold the audit log, which the
+// generic endpoints must never read or modify
+func IsAuditNamespace(database, collection string) bool {
+	return database == config.Database && collection == config.Collection
+}
+
+// CanSearchAllOrganizations reports whether members of the organization may search the audit
+// records of every organization rather than only their own
+func CanSearchAllOrganizations(organizationID string) bool {
+	for _, admin := range config.AdminOrganizations {
+		if organizationID == admin {
+			return true
+		}
+	}
+	return false
+}
+
+// statusWriter wraps http.ResponseWriter to capture the status code
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.statusCode = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap exposes the underlying ResponseWriter so http.ResponseController keeps working for streams
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Middleware records every request in the audit log once the handler has finished. It must run
+// inside the authentication middleware so the caller's user and organization are known; handlers
+// add the filter and affected count with SetFilter and SetAffected.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		record := &Record{
+			ID:         bson.NewObjectID(),
+			Timestamp:  startTime.UTC(),
+			RequestID:  requestid.Get(r),
+			Method:     r.Method,
+			Endpoint:   r.URL.Path,
+			Database:   r.URL.Query().Get("database"),
+			Collection: r.URL.Query().Get("collection"),
+			ObjectID:   r.URL.Query().Get("objectId"),
+		}
+		record.UserID, _ = auth.GetUserID(r)
+		record.OrganizationID, _ = auth.GetOrganizationID(r)
+
+		sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), RecordKey{}, record)))
+
+		record.Status = sw.statusCode
+		record.LatencyMs = time.Since(startTime).Milliseconds()
+		enqueue(*record)
+	})
+}
+
+// SetFilter records the redacted form of the filter a request was sent with. It must be given the
+// client's filter, before tenancy scoping adds the discriminator condition.
+func SetFilter(r *http.Request, filter bson.D) {
+	if record, ok := r.Context().Value(RecordKey{}).(*Record); ok {
+		record.Filter = RedactFilter(filter)
+	}
+}
+
+// SetAffected records the number of documents a request returned or wrote
+func SetAffected(r *http.Request, count int64) {
+	if record, ok := r.Context().Value(RecordKey{}).(*Record); ok {
+		record.AffectedCount = &count
+	}
+}
+
+// RedactFilter keeps the field names and operators of a filter, so the audit log shows what was
+// queried, and replaces every value with Redacted so no document data ends up in the log
+func RedactFilter(filter bson.D) bson.D {
+	if filter == nil {
+		return nil
+	}
+	redacted := make(bson.D, 0, len(filter))
+	for _, elem := range filter {
+		redacted = append(redacted, bson.E{Key: elem.Key, Value: redactValue(elem.Key, elem.Value)})
+	}
+	return redacted
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return RedactFilter(v)
+	case bson.A:
+		// Operands of $and, $or and $nor are filters themselves; other arrays, such as the
+		// operand of $in, are values
+		if key != "$and" && key != "$or" && key != "$nor" {
+			return Redacted
+		}
+		items := make(bson.A, 0, len(v))
+		for _, item := range v {
+			items = append(items, redactValue("", item))
+		}
+		return items
+	}
+	return Redacted
+}
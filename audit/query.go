@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"mongo-manager/mongo"
+	"mongo-manager/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Query returns one page of audit records matching every criterion set in the request, newest first
+func Query(request types.AuditQueryRequest) (types.Page, error) {
+	filter := bson.D{}
+	if request.UserID != "" {
+		filter = append(filter, bson.E{Key: "userId", Value: request.UserID})
+	}
+	if request.OrganizationID != "" {
+		filter = append(filter, bson.E{Key: "organizationId", Value: request.OrganizationID})
+	}
+	if request.Database != "" {
+		filter = append(filter, bson.E{Key: "database", Value: request.Database})
+	}
+	if request.Collection != "" {
+		filter = append(filter, bson.E{Key: "collection", Value: request.Collection})
+	}
+
+	timeRange := bson.D{}
+	if request.From != nil {
+		timeRange = append(timeRange, bson.E{Key: "$gte", Value: *request.From})
+	}
+	if request.To != nil {
+		timeRange = append(timeRange, bson.E{Key: "$lt", Value: *request.To})
+	}
+	if len(timeRange) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timeRange})
+	}
+
+	return mongo.GetAll(types.Request{
+		Database:   config.Database,
+		Collection: config.Collection,
+		Filter:     filter,
+		Sort:       bson.D{{Key: "timestamp", Value: -1}},
+		Limit:      request.Limit,
+		Cursor:     request.Cursor,
+	})
+}
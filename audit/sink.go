@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"mongo-manager/mongo"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// QueueSize is the number of records buffered for the background writer. When the queue is full
+// records are written synchronously instead of being dropped.
+const QueueSize = 1024
+
+// WriteTimeout bounds how long writing a single record may take
+const WriteTimeout = 5 * time.Second
+
+// Sink persists audit records. Sinks only ever append.
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+}
+
+// MongoSink appends records to a collection
+type MongoSink struct {
+	collection *mongodriver.Collection
+}
+
+// NewMongoSink returns a sink writing to the given collection and makes sure the indexes used by
+// Query exist
+func NewMongoSink(database, collection string) *MongoSink {
+	sink := &MongoSink{collection: mongo.Client.Database(database).Collection(collection)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), WriteTimeout)
+	defer cancel()
+	_, err := sink.collection.Indexes().CreateMany(ctx, []mongodriver.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "organizationId", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "database", Value: 1}, {Key: "collection", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	if err != nil {
+		log.Printf("Warning: could not create audit log indexes on %s.%s: %v", database, collection, err)
+	}
+	return sink
+}
+
+func (s *MongoSink) Write(ctx context.Context, record Record) error {
+	_, err := s.collection.InsertOne(ctx, record)
+	return err
+}
+
+// FileSink appends records to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, record Record) error {
+	line, err := bson.MarshalExtJSON(record, false, false)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+var (
+	sinks   []Sink
+	records = make(chan Record, QueueSize)
+)
+
+// start sets up the configured sinks and the background writer
+func start() {
+	sinks = []Sink{NewMongoSink(config.Database, config.Collection)}
+	if config.File != "" {
+		fileSink, err := NewFileSink(config.File)
+		if err != nil {
+			log.Printf("Warning: could not open audit log file %s: %v", config.File, err)
+		} else {
+			sinks = append(sinks, fileSink)
+		}
+	}
+
+	go func() {
+		for record := range records {
+			write(record)
+		}
+	}()
+}
+
+// enqueue hands a record to the background writer so requests don't wait on the audit log
+func enqueue(record Record) {
+	select {
+	case records <- record:
+	default:
+		write(record)
+	}
+}
+
+func write(record Record) {
+	for _, sink := range sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), WriteTimeout)
+		if err := sink.Write(ctx, record); err != nil {
+			log.Printf("[AUDIT] ERROR: Failed to write record for request %s (%s %s): %v", record.RequestID, record.Method, record.Endpoint, err)
+		}
+		cancel()
+	}
+}
@@ -2,27 +2,75 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"mongo-manager/clerk"
+	"mongo-manager/rbac"
+	"mongo-manager/verifier"
 	"net/http"
 	"time"
-
-	clerkhttp "github.com/clerk/clerk-sdk-go/v2/http"
 )
 
+// OrganizationHeader is the HTTP header a user in several organizations can send to select the
+// organization a request acts for
+const OrganizationHeader = "X-Organization-Id"
+
 // OrganizationIDKey is the context key for storing organization ID
 type OrganizationIDKey struct{}
 type UserIDKey struct{}
 
+// RoleKey is the context key for storing the caller's role in their organization
+type RoleKey struct{}
+
+// SubjectKey is the context key for the permissions of requests authenticated with an API key
+type SubjectKey struct{}
+
+// APIKeyHeader is the HTTP header machine clients send their API key in
+const APIKeyHeader = "X-API-Key"
+
+// ErrInvalidAPIKey is returned by a KeyAuthenticator for keys that are malformed, unknown, revoked
+// or expired
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// KeyIdentity is who a request made with an API key acts as: the key's organization, the user
+// requests are attributed to and the key's own grants
+type KeyIdentity struct {
+	ID             string
+	OrganizationID string
+	UserID         string
+	Subject        rbac.Subject
+}
+
+// KeyAuthenticator resolves the API key sent in APIKeyHeader to its identity
+type KeyAuthenticator func(ctx context.Context, key string) (KeyIdentity, error)
+
+// tokenVerifier checks the session tokens of VerifyingMiddleware; it is set at startup with SetVerifier
+var tokenVerifier verifier.Verifier
+
+// keyAuthenticator checks the API keys of APIKeyMiddleware; it is set at startup with SetKeyAuthenticator
+var keyAuthenticator KeyAuthenticator
+
+// SetVerifier sets the verifier session tokens are checked with
+func SetVerifier(v verifier.Verifier) {
+	tokenVerifier = v
+}
+
+// SetKeyAuthenticator sets how API keys are checked. Until it is called every API key is refused
+// with 503.
+func SetKeyAuthenticator(a KeyAuthenticator) {
+	keyAuthenticator = a
+}
+
 // responseWriter wraps http.ResponseWriter to capture the status code
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
 }
 
-// VerifyingMiddleware is the general middleware that verifies the passed JWT Token from clerk and extracts the user ID and organization ID to pass it to the next handler
+// VerifyingMiddleware is the general middleware that verifies the passed JWT Token with the configured verifier and extracts the user ID and organization ID to pass it to the next handler
 func VerifyingMiddleware(next http.Handler) http.Handler {
-	return clerkhttp.RequireHeaderAuthorization()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[AUTH] Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 		startTime := time.Now()
 
@@ -35,50 +83,120 @@ func VerifyingMiddleware(next http.Handler) http.Handler {
 		}
 		log.Printf("[AUTH] Authorization header present for %s %s", r.Method, r.URL.Path)
 
-		userID, err := ExtractUserIDFromAuthHeader(r)
+		claims, err := ExtractSessionClaims(r)
+		if err == nil && claims.Subject == "" {
+			err = fmt.Errorf("%w: no user ID found in token", verifier.ErrInvalidToken)
+		}
 		if err != nil {
 			log.Printf("[AUTH] ERROR: Failed to extract user ID for %s %s: %v", r.Method, r.URL.Path, err)
-			w.WriteHeader(http.StatusUnauthorized)
+			if errors.Is(err, verifier.ErrInvalidToken) || errors.Is(err, verifier.ErrUnknownKey) {
+				w.WriteHeader(http.StatusUnauthorized)
+			} else {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
 			return
 		}
+		userID := claims.Subject
 		log.Printf("[AUTH] Successfully extracted user ID: %s for %s %s", userID, r.Method, r.URL.Path)
 
-		organizationID, err := clerk.GetUserOrganizationId(userID)
-		if err != nil {
+		selected := SelectedOrganization(r, claims)
+		var organizationID, role string
+		if claims.OrganizationRole != "" {
+			organizationID, role, err = tokenMembership(claims, selected)
+		} else {
+			organizationID, role, err = clerk.GetUserOrganizationMembership(userID, selected)
+		}
+		switch {
+		case errors.Is(err, clerk.ErrNotMember):
+			log.Printf("[AUTH] ERROR: User %s is not a member of organization %s on %s %s", userID, selected, r.Method, r.URL.Path)
+			writeProblem(w, r, http.StatusForbidden, CodeNotMember, "you are not a member of organization "+selected)
+			return
+		case errors.Is(err, clerk.ErrOrganizationRequired):
+			log.Printf("[AUTH] ERROR: User %s belongs to several organizations and selected none on %s %s", userID, r.Method, r.URL.Path)
+			writeProblem(w, r, http.StatusBadRequest, CodeOrganizationRequired, "you belong to several organizations, select one with the X-Organization-Id header")
+			return
+		case err != nil:
 			log.Printf("[AUTH] ERROR: Failed to get organization ID for user %s on %s %s: %v", userID, r.Method, r.URL.Path, err)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		log.Printf("[AUTH] Successfully retrieved organization ID: %s for user %s on %s %s", organizationID, userID, r.Method, r.URL.Path)
+		log.Printf("[AUTH] Successfully retrieved organization ID: %s (role %s) for user %s on %s %s", organizationID, role, userID, r.Method, r.URL.Path)
 
-		// Add organization ID and user ID to request context
+		// Add organization ID, user ID and role to request context
 		ctx := context.WithValue(r.Context(), OrganizationIDKey{}, organizationID)
 		ctx = context.WithValue(ctx, UserIDKey{}, userID)
+		ctx = context.WithValue(ctx, RoleKey{}, role)
 		r = r.WithContext(ctx)
 
 		// Wrap the response writer to capture the status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(rw, r)
 		log.Printf("[AUTH] Response: %s %s -> STATUS: %d completed in %v (User: %s, Org: %s)", r.Method, r.URL.Path, rw.statusCode, time.Since(startTime), userID, organizationID)
-	}))
+	})
+}
+
+// tokenMembership returns the organization and role asserted by tokens that carry them, such as
+// those of an OIDC provider. Such a token acts for its own organization only.
+func tokenMembership(claims *verifier.Claims, selected string) (string, string, error) {
+	if selected != claims.OrganizationID {
+		return "", "", clerk.ErrNotMember
+	}
+	return claims.OrganizationID, claims.OrganizationRole, nil
+}
+
+// CombinedMiddleware authenticates machine clients by the API key in the X-API-Key header and
+// everyone else through VerifyingMiddleware. Both paths populate the organization and user ID the
+// same way; API key requests carry the key's own grants instead of an organization role.
+func CombinedMiddleware(next http.Handler) http.Handler {
+	verifying := VerifyingMiddleware(next)
+	keyed := APIKeyMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(APIKeyHeader) == "" {
+			verifying.ServeHTTP(w, r)
+			return
+		}
+		keyed.ServeHTTP(w, r)
+	})
 }
 
-// USED ONLY FOR TESTING
-func TestingMiddleware(next http.Handler) http.Handler {
-	return (http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// APIKeyMiddleware authenticates requests by the API key in the X-API-Key header, refusing
+// requests without one
+func APIKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[AUTH] Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 		startTime := time.Now()
 
-		organizationID := "org_2v0ixOVzW5VsqlFr1ZFJbc7GTay"
-		log.Printf("[AUTH] Successfully retrieved organization ID: %s on %s %s", organizationID, r.Method, r.URL.Path)
+		raw := r.Header.Get(APIKeyHeader)
+		if raw == "" {
+			log.Printf("[AUTH] ERROR: Missing API key for %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
-		// Add organization ID to request context
-		ctx := context.WithValue(r.Context(), OrganizationIDKey{}, organizationID)
+		if keyAuthenticator == nil {
+			log.Printf("[AUTH] ERROR: No API key authenticator is configured for %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		key, err := keyAuthenticator(r.Context(), raw)
+		if err != nil {
+			log.Printf("[AUTH] ERROR: Failed to authenticate API key for %s %s: %v", r.Method, r.URL.Path, err)
+			if errors.Is(err, ErrInvalidAPIKey) {
+				w.WriteHeader(http.StatusUnauthorized)
+			} else {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		log.Printf("[AUTH] Successfully authenticated API key %s (Org: %s) for %s %s", key.ID, key.OrganizationID, r.Method, r.URL.Path)
+
+		ctx := context.WithValue(r.Context(), OrganizationIDKey{}, key.OrganizationID)
+		ctx = context.WithValue(ctx, UserIDKey{}, key.UserID)
+		ctx = context.WithValue(ctx, SubjectKey{}, key.Subject)
 		r = r.WithContext(ctx)
 
-		// Wrap the response writer to capture the status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(rw, r)
-		log.Printf("[AUTH] Response: %s %s -> STATUS: %d completed in %v (Org: %s)", r.Method, r.URL.Path, rw.statusCode, time.Since(startTime), organizationID)
-	}))
+		log.Printf("[AUTH] Response: %s %s -> STATUS: %d completed in %v (API key: %s, Org: %s)", r.Method, r.URL.Path, rw.statusCode, time.Since(startTime), key.ID, key.OrganizationID)
+	})
 }
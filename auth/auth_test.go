@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mongo-manager/verifier"
+)
+
+// verifierFunc lets a test decide what the configured verifier returns
+type verifierFunc func(ctx context.Context, token string) (*verifier.Claims, error)
+
+func (f verifierFunc) Verify(ctx context.Context, token string) (*verifier.Claims, error) {
+	return f(ctx, token)
+}
+
+// setVerifier replaces the configured verifier for the duration of the test
+func setVerifier(t *testing.T, v verifier.Verifier) {
+	t.Helper()
+	saved := tokenVerifier
+	t.Cleanup(func() { tokenVerifier = saved })
+	tokenVerifier = v
+}
+
+func TestVerifyingMiddlewareStatus(t *testing.T) {
+	member := &verifier.Claims{Subject: "user_test", OrganizationID: "org_test", OrganizationRole: "org:member"}
+	returning := func(claims *verifier.Claims, err error) verifier.Verifier {
+		return verifierFunc(func(ctx context.Context, token string) (*verifier.Claims, error) { return claims, err })
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		verifier verifier.Verifier
+		want     int
+	}{
+		{name: "valid token", header: "Bearer token", verifier: returning(member, nil), want: http.StatusOK},
+		{name: "missing header", verifier: returning(member, nil), want: http.StatusUnauthorized},
+		{name: "basic credentials", header: "Basic dXNlcjpwYXNz", verifier: returning(member, nil), want: http.StatusUnauthorized},
+		{name: "token without subject", header: "Bearer token", verifier: returning(&verifier.Claims{OrganizationID: "org_test", OrganizationRole: "org:member"}, nil), want: http.StatusUnauthorized},
+		{name: "invalid token", header: "Bearer token", verifier: returning(nil, fmt.Errorf("%w: expired", verifier.ErrInvalidToken)), want: http.StatusUnauthorized},
+		{name: "unknown key", header: "Bearer token", verifier: returning(nil, verifier.ErrUnknownKey), want: http.StatusUnauthorized},
+		{name: "key provider unreachable", header: "Bearer token", verifier: returning(nil, errors.New("fetching JWKS: connection refused")), want: http.StatusServiceUnavailable},
+		{name: "no verifier configured", header: "Bearer token", want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVerifier(t, tt.verifier)
+
+			handler := VerifyingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/v1/get-all", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("VerifyingMiddleware answered %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
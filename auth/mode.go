@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Mode selects how requests are authenticated
+type Mode string
+
+const (
+	// ModeClerk accepts Clerk session tokens, and API keys for machine clients
+	ModeClerk Mode = "clerk"
+	// ModeAPIKey accepts API keys only
+	ModeAPIKey Mode = "api-key"
+	// ModeStaticDev attributes every request to the fixed identity configured with the AUTH_DEV_*
+	// variables, for local development
+	ModeStaticDev Mode = "static-dev"
+	// ModeDisabled authenticates nothing: requests only carry an identity injected with WithIdentity
+	ModeDisabled Mode = "disabled"
+)
+
+// DefaultMode applies when AUTH_MODE is not set
+const DefaultMode = ModeClerk
+
+// DefaultDevRole is the organization role of the static-dev identity when AUTH_DEV_ROLE is not set
+const DefaultDevRole = "org:admin"
+
+// Identity is who a request acts as
+type Identity struct {
+	UserID         string
+	OrganizationID string
+	Role           string
+}
+
+// identityKey is the context key for an identity injected with WithIdentity
+type identityKey struct{}
+
+var (
+	configuredMode string
+	allowInsecure  string
+	devIdentity    Identity
+)
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	configuredMode = os.Getenv("AUTH_MODE")
+	allowInsecure = os.Getenv("AUTH_ALLOW_INSECURE")
+	devIdentity = Identity{
+		UserID:         os.Getenv("AUTH_DEV_USER_ID"),
+		OrganizationID: os.Getenv("AUTH_DEV_ORGANIZATION_ID"),
+		Role:           os.Getenv("AUTH_DEV_ROLE"),
+	}
+	if devIdentity.Role == "" {
+		devIdentity.Role = DefaultDevRole
+	}
+}
+
+// LoadMode returns the mode selected by AUTH_MODE. The static-dev and disabled modes leave the API
+// open to anyone who can reach it, so they are refused unless AUTH_ALLOW_INSECURE is true; the
+// service must not start when LoadMode fails.
+func LoadMode() (Mode, error) {
+	mode := Mode(configuredMode)
+	if mode == "" {
+		mode = DefaultMode
+	}
+
+	switch mode {
+	case ModeClerk, ModeAPIKey:
+		return mode, nil
+	case ModeStaticDev, ModeDisabled:
+	default:
+		return "", fmt.Errorf("unknown AUTH_MODE %q, expected %q, %q, %q or %q", mode, ModeClerk, ModeAPIKey, ModeStaticDev, ModeDisabled)
+	}
+
+	if allowed, _ := strconv.ParseBool(allowInsecure); !allowed {
+		return "", fmt.Errorf("AUTH_MODE %q disables authentication and requires AUTH_ALLOW_INSECURE=true", mode)
+	}
+	if mode == ModeStaticDev && devIdentity.OrganizationID == "" {
+		return "", fmt.Errorf("AUTH_MODE %q requires AUTH_DEV_ORGANIZATION_ID", mode)
+	}
+	return mode, nil
+}
+
+// Middleware returns the authentication middleware of the mode
+func (m Mode) Middleware() func(http.Handler) http.Handler {
+	switch m {
+	case ModeAPIKey:
+		return APIKeyMiddleware
+	case ModeStaticDev:
+		return func(next http.Handler) http.Handler { return identityMiddleware(&devIdentity, next) }
+	case ModeDisabled:
+		return func(next http.Handler) http.Handler { return identityMiddleware(nil, next) }
+	}
+	return CombinedMiddleware
+}
+
+// WithIdentity returns a context carrying the identity, so tests can call handlers as any user,
+// organization and role. The identity is used by the static-dev and disabled modes, taking
+// precedence over the static-dev identity, and ignored by the others.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// identityMiddleware attributes requests to the identity injected with WithIdentity, or to
+// fallback when there is none. Requests with neither are passed on without an identity, so the
+// handlers refuse them.
+func identityMiddleware(fallback *Identity, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[AUTH] Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		startTime := time.Now()
+
+		identity, ok := r.Context().Value(identityKey{}).(Identity)
+		if !ok && fallback != nil {
+			identity, ok = *fallback, true
+		}
+		if ok {
+			ctx := context.WithValue(r.Context(), OrganizationIDKey{}, identity.OrganizationID)
+			ctx = context.WithValue(ctx, UserIDKey{}, identity.UserID)
+			ctx = context.WithValue(ctx, RoleKey{}, identity.Role)
+			r = r.WithContext(ctx)
+		}
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		log.Printf("[AUTH] Response: %s %s -> STATUS: %d completed in %v (User: %s, Org: %s, unauthenticated)", r.Method, r.URL.Path, rw.statusCode, time.Since(startTime), identity.UserID, identity.OrganizationID)
+	})
+}
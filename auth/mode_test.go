@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setConfig replaces the environment read by init for the duration of the test
+func setConfig(t *testing.T, mode string, insecure string, dev Identity) {
+	t.Helper()
+	savedMode, savedInsecure, savedDev := configuredMode, allowInsecure, devIdentity
+	t.Cleanup(func() {
+		configuredMode, allowInsecure, devIdentity = savedMode, savedInsecure, savedDev
+	})
+	configuredMode, allowInsecure, devIdentity = mode, insecure, dev
+}
+
+func TestLoadMode(t *testing.T) {
+	devOrg := Identity{UserID: "user_dev", OrganizationID: "org_dev", Role: DefaultDevRole}
+
+	tests := []struct {
+		name     string
+		mode     string
+		insecure string
+		dev      Identity
+		want     Mode
+		wantErr  string
+	}{
+		{name: "default", want: ModeClerk},
+		{name: "clerk", mode: "clerk", want: ModeClerk},
+		{name: "api-key", mode: "api-key", want: ModeAPIKey},
+		{name: "unknown", mode: "basic", wantErr: "unknown AUTH_MODE"},
+		{name: "static-dev", mode: "static-dev", insecure: "true", dev: devOrg, want: ModeStaticDev},
+		{name: "static-dev without insecure flag", mode: "static-dev", dev: devOrg, wantErr: "AUTH_ALLOW_INSECURE"},
+		{name: "static-dev with invalid insecure flag", mode: "static-dev", insecure: "yes please", dev: devOrg, wantErr: "AUTH_ALLOW_INSECURE"},
+		{name: "static-dev with insecure flag off", mode: "static-dev", insecure: "false", dev: devOrg, wantErr: "AUTH_ALLOW_INSECURE"},
+		{name: "static-dev without organization", mode: "static-dev", insecure: "true", dev: Identity{UserID: "user_dev"}, wantErr: "AUTH_DEV_ORGANIZATION_ID"},
+		{name: "disabled", mode: "disabled", insecure: "1", want: ModeDisabled},
+		{name: "disabled without insecure flag", mode: "disabled", wantErr: "AUTH_ALLOW_INSECURE"},
+		{name: "insecure flag ignored by clerk", mode: "clerk", insecure: "true", want: ModeClerk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.mode, tt.insecure, tt.dev)
+
+			got, err := LoadMode()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadMode() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadMode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LoadMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIdentity(t *testing.T) {
+	dev := Identity{UserID: "user_dev", OrganizationID: "org_dev", Role: DefaultDevRole}
+	injected := Identity{UserID: "user_test", OrganizationID: "org_test", Role: "org:member"}
+
+	tests := []struct {
+		name     string
+		mode     Mode
+		identity *Identity
+		want     *Identity
+	}{
+		{name: "disabled with injected identity", mode: ModeDisabled, identity: &injected, want: &injected},
+		{name: "disabled without identity", mode: ModeDisabled},
+		{name: "static-dev falls back to the dev identity", mode: ModeStaticDev, want: &dev},
+		{name: "static-dev prefers the injected identity", mode: ModeStaticDev, identity: &injected, want: &injected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, string(tt.mode), "true", dev)
+
+			var got *Identity
+			handler := tt.mode.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				organizationID, ok := GetOrganizationID(r)
+				if !ok {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				userID, _ := GetUserID(r)
+				role, _ := GetRole(r)
+				got = &Identity{UserID: userID, OrganizationID: organizationID, Role: role}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/get-all", nil)
+			if tt.identity != nil {
+				r = r.WithContext(WithIdentity(r.Context(), *tt.identity))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.want == nil {
+				if got != nil || w.Code != http.StatusNoContent {
+					t.Fatalf("request carried identity %+v (status %d), want none", got, w.Code)
+				}
+				return
+			}
+			if got == nil || *got != *tt.want {
+				t.Fatalf("request carried identity %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIgnoresInjectedIdentity(t *testing.T) {
+	setConfig(t, string(ModeAPIKey), "", Identity{})
+
+	reached := false
+	handler := ModeAPIKey.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/get-all", nil)
+	r = r.WithContext(WithIdentity(r.Context(), Identity{UserID: "user_test", OrganizationID: "org_test"}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if reached || w.Code != http.StatusUnauthorized {
+		t.Fatalf("api-key mode answered %d and reached the handler: %v, want 401 without reaching it", w.Code, reached)
+	}
+}
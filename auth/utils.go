@@ -1,14 +1,49 @@
 package auth
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"log"
+	"mongo-manager/rbac"
+	"mongo-manager/requestid"
+	"mongo-manager/verifier"
 	"net/http"
 	"strings"
+)
 
-	clerkjwt "github.com/clerk/clerk-sdk-go/v2/jwt"
+// Problem codes of the organization selection errors, listed with the other codes of the v1 API
+const (
+	CodeNotMember            = "not_member"
+	CodeOrganizationRequired = "organization_required"
 )
 
+// problem is the RFC 7807 body the v1 API returns, which this package can't import
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeProblem writes a problem details response for the given status, code and client-safe detail
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	body := problem{
+		Type:      "urn:mongo-manager:problem:" + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		RequestID: requestid.Get(r),
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("[AUTH] Error writing problem for %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
@@ -29,33 +64,84 @@ func GetUserID(r *http.Request) (string, bool) {
 	return userID, ok
 }
 
+// GetSubject returns who permissions are checked for: the API key the request was made with, or
+// the caller's organization role
+func GetSubject(r *http.Request) rbac.Subject {
+	if subject, ok := r.Context().Value(SubjectKey{}).(rbac.Subject); ok {
+		return subject
+	}
+	role, _ := GetRole(r)
+	return rbac.ForRole(role)
+}
+
+// IsAPIKey reports whether the request was authenticated with an API key rather than a user session
+func IsAPIKey(r *http.Request) bool {
+	_, ok := r.Context().Value(SubjectKey{}).(rbac.Subject)
+	return ok
+}
+
+// GetRole retrieves the caller's organization role from the request context
+func GetRole(r *http.Request) (string, bool) {
+	role, ok := r.Context().Value(RoleKey{}).(string)
+	return role, ok
+}
+
 // extractUserIDFromAuthHeader extracts the user ID from the Authorization header
 func ExtractUserIDFromAuthHeader(req *http.Request) (string, error) {
+	claims, err := ExtractSessionClaims(req)
+	if err != nil {
+		return "", err
+	}
+
+	// Extract user ID from the subject claim
+	userID := claims.Subject
+	if userID == "" {
+		return "", fmt.Errorf("%w: no user ID found in token", verifier.ErrInvalidToken)
+	}
+
+	return userID, nil
+}
+
+// ExtractSessionClaims verifies the Bearer token of the Authorization header with the configured
+// verifier and returns its claims
+func ExtractSessionClaims(req *http.Request) (*verifier.Claims, error) {
 	authHeader := req.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", fmt.Errorf("missing authorization header")
+		return nil, fmt.Errorf("%w: missing authorization header", verifier.ErrInvalidToken)
 	}
 
 	// Check if it's a Bearer token
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", fmt.Errorf("invalid authorization header format")
+		return nil, fmt.Errorf("%w: invalid authorization header format", verifier.ErrInvalidToken)
 	}
 
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 
+	if tokenVerifier == nil {
+		return nil, fmt.Errorf("no token verifier configured")
+	}
+
 	// Verify the JWT token and extract claims
-	claims, err := clerkjwt.Verify(context.Background(), &clerkjwt.VerifyParams{
-		Token: token,
-	})
+	claims, err := tokenVerifier.Verify(req.Context(), token)
 	if err != nil {
-		return "", fmt.Errorf("failed to verify token: %v", err)
+		return nil, fmt.Errorf("failed to verify token: %w", err)
 	}
 
-	// Extract user ID from the subject claim
-	userID := claims.RegisteredClaims.Subject
-	if userID == "" {
-		return "", fmt.Errorf("no user ID found in token")
+	return claims, nil
+}
+
+// SelectedOrganization returns the organization the caller asked to act for: the X-Organization-Id
+// header when present, otherwise the active organization of the session token. It is empty when
+// neither is set. The selection still has to be checked against the user's memberships.
+func SelectedOrganization(req *http.Request, claims *verifier.Claims) string {
+	if organizationID := strings.TrimSpace(req.Header.Get(OrganizationHeader)); organizationID != "" {
+		return organizationID
 	}
+	return claims.OrganizationID
+}
 
-	return userID, nil
+// Unwrap exposes the underlying ResponseWriter so http.ResponseController can reach
+// optional interfaces such as http.Flusher and write deadlines
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
@@ -0,0 +1,213 @@
+package clerk
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultMembershipCacheTTL and DefaultMembershipCacheSize apply when MEMBERSHIP_CACHE_TTL and
+// MEMBERSHIP_CACHE_SIZE are not set
+const (
+	DefaultMembershipCacheTTL  = 5 * time.Minute
+	DefaultMembershipCacheSize = 10000
+)
+
+// membership is the part of a Clerk organization membership the service needs
+type membership struct {
+	OrganizationID string
+	Role           string
+}
+
+// cacheEntry is the cached membership list of one user
+type cacheEntry struct {
+	userID      string
+	memberships []membership
+	expiresAt   time.Time
+}
+
+// membershipCache holds the memberships of recently seen users. Entries expire after ttl, the
+// least recently used entry is evicted once size is reached, and concurrent lookups for the same
+// user share a single Clerk API call.
+type membershipCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	group   singleflight.Group
+	// generation is bumped by every invalidation so lookups that were already in flight don't
+	// store memberships fetched before the change
+	generation uint64
+}
+
+// counters is a set of named counters safe for concurrent use
+type counters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (c *counters) Add(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] += delta
+}
+
+// Cache metrics, reported by CacheMetrics
+var cacheMetrics = &counters{values: map[string]int64{}}
+
+// CacheMetrics returns a snapshot of the membership cache counters: hits, misses, shared_lookups,
+// evictions and invalidations
+func CacheMetrics() map[string]int64 {
+	cacheMetrics.mu.Lock()
+	defer cacheMetrics.mu.Unlock()
+	snapshot := map[string]int64{}
+	for name, value := range cacheMetrics.values {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// cache is set up by init once the environment has been loaded
+var cache *membershipCache
+
+func newMembershipCache(ttl time.Duration, size int) *membershipCache {
+	return &membershipCache{ttl: ttl, size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns the memberships of the user, calling fetch on a miss. A zero ttl disables caching.
+func (c *membershipCache) get(userID string, fetch func(userID string) ([]membership, error)) ([]membership, error) {
+	if cached, ok := c.lookup(userID); ok {
+		cacheMetrics.Add("hits", 1)
+		return cached, nil
+	}
+	cacheMetrics.Add("misses", 1)
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	result, err, shared := c.group.Do(userID, func() (interface{}, error) {
+		fetched, err := fetch(userID)
+		if err != nil {
+			return nil, err
+		}
+		c.store(userID, fetched, generation)
+		return fetched, nil
+	})
+	if shared {
+		cacheMetrics.Add("shared_lookups", 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.([]membership), nil
+}
+
+func (c *membershipCache) lookup(userID string) ([]membership, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, userID)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.memberships, true
+}
+
+func (c *membershipCache) store(userID string, fetched []membership, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 || c.size <= 0 || generation != c.generation {
+		return
+	}
+
+	entry := &cacheEntry{userID: userID, memberships: fetched, expiresAt: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[userID]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[userID] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).userID)
+		cacheMetrics.Add("evictions", 1)
+	}
+}
+
+// invalidate drops the cached memberships of the user
+func (c *membershipCache) invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.group.Forget(userID)
+	if element, ok := c.entries[userID]; ok {
+		c.order.Remove(element)
+		delete(c.entries, userID)
+	}
+	cacheMetrics.Add("invalidations", 1)
+}
+
+// invalidateAll empties the cache
+func (c *membershipCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+	cacheMetrics.Add("invalidations", 1)
+}
+
+// InvalidateUser drops the cached memberships of a user so the next request fetches them from Clerk
+func InvalidateUser(userID string) {
+	cache.invalidate(userID)
+}
+
+// InvalidateAll drops every cached membership
+func InvalidateAll() {
+	cache.invalidateAll()
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	configured := os.Getenv(name)
+	if configured == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(configured)
+	if err != nil || parsed < 0 {
+		log.Printf("Warning: ignoring invalid %s %q, using %s", name, configured, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func envInt(name string, fallback int) int {
+	configured := os.Getenv(name)
+	if configured == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(configured)
+	if err != nil || parsed < 0 {
+		log.Printf("Warning: ignoring invalid %s %q, using %d", name, configured, fallback)
+		return fallback
+	}
+	return parsed
+}
@@ -17,25 +17,77 @@ func init() {
 	}
 
 	clerk.SetKey(os.Getenv("CLERK_SECRET_KEY"))
+	webhookSecret = os.Getenv("CLERK_WEBHOOK_SECRET")
+
+	cache = newMembershipCache(
+		envDuration("MEMBERSHIP_CACHE_TTL", DefaultMembershipCacheTTL),
+		envInt("MEMBERSHIP_CACHE_SIZE", DefaultMembershipCacheSize),
+	)
 }
 
-func getUserOrganizations(userId string) (*clerk.OrganizationMembershipList, error) {
-	orgMemberships, err := user.ListOrganizationMemberships(context.Background(), userId, &user.ListOrganizationMembershipsParams{})
+// MembershipPageSize is the number of memberships requested per page when listing a user's organizations
+const MembershipPageSize int64 = 100
 
-	if err != nil {
-		log.Printf("Error getting organization memberships: %v", err)
-	}
+// ErrNotMember is returned when a user selects an organization they don't belong to
+var ErrNotMember = errors.New("user is not a member of the selected organization")
+
+// ErrOrganizationRequired is returned when a user belongs to several organizations and did not
+// select one
+var ErrOrganizationRequired = errors.New("user belongs to several organizations, select one")
 
-	return orgMemberships, nil
+// getUserOrganizations lists every organization membership of the user, following pagination
+func getUserOrganizations(userId string) ([]membership, error) {
+	var memberships []membership
+	for {
+		params := &user.ListOrganizationMembershipsParams{}
+		params.Limit = clerk.Int64(MembershipPageSize)
+		params.Offset = clerk.Int64(int64(len(memberships)))
+
+		page, err := user.ListOrganizationMemberships(context.Background(), userId, params)
+		if err != nil {
+			log.Printf("Error getting organization memberships: %v", err)
+			return nil, err
+		}
+		for _, m := range page.OrganizationMemberships {
+			memberships = append(memberships, membership{OrganizationID: m.Organization.ID, Role: m.Role})
+		}
+
+		if int64(len(page.OrganizationMemberships)) < MembershipPageSize || int64(len(memberships)) >= page.TotalCount {
+			return memberships, nil
+		}
+	}
 }
 
 func GetUserOrganizationId(userId string) (string, error) {
-	orgMemberships, err := getUserOrganizations(userId)
+	organizationId, _, err := GetUserOrganizationMembership(userId, "")
+	return organizationId, err
+}
+
+// GetUserOrganizationMembership returns the organization the user acts for and their role in it,
+// e.g. "org:admin". organizationId selects one of the user's organizations; when it is empty the
+// user's only organization is used, and users in several organizations get ErrOrganizationRequired.
+// Selecting an organization the user doesn't belong to returns ErrNotMember. Memberships are served
+// from the membership cache.
+func GetUserOrganizationMembership(userId string, organizationId string) (string, string, error) {
+	memberships, err := cache.get(userId, getUserOrganizations)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	if len(memberships) == 0 {
+		return "", "", errors.New("no organization memberships found")
 	}
-	if len(orgMemberships.OrganizationMemberships) == 0 {
-		return "", errors.New("no organization memberships found")
+
+	if organizationId == "" {
+		if len(memberships) > 1 {
+			return "", "", ErrOrganizationRequired
+		}
+		return memberships[0].OrganizationID, memberships[0].Role, nil
+	}
+
+	for _, m := range memberships {
+		if m.OrganizationID == organizationId {
+			return m.OrganizationID, m.Role, nil
+		}
 	}
-	return orgMemberships.OrganizationMemberships[0].Organization.ID, nil
+	return "", "", ErrNotMember
 }
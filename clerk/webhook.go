@@ -0,0 +1,128 @@
+package clerk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookTolerance is how far the timestamp of a webhook may be from the current time, which
+// limits how long a captured delivery can be replayed
+const WebhookTolerance = 5 * time.Minute
+
+// MaxWebhookBytes is the largest webhook payload accepted
+const MaxWebhookBytes = 1 << 20
+
+// ErrInvalidSignature is returned when a webhook is not signed with the configured secret
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// webhookSecret is the signing secret of the Clerk webhook endpoint, "whsec_..."
+var webhookSecret string
+
+// webhookEvent is the part of a Clerk webhook event needed to invalidate cached memberships
+type webhookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		ID             string `json:"id"`
+		PublicUserData struct {
+			UserID string `json:"user_id"`
+		} `json:"public_user_data"`
+	} `json:"data"`
+}
+
+// WebhookHandler receives Clerk webhooks and invalidates the membership cache when memberships
+// change. Deliveries are verified against CLERK_WEBHOOK_SECRET and refused when it is not set.
+func WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if webhookSecret == "" {
+		log.Printf("[WEBHOOK] ERROR: Received a Clerk webhook but CLERK_WEBHOOK_SECRET is not set")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := VerifyWebhook(webhookSecret, r.Header, body, time.Now()); err != nil {
+		log.Printf("[WEBHOOK] ERROR: Rejected Clerk webhook %s: %v", r.Header.Get("svix-id"), err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(event.Type, "organizationMembership."):
+		InvalidateUser(event.Data.PublicUserData.UserID)
+		log.Printf("[WEBHOOK] %s: invalidated cached memberships of user %s", event.Type, event.Data.PublicUserData.UserID)
+	case event.Type == "user.deleted":
+		InvalidateUser(event.Data.ID)
+		log.Printf("[WEBHOOK] %s: invalidated cached memberships of user %s", event.Type, event.Data.ID)
+	case event.Type == "organization.deleted":
+		// The event doesn't list the members, so every cached membership is dropped
+		InvalidateAll()
+		log.Printf("[WEBHOOK] %s: invalidated all cached memberships", event.Type)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyWebhook checks the Svix signature Clerk attaches to webhooks: an HMAC-SHA256 over
+// "<svix-id>.<svix-timestamp>.<body>" keyed with the base64 part of the "whsec_" secret. The
+// svix-signature header may list several space-separated "v1,<signature>" values during secret
+// rotation; one match is enough.
+func VerifyWebhook(secret string, header http.Header, body []byte, now time.Time) error {
+	id := header.Get("svix-id")
+	timestamp := header.Get("svix-timestamp")
+	signatures := header.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return errors.New("missing svix headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed svix-timestamp")
+	}
+	sent := time.Unix(seconds, 0)
+	if now.Sub(sent) > WebhookTolerance || sent.Sub(now) > WebhookTolerance {
+		return errors.New("svix-timestamp is outside the tolerance")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return errors.New("malformed webhook secret")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range strings.Fields(signatures) {
+		version, encoded, ok := strings.Cut(signature, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
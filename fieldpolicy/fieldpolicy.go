@@ -0,0 +1,215 @@
+package fieldpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Everyone matches every caller, including API keys, when listed as a role
+const Everyone = "*"
+
+// DefaultReloadInterval is how often the policy file is checked for changes when
+// FIELD_POLICY_RELOAD_INTERVAL is not set
+const DefaultReloadInterval = 10 * time.Second
+
+// Variables replaced in row filters by the caller's identity
+const (
+	VariableUserID         = "$userId"
+	VariableOrganizationID = "$organizationId"
+	VariableRole           = "$role"
+)
+
+// Config lists the field and row policies of collections keyed by "database.collection".
+// Collections without a policy are unrestricted beyond RBAC and tenancy.
+type Config struct {
+	Collections map[string]CollectionPolicy `json:"collections"`
+}
+
+// CollectionPolicy restricts the fields of a collection by role and the documents each role sees
+type CollectionPolicy struct {
+	// Fields maps dotted field paths to the roles that may read and write them
+	Fields map[string]FieldRule `json:"fields"`
+	// Rows are filters every request is restricted by, ANDed together
+	Rows []RowRule `json:"rows"`
+}
+
+// FieldRule lists the roles that may read and write a field. A nil list allows every role, an
+// empty one none. Callers that may not read the field get Mask in its place when it is set, and
+// have the field removed otherwise.
+type FieldRule struct {
+	Read  []string    `json:"read"`
+	Write []string    `json:"write"`
+	Mask  interface{} `json:"mask"`
+}
+
+// RowRule restricts the documents callers see and write to those matching Filter, an Extended
+// JSON filter in which the strings "$userId", "$organizationId" and "$role" are replaced by the
+// caller's identity. Callers with an Exempt role are not restricted.
+type RowRule struct {
+	Filter json.RawMessage `json:"filter"`
+	Exempt []string        `json:"exempt"`
+
+	filter bson.D
+}
+
+// Caller is who a policy is evaluated for. API key requests have no Role and only match Everyone.
+type Caller struct {
+	UserID         string
+	OrganizationID string
+	Role           string
+}
+
+// ErrUnavailable is returned for every request when the policy file could not be loaded at startup,
+// so a broken file denies access instead of lifting every restriction
+var ErrUnavailable = errors.New("field policies could not be loaded")
+
+// config holds the active policies; it is nil while they are unavailable
+var config atomic.Pointer[Config]
+
+var (
+	policyPath     string
+	reloadInterval = DefaultReloadInterval
+	loadedModTime  time.Time
+)
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	config.Store(&Config{})
+	policyPath = os.Getenv("FIELD_POLICY_PATH")
+	if policyPath == "" {
+		policyPath = "field_policy.json"
+	}
+	if configured := os.Getenv("FIELD_POLICY_RELOAD_INTERVAL"); configured != "" {
+		parsed, err := time.ParseDuration(configured)
+		if err != nil || parsed < 0 {
+			log.Printf("Warning: ignoring invalid FIELD_POLICY_RELOAD_INTERVAL %q, using %s", configured, DefaultReloadInterval)
+		} else {
+			reloadInterval = parsed
+		}
+	}
+
+	reload()
+}
+
+// LoadConfig reads and validates a policy file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var loaded Config
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return Config{}, err
+	}
+	if err := loaded.compile(); err != nil {
+		return Config{}, err
+	}
+	return loaded, nil
+}
+
+// SetConfig replaces the active policies
+func SetConfig(c Config) error {
+	if err := c.compile(); err != nil {
+		return err
+	}
+	config.Store(&c)
+	return nil
+}
+
+// compile parses the row filters of every policy
+func (c *Config) compile() error {
+	for namespace, policy := range c.Collections {
+		for i := range policy.Rows {
+			row := &policy.Rows[i]
+			if len(row.Filter) == 0 {
+				return fmt.Errorf("%s: rows[%d] has no filter", namespace, i)
+			}
+			var filter bson.D
+			if err := bson.UnmarshalExtJSON(row.Filter, false, &filter); err != nil {
+				return fmt.Errorf("%s: rows[%d].filter: %w", namespace, i, err)
+			}
+			row.filter = filter
+		}
+	}
+	return nil
+}
+
+// StartReload checks the policy file every FIELD_POLICY_RELOAD_INTERVAL until ctx is cancelled and
+// applies it when it has changed. A file that can't be parsed keeps the previous policies active.
+func StartReload(ctx context.Context) {
+	if reloadInterval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reload()
+			}
+		}
+	}()
+}
+
+func reload() {
+	info, err := os.Stat(policyPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if !loadedModTime.IsZero() {
+				log.Printf("Warning: field policy file %s was removed, keeping the last loaded policies", policyPath)
+			}
+			return
+		}
+		log.Printf("Warning: could not read field policy file %s: %v", policyPath, err)
+		return
+	}
+	if info.ModTime().Equal(loadedModTime) {
+		return
+	}
+
+	loaded, err := LoadConfig(policyPath)
+	if err != nil {
+		if loadedModTime.IsZero() {
+			log.Printf("Warning: could not load field policies from %s, requests will be denied until it is fixed: %v", policyPath, err)
+			config.Store(nil)
+		} else {
+			log.Printf("Warning: could not load field policies from %s, keeping the previous policies: %v", policyPath, err)
+		}
+		loadedModTime = info.ModTime()
+		return
+	}
+	config.Store(&loaded)
+	loadedModTime = info.ModTime()
+	log.Printf("Loaded field policies for %d collections from %s", len(loaded.Collections), policyPath)
+}
+
+// allowed reports whether roles lets the caller's role in; a nil list lets everyone in
+func allowed(roles []string, role string) bool {
+	return roles == nil || listed(roles, role)
+}
+
+// listed reports whether the caller's role is in roles
+func listed(roles []string, role string) bool {
+	for _, candidate := range roles {
+		if candidate == Everyone || (role != "" && candidate == role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package fieldpolicy
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ScopePipeline restricts an aggregation pipeline to the caller's view. Stages matching the rows,
+// removing the hidden fields and masking the masked ones are prepended, so later stages never see
+// the values. Stages reading another collection ($lookup, $graphLookup, $unionWith, including those
+// nested in $facet) are refused when foreign returns a restricted view of that collection, since
+// their documents would bypass it. Run it before tenancy scoping, which validates the stages.
+func (v View) ScopePipeline(pipeline []bson.D, foreign func(collection string) (View, error)) ([]bson.D, error) {
+	if err := checkForeign(pipeline, foreign); err != nil {
+		return nil, err
+	}
+	if !v.Restricted() {
+		return pipeline, nil
+	}
+
+	scoped := []bson.D{}
+	if len(v.rows) > 0 {
+		scoped = append(scoped, bson.D{{Key: "$match", Value: v.ScopeFilter(nil)}})
+	}
+	if len(v.hidden) > 0 {
+		unset := bson.A{}
+		for _, hidden := range v.hidden {
+			unset = append(unset, hidden)
+		}
+		scoped = append(scoped, bson.D{{Key: "$unset", Value: unset}})
+	}
+	if len(v.masked) > 0 {
+		set := bson.D{}
+		for masked, mask := range v.masked {
+			// Documents without the field keep going without it instead of gaining the mask
+			set = append(set, bson.E{Key: masked, Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$" + masked}}, "missing"}}},
+				"$$REMOVE",
+				bson.D{{Key: "$literal", Value: mask}},
+			}}}})
+		}
+		scoped = append(scoped, bson.D{{Key: "$set", Value: set}})
+	}
+	return append(scoped, pipeline...), nil
+}
+
+// checkForeign refuses stages that read a collection the caller's view of is restricted
+func checkForeign(pipeline []bson.D, foreign func(collection string) (View, error)) error {
+	for _, stage := range pipeline {
+		for _, elem := range stage {
+			var collection string
+			var nested []bson.D
+			switch elem.Key {
+			case "$lookup", "$graphLookup":
+				spec := document(elem.Value)
+				collection, _ = lookupString(spec, "from")
+				nested = stages(lookup(spec, "pipeline"))
+			case "$unionWith":
+				if coll, ok := elem.Value.(string); ok {
+					collection = coll
+				} else {
+					spec := document(elem.Value)
+					collection, _ = lookupString(spec, "coll")
+					nested = stages(lookup(spec, "pipeline"))
+				}
+			case "$facet":
+				for _, facet := range document(elem.Value) {
+					if err := checkForeign(stages(facet.Value), foreign); err != nil {
+						return err
+					}
+				}
+				continue
+			default:
+				continue
+			}
+
+			if collection != "" {
+				view, err := foreign(collection)
+				if err != nil {
+					return err
+				}
+				if view.Restricted() {
+					return fmt.Errorf("%w: %s can't read %q", ErrRestricted, elem.Key, collection)
+				}
+			}
+			if err := checkForeign(nested, foreign); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func document(value interface{}) bson.D {
+	switch v := value.(type) {
+	case bson.D:
+		return v
+	case bson.M:
+		return mapDocument(v)
+	case map[string]interface{}:
+		return mapDocument(v)
+	}
+	return nil
+}
+
+func mapDocument(m map[string]interface{}) bson.D {
+	doc := bson.D{}
+	for key, val := range m {
+		doc = append(doc, bson.E{Key: key, Value: val})
+	}
+	return doc
+}
+
+func stages(value interface{}) []bson.D {
+	var items []interface{}
+	switch v := value.(type) {
+	case []bson.D:
+		return v
+	case bson.A:
+		items = v
+	case []interface{}:
+		items = v
+	}
+	pipeline := []bson.D{}
+	for _, item := range items {
+		if stage := document(item); stage != nil {
+			pipeline = append(pipeline, stage)
+		}
+	}
+	return pipeline
+}
+
+func lookup(doc bson.D, key string) interface{} {
+	for _, elem := range doc {
+		if elem.Key == key {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+func lookupString(doc bson.D, key string) (string, bool) {
+	value, ok := lookup(doc, key).(string)
+	return value, ok
+}
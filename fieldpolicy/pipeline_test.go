@@ -0,0 +1,86 @@
+package fieldpolicy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestScopePipeline(t *testing.T) {
+	testConfig(t)
+	member := Caller{UserID: "user_1", Role: "org:member"}
+	view := memberView(t)
+	foreign := func(collection string) (View, error) {
+		return Resolve(member, "shop", collection)
+	}
+
+	match := bson.D{{Key: "$match", Value: bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "ownerId", Value: "user_1"}}}}}}}
+	unset := bson.D{{Key: "$unset", Value: bson.A{"internal", "ssn"}}}
+	mask := bson.D{{Key: "$set", Value: bson.D{{Key: "contact.phone", Value: bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$contact.phone"}}, "missing"}}},
+		"$$REMOVE",
+		bson.D{{Key: "$literal", Value: "***"}},
+	}}}}}}}
+	group := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$ssn"}}}}
+
+	t.Run("restricted view", func(t *testing.T) {
+		got, err := view.ScopePipeline([]bson.D{group}, foreign)
+		if err != nil {
+			t.Fatalf("ScopePipeline() error = %v", err)
+		}
+		// The hidden ssn is already gone when the caller's $group reads it
+		want := []bson.D{match, unset, mask, group}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ScopePipeline() =\n%v\nwant\n%v", got, want)
+		}
+	})
+
+	t.Run("unrestricted view", func(t *testing.T) {
+		got, err := View{}.ScopePipeline([]bson.D{group}, foreign)
+		if err != nil || !reflect.DeepEqual(got, []bson.D{group}) {
+			t.Errorf("ScopePipeline() = %v, %v, want the pipeline unchanged", got, err)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		pipeline []bson.D
+		wantErr  bool
+	}{
+		{name: "$lookup of an unrestricted collection", pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "products"}, {Key: "as", Value: "p"}}}}}},
+		{name: "$lookup of a restricted collection", pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "as", Value: "c"}}}}}, wantErr: true},
+		{name: "$graphLookup of a restricted collection", pipeline: []bson.D{{{Key: "$graphLookup", Value: bson.D{{Key: "from", Value: "customers"}}}}}, wantErr: true},
+		{name: "$unionWith shorthand of a restricted collection", pipeline: []bson.D{{{Key: "$unionWith", Value: "customers"}}}, wantErr: true},
+		{
+			name: "$unionWith nested in a $lookup",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "products"},
+				{Key: "pipeline", Value: bson.A{bson.D{{Key: "$unionWith", Value: bson.D{{Key: "coll", Value: "customers"}}}}}},
+				{Key: "as", Value: "p"},
+			}}}},
+			wantErr: true,
+		},
+		{
+			name: "$lookup nested in a $facet",
+			pipeline: []bson.D{{{Key: "$facet", Value: bson.D{
+				{Key: "a", Value: bson.A{bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "as", Value: "c"}}}}}},
+			}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Foreign collections are checked even when the caller's own view is unrestricted
+			_, err := View{}.ScopePipeline(tt.pipeline, foreign)
+			if tt.wantErr && !errors.Is(err, ErrRestricted) {
+				t.Errorf("ScopePipeline() error = %v, want ErrRestricted", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("ScopePipeline() error = %v", err)
+			}
+		})
+	}
+}
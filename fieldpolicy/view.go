@@ -0,0 +1,526 @@
+package fieldpolicy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrRestricted is returned for operations the policies can't be enforced on, such as pipeline
+// updates or replacements, when the caller is restricted on the collection
+var ErrRestricted = errors.New("field policies restrict the caller on this collection")
+
+// FieldError is returned when a request filters, sorts or writes a field the caller may not
+type FieldError struct {
+	Field  string
+	Action string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("the field policy does not allow the caller to %s %q", e.Action, e.Field)
+}
+
+// View is what one caller may do with one collection
+type View struct {
+	// hidden are the paths the caller may not read and that are removed from documents
+	hidden []string
+	// masked maps the paths the caller may not read to the value shown in their place
+	masked map[string]interface{}
+	// protected are the paths the caller may not write
+	protected []string
+	// rows are the row filters that apply to the caller, with variables replaced
+	rows []bson.D
+	// rowFields are the fields the rows filter on, which the caller may not change so documents
+	// can't be moved out of their rows
+	rowFields []string
+}
+
+// Resolve returns the caller's view of the collection
+func Resolve(caller Caller, database, collection string) (View, error) {
+	active := config.Load()
+	if active == nil {
+		return View{}, ErrUnavailable
+	}
+	policy, ok := active.Collections[database+"."+collection]
+	if !ok {
+		return View{}, nil
+	}
+
+	view := View{masked: map[string]interface{}{}}
+	for path, rule := range policy.Fields {
+		if !allowed(rule.Read, caller.Role) {
+			if rule.Mask != nil {
+				view.masked[path] = rule.Mask
+			} else {
+				view.hidden = append(view.hidden, path)
+			}
+		}
+		if !allowed(rule.Write, caller.Role) {
+			view.protected = append(view.protected, path)
+		}
+	}
+	sort.Strings(view.hidden)
+	sort.Strings(view.protected)
+
+	for _, row := range policy.Rows {
+		if !listed(row.Exempt, caller.Role) {
+			view.rows = append(view.rows, substitute(row.filter, caller).(bson.D))
+			for _, elem := range row.filter {
+				if !strings.HasPrefix(elem.Key, "$") {
+					view.rowFields = append(view.rowFields, elem.Key)
+				}
+			}
+		}
+	}
+	return view, nil
+}
+
+// Restricted reports whether any field or row policy applies to the caller. Trash and revision
+// history hold whole past versions of documents and are not available to restricted callers.
+func (v View) Restricted() bool {
+	return len(v.hidden) > 0 || len(v.masked) > 0 || len(v.protected) > 0 || len(v.rows) > 0
+}
+
+// unreadable returns the hidden or masked path overlapping path, if any
+func (v View) unreadable(path string) (string, bool) {
+	for _, hidden := range v.hidden {
+		if overlaps(path, hidden) {
+			return hidden, true
+		}
+	}
+	for masked := range v.masked {
+		if overlaps(path, masked) {
+			return masked, true
+		}
+	}
+	return "", false
+}
+
+// unwritable returns the protected or row path overlapping path, if any
+func (v View) unwritable(path string) (string, bool) {
+	for _, protected := range append(v.protected, v.rowFields...) {
+		if overlaps(path, protected) {
+			return protected, true
+		}
+	}
+	return "", false
+}
+
+// ScopeFilter restricts a filter to the rows the caller may see. It must run after tenancy scoping.
+func (v View) ScopeFilter(filter bson.D) bson.D {
+	if len(v.rows) == 0 {
+		return filter
+	}
+	conditions := bson.A{}
+	if len(filter) > 0 {
+		conditions = append(conditions, filter)
+	}
+	for _, row := range v.rows {
+		conditions = append(conditions, row)
+	}
+	return bson.D{{Key: "$and", Value: conditions}}
+}
+
+// CheckQuery refuses filters and sorts on fields the caller may not read, which would otherwise
+// reveal their values one guess at a time
+func (v View) CheckQuery(filter bson.D, sortKeys bson.D) error {
+	if len(v.hidden) == 0 && len(v.masked) == 0 {
+		return nil
+	}
+	if err := v.checkFilter(filter); err != nil {
+		return err
+	}
+	for _, key := range sortKeys {
+		if field, ok := v.unreadable(normalizePath(key.Key)); ok {
+			return &FieldError{Field: field, Action: "sort by"}
+		}
+	}
+	return nil
+}
+
+func (v View) checkFilter(filter bson.D) error {
+	for _, elem := range filter {
+		switch elem.Key {
+		case "$and", "$or", "$nor":
+			items, _ := elem.Value.(bson.A)
+			for _, item := range items {
+				if nested, ok := item.(bson.D); ok {
+					if err := v.checkFilter(nested); err != nil {
+						return err
+					}
+				}
+			}
+		case "$expr", "$where", "$function", "$text", "$jsonSchema":
+			// These can reach any field without naming it as a key
+			return fmt.Errorf("%w: %s can't be used", ErrRestricted, elem.Key)
+		default:
+			if field, ok := v.unreadable(normalizePath(elem.Key)); ok {
+				return &FieldError{Field: field, Action: "filter on"}
+			}
+		}
+	}
+	return nil
+}
+
+// Projection removes the hidden fields through the projection. Exclusion projections get the
+// hidden paths added, and inclusion projections lose the paths within them. Masked fields and
+// hidden fields inside included embedded documents are left to Redact.
+func (v View) Projection(projection bson.D) bson.D {
+	if len(v.hidden) == 0 {
+		return projection
+	}
+
+	scoped := bson.D{}
+	if isExclusion(projection) {
+		// MongoDB refuses overlapping paths, so exclusions within a hidden path are replaced by it
+		// and hidden paths within an excluded one are already covered
+		for _, elem := range projection {
+			if !v.withinHidden(elem.Key) {
+				scoped = append(scoped, elem)
+			}
+		}
+		for _, hidden := range v.hidden {
+			covered := false
+			for _, elem := range scoped {
+				if hidden == elem.Key || strings.HasPrefix(hidden, elem.Key+".") {
+					covered = true
+				}
+			}
+			if !covered {
+				scoped = append(scoped, bson.E{Key: hidden, Value: 0})
+			}
+		}
+		return scoped
+	}
+
+	included := false
+	for _, elem := range projection {
+		if elem.Key != "_id" {
+			if v.withinHidden(elem.Key) {
+				continue
+			}
+			included = true
+		}
+		scoped = append(scoped, elem)
+	}
+	if !included {
+		// Everything asked for is hidden; an inclusion projection left without fields would
+		// return whole documents
+		scoped = append(scoped, bson.E{Key: "_id", Value: 1})
+	}
+	return scoped
+}
+
+// withinHidden reports whether path is a hidden path or lies inside one
+func (v View) withinHidden(path string) bool {
+	path = normalizePath(path)
+	for _, hidden := range v.hidden {
+		if path == hidden || strings.HasPrefix(path, hidden+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// isExclusion reports whether a projection only excludes fields, which is also how an empty
+// projection behaves
+func isExclusion(projection bson.D) bool {
+	for _, elem := range projection {
+		if elem.Key == "_id" {
+			continue
+		}
+		switch value := elem.Value.(type) {
+		case bool:
+			if value {
+				return false
+			}
+		case int32:
+			if value != 0 {
+				return false
+			}
+		case int64:
+			if value != 0 {
+				return false
+			}
+		case int:
+			if value != 0 {
+				return false
+			}
+		case float64:
+			if value != 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Redact removes the hidden fields of a document and masks the masked ones. It runs on every
+// document returned to the caller, including those the projection already trimmed.
+func (v View) Redact(doc bson.M) {
+	v.RedactAt(doc, "")
+}
+
+// RedactAt redacts the document nested under prefix, such as the "document" of a trash entry
+func (v View) RedactAt(doc bson.M, prefix string) {
+	if doc == nil {
+		return
+	}
+	for _, hidden := range v.hidden {
+		redactPath(doc, splitPath(prefix, hidden), nil, false)
+	}
+	for masked, value := range v.masked {
+		redactPath(doc, splitPath(prefix, masked), value, true)
+	}
+}
+
+// RedactValue returns what the caller may see of the value of a field path, such as a change in a
+// dry run: the value itself with the fields below path redacted, its mask, or false when the field
+// is hidden
+func (v View) RedactValue(path string, value interface{}) (interface{}, bool) {
+	path = normalizePath(path)
+	for _, hidden := range v.hidden {
+		if path == hidden || strings.HasPrefix(path, hidden+".") {
+			return nil, false
+		}
+		if strings.HasPrefix(hidden, path+".") {
+			value = redactPath(value, strings.Split(strings.TrimPrefix(hidden, path+"."), "."), nil, false)
+		}
+	}
+	for masked, mask := range v.masked {
+		if path == masked || strings.HasPrefix(path, masked+".") {
+			if value == nil {
+				return nil, true
+			}
+			return mask, true
+		}
+		if strings.HasPrefix(masked, path+".") {
+			value = redactPath(value, strings.Split(strings.TrimPrefix(masked, path+"."), "."), mask, true)
+		}
+	}
+	return value, true
+}
+
+func splitPath(prefix, path string) []string {
+	if prefix != "" {
+		path = prefix + "." + path
+	}
+	return strings.Split(path, ".")
+}
+
+// redactPath removes or masks the field at segments, descending into embedded documents and into
+// every element of arrays on the way
+func redactPath(value interface{}, segments []string, mask interface{}, masking bool) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		redactMap(v, segments, mask, masking)
+	case map[string]interface{}:
+		redactMap(v, segments, mask, masking)
+	case bson.D:
+		for i, elem := range v {
+			if elem.Key != segments[0] {
+				continue
+			}
+			if len(segments) > 1 {
+				v[i].Value = redactPath(elem.Value, segments[1:], mask, masking)
+			} else if masking {
+				v[i].Value = mask
+			} else {
+				return append(v[:i:i], v[i+1:]...)
+			}
+		}
+	case bson.A:
+		for i := range v {
+			v[i] = redactPath(v[i], segments, mask, masking)
+		}
+	}
+	return value
+}
+
+func redactMap(doc map[string]interface{}, segments []string, mask interface{}, masking bool) {
+	child, ok := doc[segments[0]]
+	if !ok {
+		return
+	}
+	if len(segments) > 1 {
+		doc[segments[0]] = redactPath(child, segments[1:], mask, masking)
+	} else if masking {
+		doc[segments[0]] = mask
+	} else {
+		delete(doc, segments[0])
+	}
+}
+
+// CheckDocument refuses a document to insert that sets a field the caller may not write
+func (v View) CheckDocument(doc map[string]interface{}) error {
+	for _, protected := range v.protected {
+		if hasPath(doc, strings.Split(protected, ".")) {
+			return &FieldError{Field: protected, Action: "write"}
+		}
+	}
+	return nil
+}
+
+// CheckReplacement refuses replacing documents when the caller may not write some of their
+// fields, since a replacement rewrites or drops every field
+func (v View) CheckReplacement() error {
+	if len(v.protected) > 0 {
+		return &FieldError{Field: v.protected[0], Action: "write"}
+	}
+	return nil
+}
+
+// CheckUpdate refuses updates that touch a field the caller may not write. data is the $set
+// shorthand of the update endpoints. Pipeline updates can compute any field and are refused
+// outright when the caller may not write some field.
+func (v View) CheckUpdate(data map[string]interface{}, update interface{}) error {
+	if len(v.protected) == 0 && len(v.rowFields) == 0 {
+		return nil
+	}
+	for key := range data {
+		if field, ok := v.unwritable(normalizePath(key)); ok {
+			return &FieldError{Field: field, Action: "write"}
+		}
+	}
+
+	switch u := update.(type) {
+	case nil:
+		return nil
+	case bson.D:
+		for _, operator := range u {
+			fields, ok := operator.Value.(bson.D)
+			if !ok {
+				continue
+			}
+			for _, field := range fields {
+				if protected, ok := v.unwritable(normalizePath(field.Key)); ok {
+					return &FieldError{Field: protected, Action: "write"}
+				}
+				if target, ok := field.Value.(string); ok && operator.Key == "$rename" {
+					if protected, ok := v.unwritable(normalizePath(target)); ok {
+						return &FieldError{Field: protected, Action: "write"}
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: pipeline updates can't be used", ErrRestricted)
+}
+
+// StampDocument sets the fields a row filter requires to equal a value, such as an owner ID, on a
+// document to insert or a replacement so callers can't create documents outside their rows
+func (v View) StampDocument(doc map[string]interface{}) {
+	if doc == nil {
+		return
+	}
+	for _, row := range v.rows {
+		for _, elem := range row {
+			if strings.HasPrefix(elem.Key, "$") || strings.Contains(elem.Key, ".") {
+				continue
+			}
+			if _, isOperator := elem.Value.(bson.D); isOperator {
+				continue
+			}
+			doc[elem.Key] = elem.Value
+		}
+	}
+}
+
+// overlaps reports whether one path contains the other, so reading or writing either touches both
+func overlaps(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// normalizePath drops array indexes and positional operators, such as "items.$[].ssn" or
+// "items.0.ssn", so paths compare by field names only
+func normalizePath(path string) string {
+	segments := strings.Split(path, ".")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, "$") || isIndex(segment) {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+	return strings.Join(kept, ".")
+}
+
+func isIndex(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, c := range segment {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPath(value interface{}, segments []string) bool {
+	if len(segments) == 0 {
+		return true
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[segments[0]]
+		return ok && hasPath(child, segments[1:])
+	case bson.M:
+		child, ok := v[segments[0]]
+		return ok && hasPath(child, segments[1:])
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == segments[0] {
+				return hasPath(elem.Value, segments[1:])
+			}
+		}
+	case bson.A:
+		for _, item := range v {
+			if hasPath(item, segments) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasPath(item, segments) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// substitute replaces the variables of a row filter with the caller's identity
+func substitute(value interface{}, caller Caller) interface{} {
+	switch v := value.(type) {
+	case string:
+		switch v {
+		case VariableUserID:
+			return caller.UserID
+		case VariableOrganizationID:
+			return caller.OrganizationID
+		case VariableRole:
+			return caller.Role
+		}
+		return v
+	case bson.D:
+		replaced := make(bson.D, 0, len(v))
+		for _, elem := range v {
+			replaced = append(replaced, bson.E{Key: elem.Key, Value: substitute(elem.Value, caller)})
+		}
+		return replaced
+	case bson.A:
+		replaced := make(bson.A, 0, len(v))
+		for _, item := range v {
+			replaced = append(replaced, substitute(item, caller))
+		}
+		return replaced
+	}
+	return value
+}
@@ -0,0 +1,361 @@
+package fieldpolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setConfig replaces the active policies for the duration of the test
+func setConfig(t *testing.T, c Config) {
+	t.Helper()
+	saved := config.Load()
+	t.Cleanup(func() { config.Store(saved) })
+	if err := SetConfig(c); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+}
+
+// testConfig restricts shop.customers: only admins read and write ssn, nobody internal, others see
+// contact.phone masked, only admins write notes, and members only see the customers they own
+func testConfig(t *testing.T) {
+	admins := []string{"org:admin"}
+	setConfig(t, Config{Collections: map[string]CollectionPolicy{
+		"shop.customers": {
+			Fields: map[string]FieldRule{
+				"ssn":           {Read: admins, Write: admins},
+				"internal":      {Read: []string{}, Write: []string{}},
+				"contact.phone": {Read: admins, Mask: "***"},
+				"notes":         {Write: admins},
+			},
+			Rows: []RowRule{{Filter: json.RawMessage(`{"ownerId": "$userId"}`), Exempt: admins}},
+		},
+		"shop.orders": {
+			Fields: map[string]FieldRule{"total": {Write: []string{Everyone}}},
+		},
+	}})
+}
+
+// memberView resolves the view of shop.customers for a member
+func memberView(t *testing.T) View {
+	t.Helper()
+	view, err := Resolve(Caller{UserID: "user_1", OrganizationID: "org_1", Role: "org:member"}, "shop", "customers")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	return view
+}
+
+func TestResolve(t *testing.T) {
+	testConfig(t)
+
+	tests := []struct {
+		name       string
+		caller     Caller
+		collection string
+		restricted bool
+	}{
+		{name: "member", caller: Caller{UserID: "user_1", Role: "org:member"}, collection: "customers", restricted: true},
+		{name: "API key", caller: Caller{OrganizationID: "org_1"}, collection: "customers", restricted: true},
+		{name: "admin denied by an empty list", caller: Caller{UserID: "user_2", Role: "org:admin"}, collection: "customers", restricted: true},
+		{name: "everyone may write", caller: Caller{Role: "org:member"}, collection: "orders"},
+		{name: "no policy", caller: Caller{Role: "org:member"}, collection: "products"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view, err := Resolve(tt.caller, "shop", tt.collection)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if view.Restricted() != tt.restricted {
+				t.Errorf("Resolve() restricted = %v, want %v", view.Restricted(), tt.restricted)
+			}
+		})
+	}
+
+	t.Run("unavailable", func(t *testing.T) {
+		saved := config.Load()
+		t.Cleanup(func() { config.Store(saved) })
+		config.Store(nil)
+		if _, err := Resolve(Caller{Role: "org:admin"}, "shop", "products"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Resolve() error = %v, want ErrUnavailable", err)
+		}
+	})
+}
+
+func TestSetConfigInvalidRow(t *testing.T) {
+	err := SetConfig(Config{Collections: map[string]CollectionPolicy{
+		"shop.customers": {Rows: []RowRule{{Filter: json.RawMessage(`{"ownerId": `)}}},
+	}})
+	if err == nil {
+		t.Errorf("SetConfig() with a malformed row filter succeeded")
+	}
+}
+
+func TestCheckQuery(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+
+	tests := []struct {
+		name      string
+		filter    bson.D
+		sort      bson.D
+		wantField string
+		wantErr   error
+	}{
+		{name: "readable field", filter: bson.D{{Key: "name", Value: "a"}}, sort: bson.D{{Key: "notes", Value: 1}}},
+		{name: "hidden field", filter: bson.D{{Key: "ssn", Value: "123"}}, wantField: "ssn"},
+		{name: "dotted path into a hidden field", filter: bson.D{{Key: "ssn.last4", Value: "1234"}}, wantField: "ssn"},
+		{name: "masked field", filter: bson.D{{Key: "contact.phone", Value: bson.D{{Key: "$regex", Value: "^555"}}}}, wantField: "contact.phone"},
+		{name: "parent of a masked field", filter: bson.D{{Key: "contact", Value: bson.D{{Key: "phone", Value: "555"}}}}, wantField: "contact.phone"},
+		{name: "array index before a masked field", filter: bson.D{{Key: "contact.0.phone", Value: "555"}}, wantField: "contact.phone"},
+		{name: "hidden field inside $or", filter: bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "name", Value: "a"}}, bson.D{{Key: "internal", Value: true}}}}}, wantField: "internal"},
+		{name: "hidden field inside $nor", filter: bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "ssn", Value: bson.D{{Key: "$gt", Value: "5"}}}}}}}, wantField: "ssn"},
+		{name: "$expr", filter: bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$ssn", "123"}}}}}, wantErr: ErrRestricted},
+		{name: "$where", filter: bson.D{{Key: "$where", Value: "this.ssn"}}, wantErr: ErrRestricted},
+		{name: "sort by a hidden field", sort: bson.D{{Key: "ssn", Value: 1}}, wantField: "ssn"},
+		{name: "sort by a masked field", sort: bson.D{{Key: "contact.phone", Value: -1}}, wantField: "contact.phone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := view.CheckQuery(tt.filter, tt.sort)
+			switch {
+			case tt.wantField != "":
+				var fieldErr *FieldError
+				if !errors.As(err, &fieldErr) || fieldErr.Field != tt.wantField {
+					t.Errorf("CheckQuery() error = %v, want a field error on %q", err, tt.wantField)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CheckQuery() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("CheckQuery() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestProjection(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+
+	tests := []struct {
+		name       string
+		projection bson.D
+		want       bson.D
+	}{
+		{
+			name: "no projection",
+			want: bson.D{{Key: "internal", Value: 0}, {Key: "ssn", Value: 0}},
+		},
+		{
+			name:       "exclusion",
+			projection: bson.D{{Key: "notes", Value: 0}},
+			want:       bson.D{{Key: "notes", Value: 0}, {Key: "internal", Value: 0}, {Key: "ssn", Value: 0}},
+		},
+		{
+			name:       "exclusion inside a hidden field",
+			projection: bson.D{{Key: "ssn.last4", Value: false}},
+			want:       bson.D{{Key: "internal", Value: 0}, {Key: "ssn", Value: 0}},
+		},
+		{
+			name:       "inclusion of a hidden field",
+			projection: bson.D{{Key: "name", Value: 1}, {Key: "ssn", Value: 1}},
+			want:       bson.D{{Key: "name", Value: 1}},
+		},
+		{
+			name:       "inclusion of a dotted path into a hidden field",
+			projection: bson.D{{Key: "_id", Value: 0}, {Key: "ssn.last4", Value: 1}, {Key: "name", Value: 1}},
+			want:       bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: 1}},
+		},
+		{
+			name:       "inclusion of hidden fields only",
+			projection: bson.D{{Key: "ssn", Value: 1}, {Key: "internal", Value: true}},
+			want:       bson.D{{Key: "_id", Value: 1}},
+		},
+		{
+			// Masked fields come back through the projection and are masked by Redact
+			name:       "inclusion of a masked field",
+			projection: bson.D{{Key: "contact.phone", Value: 1}},
+			want:       bson.D{{Key: "contact.phone", Value: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := view.Projection(tt.projection); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Projection(%v) = %v, want %v", tt.projection, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+
+	tests := []struct {
+		name string
+		doc  bson.M
+		want bson.M
+	}{
+		{
+			name: "hidden and masked fields",
+			doc:  bson.M{"name": "a", "ssn": "123", "internal": true, "contact": bson.M{"phone": "555", "email": "a@b"}},
+			want: bson.M{"name": "a", "contact": bson.M{"phone": "***", "email": "a@b"}},
+		},
+		{
+			name: "masked field in an embedded document",
+			doc:  bson.M{"contact": bson.D{{Key: "phone", Value: "555"}, {Key: "email", Value: "a@b"}}},
+			want: bson.M{"contact": bson.D{{Key: "phone", Value: "***"}, {Key: "email", Value: "a@b"}}},
+		},
+		{
+			name: "masked field in every element of an array",
+			doc:  bson.M{"contact": bson.A{bson.D{{Key: "phone", Value: "555"}}, bson.D{{Key: "phone", Value: "556"}}}},
+			want: bson.M{"contact": bson.A{bson.D{{Key: "phone", Value: "***"}}, bson.D{{Key: "phone", Value: "***"}}}},
+		},
+		{
+			name: "missing masked field stays missing",
+			doc:  bson.M{"contact": bson.M{"email": "a@b"}},
+			want: bson.M{"contact": bson.M{"email": "a@b"}},
+		},
+		{
+			name: "hidden field reached through an included parent",
+			doc:  bson.M{"ssn": bson.D{{Key: "last4", Value: "1234"}}},
+			want: bson.M{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view.Redact(tt.doc)
+			if !reflect.DeepEqual(tt.doc, tt.want) {
+				t.Errorf("Redact() = %v, want %v", tt.doc, tt.want)
+			}
+		})
+	}
+
+	t.Run("nested under a prefix", func(t *testing.T) {
+		entry := bson.M{"document": bson.M{"ssn": "123", "contact": bson.M{"phone": "555"}}, "ssn": "kept"}
+		view.RedactAt(entry, "document")
+		want := bson.M{"document": bson.M{"contact": bson.M{"phone": "***"}}, "ssn": "kept"}
+		if !reflect.DeepEqual(entry, want) {
+			t.Errorf("RedactAt() = %v, want %v", entry, want)
+		}
+	})
+}
+
+func TestRedactValue(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		value    interface{}
+		want     interface{}
+		wantShow bool
+	}{
+		{name: "readable field", path: "name", value: "a", want: "a", wantShow: true},
+		{name: "hidden field", path: "ssn", value: "123"},
+		{name: "dotted path into a hidden field", path: "ssn.last4", value: "1234"},
+		{name: "masked field", path: "contact.phone", value: "555", want: "***", wantShow: true},
+		{name: "array element of a masked field", path: "contact.phone.0", value: "555", want: "***", wantShow: true},
+		{name: "removed masked field", path: "contact.phone", wantShow: true},
+		{
+			name:     "parent of a masked field",
+			path:     "contact",
+			value:    bson.D{{Key: "phone", Value: "555"}, {Key: "email", Value: "a@b"}},
+			want:     bson.D{{Key: "phone", Value: "***"}, {Key: "email", Value: "a@b"}},
+			wantShow: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, show := view.RedactValue(tt.path, tt.value)
+			if show != tt.wantShow || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedactValue(%q) = %v, %v, want %v, %v", tt.path, got, show, tt.want, tt.wantShow)
+			}
+		})
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+
+	tests := []struct {
+		name      string
+		data      map[string]interface{}
+		update    interface{}
+		wantField string
+		wantErr   error
+	}{
+		{name: "writable fields", data: map[string]interface{}{"name": "a"}, update: bson.D{{Key: "$inc", Value: bson.D{{Key: "visits", Value: 1}}}}},
+		{name: "protected field in data", data: map[string]interface{}{"notes": "x"}, wantField: "notes"},
+		{name: "protected field", update: bson.D{{Key: "$set", Value: bson.D{{Key: "ssn", Value: "1"}}}}, wantField: "ssn"},
+		{name: "dotted path into a protected field", update: bson.D{{Key: "$unset", Value: bson.D{{Key: "ssn.last4", Value: ""}}}}, wantField: "ssn"},
+		{name: "positional path into a protected field", update: bson.D{{Key: "$set", Value: bson.D{{Key: "internal.$[].flag", Value: true}}}}, wantField: "internal"},
+		{name: "renamed onto a protected field", update: bson.D{{Key: "$rename", Value: bson.D{{Key: "name", Value: "notes"}}}}, wantField: "notes"},
+		{name: "row field", update: bson.D{{Key: "$set", Value: bson.D{{Key: "ownerId", Value: "user_2"}}}}, wantField: "ownerId"},
+		{name: "pipeline", update: bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "a"}}}}}, wantErr: ErrRestricted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := view.CheckUpdate(tt.data, tt.update)
+			switch {
+			case tt.wantField != "":
+				var fieldErr *FieldError
+				if !errors.As(err, &fieldErr) || fieldErr.Field != tt.wantField {
+					t.Errorf("CheckUpdate() error = %v, want a field error on %q", err, tt.wantField)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CheckUpdate() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("CheckUpdate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestRows(t *testing.T) {
+	testConfig(t)
+	view := memberView(t)
+	row := bson.D{{Key: "ownerId", Value: "user_1"}}
+
+	filter := bson.D{{Key: "status", Value: "active"}}
+	want := bson.D{{Key: "$and", Value: bson.A{filter, row}}}
+	if got := view.ScopeFilter(filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScopeFilter() = %v, want %v", got, want)
+	}
+	if got := view.ScopeFilter(nil); !reflect.DeepEqual(got, bson.D{{Key: "$and", Value: bson.A{row}}}) {
+		t.Errorf("ScopeFilter(nil) = %v, want only the row", got)
+	}
+
+	doc := map[string]interface{}{"name": "a", "ownerId": "user_2"}
+	view.StampDocument(doc)
+	if doc["ownerId"] != "user_1" {
+		t.Errorf("StampDocument() left ownerId = %v, want user_1", doc["ownerId"])
+	}
+
+	if err := view.CheckDocument(map[string]interface{}{"contact": map[string]interface{}{"phone": "555"}}); err != nil {
+		t.Errorf("CheckDocument() of a readable-only field error = %v", err)
+	}
+	var fieldErr *FieldError
+	if err := view.CheckDocument(map[string]interface{}{"internal": bson.D{{Key: "flag", Value: true}}}); !errors.As(err, &fieldErr) {
+		t.Errorf("CheckDocument() of a protected field error = %v, want a field error", err)
+	}
+	if err := view.CheckReplacement(); !errors.As(err, &fieldErr) {
+		t.Errorf("CheckReplacement() error = %v, want a field error", err)
+	}
+}
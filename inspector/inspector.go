@@ -0,0 +1,301 @@
+package inspector
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Limits used when QUERY_MAX_DEPTH, QUERY_MAX_IN_SIZE and QUERY_MAX_REGEX_LENGTH are not set
+const (
+	DefaultMaxDepth       = 32
+	DefaultMaxInSize      = 1000
+	DefaultMaxRegexLength = 512
+)
+
+// DefaultOperators are the operators accepted when QUERY_OPERATOR_ALLOWLIST is not set: query and
+// projection operators, aggregation stages, and aggregation expression and accumulator operators.
+// $where, $function and $accumulator are left out since they run JavaScript on the server.
+var DefaultOperators = []string{
+	// Query and projection operators
+	"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin", "$and", "$or", "$nor", "$not",
+	"$exists", "$type", "$regex", "$options", "$all", "$elemMatch", "$size", "$mod", "$expr",
+	"$text", "$search", "$language", "$caseSensitive", "$diacriticSensitive", "$jsonSchema",
+	"$geoWithin", "$geoIntersects", "$near", "$nearSphere", "$geometry", "$maxDistance",
+	"$minDistance", "$box", "$center", "$centerSphere", "$polygon",
+	"$bitsAllSet", "$bitsAllClear", "$bitsAnySet", "$bitsAnyClear", "$comment", "$meta", "$slice",
+	// Update modifiers
+	"$each", "$position", "$sort",
+	// Aggregation stages
+	"$addFields", "$bucket", "$bucketAuto", "$count", "$densify", "$documents", "$facet", "$fill",
+	"$geoNear", "$graphLookup", "$group", "$limit", "$lookup", "$match", "$merge", "$out", "$project",
+	"$redact", "$replaceRoot", "$replaceWith", "$sample", "$set", "$setWindowFields", "$skip",
+	"$sortByCount", "$unionWith", "$unset", "$unwind",
+	// Arithmetic, array, boolean and comparison expressions
+	"$abs", "$add", "$ceil", "$divide", "$exp", "$floor", "$ln", "$log", "$log10", "$multiply",
+	"$pow", "$round", "$sqrt", "$subtract", "$trunc",
+	"$arrayElemAt", "$arrayToObject", "$concatArrays", "$filter", "$first", "$firstN",
+	"$indexOfArray", "$isArray", "$last", "$lastN", "$map", "$maxN", "$minN", "$objectToArray",
+	"$range", "$reduce", "$reverseArray", "$sortArray", "$zip",
+	"$bitAnd", "$bitNot", "$bitOr", "$bitXor", "$cmp",
+	// Conditional, object, set and variable expressions
+	"$cond", "$ifNull", "$switch", "$let", "$literal", "$getField", "$setField", "$unsetField",
+	"$mergeObjects", "$rand", "$sampleRate", "$binarySize", "$bsonSize",
+	"$allElementsTrue", "$anyElementTrue", "$setDifference", "$setEquals", "$setIntersection",
+	"$setIsSubset", "$setUnion",
+	// Date expressions
+	"$dateAdd", "$dateDiff", "$dateFromParts", "$dateFromString", "$dateSubtract", "$dateToParts",
+	"$dateToString", "$dateTrunc", "$dayOfMonth", "$dayOfWeek", "$dayOfYear", "$hour",
+	"$isoDayOfWeek", "$isoWeek", "$isoWeekYear", "$millisecond", "$minute", "$month", "$second",
+	"$week", "$year",
+	// String expressions
+	"$concat", "$indexOfBytes", "$indexOfCP", "$ltrim", "$regexFind", "$regexFindAll", "$regexMatch",
+	"$replaceOne", "$replaceAll", "$rtrim", "$split", "$strLenBytes", "$strLenCP", "$strcasecmp",
+	"$substr", "$substrBytes", "$substrCP", "$toLower", "$toUpper", "$trim",
+	// Trigonometry expressions
+	"$sin", "$cos", "$tan", "$asin", "$acos", "$atan", "$atan2", "$asinh", "$acosh", "$atanh",
+	"$sinh", "$cosh", "$tanh", "$degreesToRadians", "$radiansToDegrees",
+	// Type expressions
+	"$convert", "$isNumber", "$toBool", "$toDate", "$toDecimal", "$toDouble", "$toInt", "$toLong",
+	"$toObjectId", "$toString", "$toUUID",
+	// Accumulators and window operators
+	"$avg", "$bottom", "$bottomN", "$max", "$median", "$min", "$percentile", "$push", "$addToSet",
+	"$stdDevPop", "$stdDevSamp", "$sum", "$top", "$topN",
+	"$covariancePop", "$covarianceSamp", "$denseRank", "$derivative", "$documentNumber",
+	"$expMovingAvg", "$integral", "$linearFill", "$locf", "$rank", "$shift",
+}
+
+// Config controls what the inspector accepts. A limit of zero disables it.
+type Config struct {
+	Operators      []string
+	MaxDepth       int
+	MaxInSize      int
+	MaxRegexLength int
+}
+
+// Violation is returned for the first part of a request document the inspector refuses. Path is
+// relative to the inspected value, such as "$or[1].name.$regex".
+type Violation struct {
+	Path    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+var (
+	config = Config{
+		Operators:      DefaultOperators,
+		MaxDepth:       DefaultMaxDepth,
+		MaxInSize:      DefaultMaxInSize,
+		MaxRegexLength: DefaultMaxRegexLength,
+	}
+	operators = allowlist(DefaultOperators)
+)
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	loaded := config
+	if configured := os.Getenv("QUERY_OPERATOR_ALLOWLIST"); configured != "" {
+		loaded.Operators = strings.Split(configured, ",")
+	}
+	loaded.MaxDepth = loadLimit("QUERY_MAX_DEPTH", DefaultMaxDepth)
+	loaded.MaxInSize = loadLimit("QUERY_MAX_IN_SIZE", DefaultMaxInSize)
+	loaded.MaxRegexLength = loadLimit("QUERY_MAX_REGEX_LENGTH", DefaultMaxRegexLength)
+	SetConfig(loaded)
+}
+
+func loadLimit(name string, fallback int) int {
+	configured := os.Getenv(name)
+	if configured == "" {
+		return fallback
+	}
+	limit, err := strconv.Atoi(configured)
+	if err != nil || limit < 0 {
+		log.Printf("Warning: ignoring invalid %s %q, using %d", name, configured, fallback)
+		return fallback
+	}
+	return limit
+}
+
+// SetConfig replaces the active inspector config
+func SetConfig(c Config) {
+	config = c
+	operators = allowlist(c.Operators)
+}
+
+func allowlist(names []string) map[string]bool {
+	allowed := map[string]bool{}
+	for _, name := range names {
+		allowed[strings.TrimSpace(name)] = true
+	}
+	return allowed
+}
+
+// Inspect walks a filter, sort, projection or pipeline sent by a client and refuses operators that
+// are not allowlisted, JavaScript values, documents nested deeper than the depth limit, $in, $nin
+// and $all lists over the size limit, and regular expressions over the length limit
+func Inspect(value interface{}) error {
+	return inspect(value, "", 0)
+}
+
+// InspectUpdate inspects an update document or pipeline. The top-level operators of an update
+// document are checked by the update allowlist instead, so only their values are inspected here.
+func InspectUpdate(update interface{}) error {
+	doc, ok := update.(bson.D)
+	if !ok {
+		return Inspect(update)
+	}
+	for _, operator := range doc {
+		if err := inspect(operator.Value, operator.Key, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inspect(value interface{}, path string, depth int) error {
+	switch v := value.(type) {
+	case bson.D:
+		return inspectDocument(v, path, depth+1)
+	case bson.M:
+		return inspectDocument(sortedDocument(v), path, depth+1)
+	case map[string]interface{}:
+		return inspectDocument(sortedDocument(v), path, depth+1)
+	case []bson.D:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return inspectArray(items, path, depth+1)
+	case bson.A:
+		return inspectArray(v, path, depth+1)
+	case []interface{}:
+		return inspectArray(v, path, depth+1)
+	case bson.Regex:
+		return checkRegex(v.Pattern, path)
+	case bson.JavaScript, bson.CodeWithScope:
+		return &Violation{Path: path, Message: "must not contain JavaScript code"}
+	}
+	return nil
+}
+
+func inspectDocument(doc bson.D, path string, depth int) error {
+	if err := checkDepth(path, depth); err != nil {
+		return err
+	}
+	for _, elem := range doc {
+		elemPath := elem.Key
+		if path != "" {
+			elemPath = path + "." + elem.Key
+		}
+
+		if strings.HasPrefix(elem.Key, "$") {
+			if !operators[elem.Key] {
+				return &Violation{Path: elemPath, Message: fmt.Sprintf("operator %s is not allowed", elem.Key)}
+			}
+			switch elem.Key {
+			case "$literal":
+				// Literal values are never evaluated, whatever keys they hold
+				continue
+			case "$in", "$nin", "$all":
+				if err := checkListSize(elem.Value, elemPath); err != nil {
+					return err
+				}
+			case "$regex":
+				if pattern, ok := elem.Value.(string); ok {
+					if err := checkRegex(pattern, elemPath); err != nil {
+						return err
+					}
+				}
+			case "$regexMatch", "$regexFind", "$regexFindAll":
+				if spec, ok := elem.Value.(bson.D); ok {
+					for _, option := range spec {
+						if pattern, ok := option.Value.(string); ok && option.Key == "regex" {
+							if err := checkRegex(pattern, elemPath+".regex"); err != nil {
+								return err
+							}
+						}
+					}
+				}
+			}
+		}
+
+		if err := inspect(elem.Value, elemPath, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inspectArray(items []interface{}, path string, depth int) error {
+	if err := checkDepth(path, depth); err != nil {
+		return err
+	}
+	for i, item := range items {
+		if err := inspect(item, fmt.Sprintf("%s[%d]", path, i), depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDepth(path string, depth int) error {
+	if config.MaxDepth > 0 && depth > config.MaxDepth {
+		return &Violation{Path: path, Message: fmt.Sprintf("must not nest deeper than %d levels", config.MaxDepth)}
+	}
+	return nil
+}
+
+// checkListSize limits the list of a query operator, and the list an aggregation $in expression,
+// {$in: [value, list]}, looks in
+func checkListSize(value interface{}, path string) error {
+	if config.MaxInSize == 0 {
+		return nil
+	}
+	items, _ := value.(bson.A)
+	size := len(items)
+	if len(items) == 2 {
+		if list, ok := items[1].(bson.A); ok && len(list) > size {
+			size = len(list)
+		}
+	}
+	if size > config.MaxInSize {
+		return &Violation{Path: path, Message: fmt.Sprintf("must list at most %d values", config.MaxInSize)}
+	}
+	return nil
+}
+
+func checkRegex(pattern string, path string) error {
+	if config.MaxRegexLength > 0 && len(pattern) > config.MaxRegexLength {
+		return &Violation{Path: path, Message: fmt.Sprintf("must be a regular expression of at most %d characters", config.MaxRegexLength)}
+	}
+	return nil
+}
+
+// sortedDocument orders the keys of a map so violations are reported deterministically
+func sortedDocument(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	doc := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		doc = append(doc, bson.E{Key: key, Value: m[key]})
+	}
+	return doc
+}
@@ -0,0 +1,150 @@
+package inspector
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setConfig replaces the inspector config for the duration of the test
+func setConfig(t *testing.T, c Config) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { SetConfig(saved) })
+	SetConfig(c)
+}
+
+// parse decodes an Extended JSON value the way request bodies are decoded
+func parse(t *testing.T, value string) interface{} {
+	t.Helper()
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(`{"v": `+value+`}`), false, &doc); err != nil {
+		t.Fatalf("parsing %s: %v", value, err)
+	}
+	return doc[0].Value
+}
+
+func TestInspect(t *testing.T) {
+	setConfig(t, Config{Operators: DefaultOperators, MaxDepth: 4, MaxInSize: 3, MaxRegexLength: 5})
+
+	tests := []struct {
+		name     string
+		value    string
+		wantPath string
+		wantOK   bool
+	}{
+		{name: "plain filter", value: `{"status": "active", "age": {"$gte": 18}}`, wantOK: true},
+		{name: "pipeline", value: `[{"$match": {"a": 1}}, {"$group": {"_id": "$a", "n": {"$sum": 1}}}]`, wantOK: true},
+		{name: "$where", value: `{"$where": "sleep(1000)"}`, wantPath: "$where"},
+		{name: "$function in $expr", value: `{"$or": [{"a": 1}, {"$expr": {"$function": {"body": "x", "args": [], "lang": "js"}}}]}`, wantPath: "$or[1].$expr.$function"},
+		{name: "$accumulator in a pipeline", value: `[{"$group": {"_id": null, "x": {"$accumulator": {}}}}]`, wantPath: "[0].$group.x.$accumulator"},
+		{name: "JavaScript value", value: `{"a": {"$code": "function() {}"}}`, wantPath: "a"},
+		{name: "unknown operator", value: `{"a": {"$foo": 1}}`, wantPath: "a.$foo"},
+		{name: "$literal holding operators", value: `{"$expr": {"$eq": ["$a", {"$literal": {"$where": "x"}}]}}`, wantOK: true},
+		{name: "at the depth limit", value: `{"a": {"b": {"c": {"d": 1}}}}`, wantOK: true},
+		{name: "over the depth limit", value: `{"a": {"b": {"c": {"d": {"e": 1}}}}}`, wantPath: "a.b.c.d"},
+		{name: "arrays count as levels", value: `{"a": [[[{"b": 1}]]]}`, wantPath: "a[0][0][0]"},
+		{name: "$in at the size limit", value: `{"a": {"$in": [1, 2, 3]}}`, wantOK: true},
+		{name: "$in over the size limit", value: `{"a": {"$in": [1, 2, 3, 4]}}`, wantPath: "a.$in"},
+		{name: "$nin over the size limit", value: `{"a": {"$nin": [1, 2, 3, 4]}}`, wantPath: "a.$nin"},
+		{name: "aggregation $in over the size limit", value: `{"$expr": {"$in": ["$a", [1, 2, 3, 4]]}}`, wantPath: "$expr.$in"},
+		{name: "short $regex", value: `{"a": {"$regex": "^ab"}}`, wantOK: true},
+		{name: "long $regex", value: `{"a": {"$regex": "^abcdef"}}`, wantPath: "a.$regex"},
+		{name: "long regular expression value", value: `{"a": {"$regularExpression": {"pattern": "(a+)+$", "options": ""}}}`, wantPath: "a"},
+		{name: "long $regexMatch", value: `{"$expr": {"$regexMatch": {"input": "$a", "regex": "(a+)+$"}}}`, wantPath: "$expr.$regexMatch.regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Inspect(parse(t, tt.value))
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("Inspect(%s) error = %v", tt.value, err)
+				}
+				return
+			}
+			var violation *Violation
+			if !errors.As(err, &violation) {
+				t.Fatalf("Inspect(%s) error = %v, want a violation at %q", tt.value, err, tt.wantPath)
+			}
+			if violation.Path != tt.wantPath {
+				t.Errorf("Inspect(%s) violation at %q, want %q", tt.value, violation.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestInspectConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		value  string
+		wantOK bool
+	}{
+		{name: "custom allowlist", config: Config{Operators: []string{"$eq", " $in"}}, value: `{"a": {"$in": [1]}}`, wantOK: true},
+		{name: "operator outside a custom allowlist", config: Config{Operators: []string{"$eq"}}, value: `{"a": {"$gt": 1}}`},
+		{name: "limits disabled", config: Config{Operators: DefaultOperators}, value: `{"a": {"$in": [1, 2, 3, 4, 5, 6], "$regex": "abcdefghijklmnop"}, "b": {"c": {"d": {"e": {"f": 1}}}}}`, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.config)
+			err := Inspect(parse(t, tt.value))
+			if tt.wantOK && err != nil {
+				t.Errorf("Inspect(%s) error = %v", tt.value, err)
+			}
+			if !tt.wantOK && err == nil {
+				t.Errorf("Inspect(%s) accepted, want a violation", tt.value)
+			}
+		})
+	}
+}
+
+func TestInspectUpdate(t *testing.T) {
+	setConfig(t, Config{Operators: DefaultOperators, MaxDepth: 5, MaxInSize: 2})
+
+	tests := []struct {
+		name     string
+		update   string
+		wantPath string
+		wantOK   bool
+	}{
+		{name: "update operators", update: `{"$inc": {"n": 1}, "$set": {"a.b": 2}}`, wantOK: true},
+		{name: "$push modifiers", update: `{"$push": {"tags": {"$each": ["a"], "$position": 0}}}`, wantOK: true},
+		{name: "operator in a value", update: `{"$set": {"a": {"$where": "x"}}}`, wantPath: "$set.a.$where"},
+		{name: "$pull list over the size limit", update: `{"$pull": {"a": {"$in": [1, 2, 3]}}}`, wantPath: "$pull.a.$in"},
+		{name: "value over the depth limit", update: `{"$set": {"a": {"b": {"c": {"d": {"e": 1}}}}}}`, wantPath: "$set.a.b.c.d"},
+		{name: "pipeline", update: `[{"$set": {"a": {"$add": ["$a", 1]}}}]`, wantOK: true},
+		{name: "pipeline with $function", update: `[{"$set": {"a": {"$function": {}}}}]`, wantPath: "[0].$set.a.$function"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := InspectUpdate(parse(t, tt.update))
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("InspectUpdate(%s) error = %v", tt.update, err)
+				}
+				return
+			}
+			var violation *Violation
+			if !errors.As(err, &violation) || violation.Path != tt.wantPath {
+				t.Errorf("InspectUpdate(%s) error = %v, want a violation at %q", tt.update, err, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestInspectMap(t *testing.T) {
+	setConfig(t, Config{Operators: DefaultOperators})
+
+	// Map keys are walked in order, so the first violation is always the same
+	value := bson.M{"b": bson.M{"$where": "x"}, "a": bson.M{"$function": "y"}}
+	for i := 0; i < 10; i++ {
+		var violation *Violation
+		if err := Inspect(value); !errors.As(err, &violation) || violation.Path != "a.$function" {
+			t.Fatalf("Inspect() error = %v, want a violation at a.$function", err)
+		}
+	}
+}
@@ -1,41 +1,120 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	v1 "mongo-manager/api/v1"
+	"mongo-manager/apikey"
+	"mongo-manager/audit"
 	"mongo-manager/auth"
+	"mongo-manager/clerk"
+	"mongo-manager/fieldpolicy"
+	"mongo-manager/mongo"
+	"mongo-manager/rbac"
+	"mongo-manager/requestid"
+	"mongo-manager/verifier"
 	"net/http"
+	"os"
 	"time"
 )
 
+// authenticate is the authentication middleware of the configured mode
+var authenticate func(http.Handler) http.Handler
+
+// mux holds the public routes. Nothing is registered on http.DefaultServeMux, where imported
+// packages may add debugging handlers, so none of those are exposed by accident.
+var mux = http.NewServeMux()
+
 func main() {
+	mode, err := auth.LoadMode()
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	if mode == auth.ModeStaticDev || mode == auth.ModeDisabled {
+		log.Printf("WARNING: authentication mode %q does not authenticate requests, never use it in production", mode)
+	}
+	log.Printf("Authentication mode: %s", mode)
+	if mode == auth.ModeClerk {
+		tokenVerifier, err := verifier.FromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Refusing to start: %v", err)
+		}
+		auth.SetVerifier(tokenVerifier)
+	}
+	auth.SetKeyAuthenticator(apikey.Identify)
+	authenticate = mode.Middleware()
 
-	http.HandleFunc("/health", healthCheck)
+	mux.HandleFunc("/health", healthCheck)
+	mux.HandleFunc("/webhooks/clerk", clerk.WebhookHandler)
 
 	// V1 API
 
-	http.Handle("/v1/get-all", auth.TestingMiddleware(http.HandlerFunc(v1.GetAll)))
-	http.Handle("/v1/get-one", auth.TestingMiddleware(http.HandlerFunc(v1.GetOne)))
-	http.Handle("/v1/insert-one", auth.TestingMiddleware(http.HandlerFunc(v1.InsertOne)))
-	http.Handle("/v1/insert-many", auth.TestingMiddleware(http.HandlerFunc(v1.InsertMany)))
-	http.Handle("/v1/update-one", auth.TestingMiddleware(http.HandlerFunc(v1.UpdateOne)))
-	http.Handle("/v1/update-many", auth.TestingMiddleware(http.HandlerFunc(v1.UpdateMany)))
-	http.Handle("/v1/delete-one", auth.TestingMiddleware(http.HandlerFunc(v1.DeleteOne)))
-	http.Handle("/v1/delete-many", auth.TestingMiddleware(http.HandlerFunc(v1.DeleteMany)))
+	route("/v1/get-all", rbac.Read, v1.GetAll)
+	route("/v1/stream", rbac.Read, v1.Stream)
+	route("/v1/aggregate", rbac.Read, v1.Aggregate)
+	route("/v1/get-one", rbac.Read, v1.GetOne)
+	route("/v1/insert-one", rbac.Insert, v1.InsertOne)
+	route("/v1/insert-many", rbac.Insert, v1.InsertMany)
+	route("/v1/update-one", rbac.Update, v1.UpdateOne)
+	route("/v1/update-many", rbac.Update, v1.UpdateMany)
+	route("/v1/replace-one", rbac.Update, v1.ReplaceOne)
+	route("/v1/find-one-and-update", rbac.Update, v1.FindOneAndUpdate)
+	route("/v1/find-one-and-replace", rbac.Update, v1.FindOneAndReplace)
+	route("/v1/find-one-and-delete", rbac.Delete, v1.FindOneAndDelete)
+	route("/v1/bulk-write", rbac.PerOperation, v1.BulkWrite)
+	route("/v1/transaction", rbac.PerOperation, v1.Transaction)
+	route("/v1/delete-one", rbac.Delete, v1.DeleteOne)
+	route("/v1/delete-many", rbac.Delete, v1.DeleteMany)
+	route("/v1/trash/list", rbac.Read, v1.ListTrash)
+	route("/v1/trash/restore", rbac.Insert, v1.RestoreFromTrash)
+	route("/v1/trash/purge", rbac.Delete, v1.PurgeTrash)
+	route("/v1/history", rbac.Read, v1.History)
+	route("/v1/revert", rbac.Update, v1.Revert)
+	route("/v1/audit/query", rbac.Admin, v1.AuditQuery)
+	route("/v1/api-keys/issue", rbac.Admin, v1.IssueAPIKey)
+	route("/v1/api-keys/list", rbac.Admin, v1.ListAPIKeys)
+	route("/v1/api-keys/revoke", rbac.Admin, v1.RevokeAPIKey)
+	route("/v1/api-keys/rotate", rbac.Admin, v1.RotateAPIKey)
+
+	mongo.StartTrashPurge(context.Background(), mongo.TrashPurgeInterval)
+	fieldpolicy.StartReload(context.Background())
 
 	server := &http.Server{
 		Addr:         ":8080",
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
-		Handler:      http.DefaultServeMux,
+		Handler:      mux,
+	}
+
+	// Metrics are only served on a separate listener, meant to be bound to an internal interface
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", metrics)
+		go func() {
+			log.Printf("Metrics are served on %s", addr)
+			log.Fatal(http.ListenAndServe(addr, metricsMux))
+		}()
 	}
 
 	log.Printf("Server is running on port %s", server.Addr)
 	log.Fatal(server.ListenAndServe())
 }
 
+// route registers a v1 handler behind the request ID, authentication, audit and permission middleware
+func route(path string, permission rbac.Permission, handler http.HandlerFunc) {
+	mux.Handle(path, requestid.Middleware(authenticate(audit.Middleware(v1.RequirePermission(permission, handler)))))
+}
+
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+func metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"membership_cache": clerk.CacheMetrics()}); err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+}
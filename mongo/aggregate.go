@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"mongo-manager/types"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Aggregate runs the request pipeline and hands each resulting document to fn as it is read
+// from the cursor. MaxTimeMS bounds the whole operation through the context deadline.
+func Aggregate(ctx context.Context, request types.AggregateRequest, fn func(bson.M) error) error {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	if request.MaxTimeMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(request.MaxTimeMS)*time.Millisecond)
+		defer cancel()
+	}
+
+	opts := options.Aggregate().
+		SetAllowDiskUse(request.AllowDiskUse).
+		SetBatchSize(StreamBatchSize)
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+	if request.Hint != nil {
+		opts.SetHint(request.Hint)
+	}
+
+	pipeline := request.Pipeline
+	if pipeline == nil {
+		pipeline = []bson.D{}
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		log.Printf("Error running aggregation: %v", err)
+		return err
+	}
+
+	return drainCursor(ctx, cursor, fn)
+}
+
+// toCollation converts the request collation into the driver representation
+func toCollation(collation *types.Collation) *options.Collation {
+	if collation == nil {
+		return nil
+	}
+	return &options.Collation{
+		Locale:          collation.Locale,
+		CaseLevel:       collation.CaseLevel,
+		CaseFirst:       collation.CaseFirst,
+		Strength:        collation.Strength,
+		NumericOrdering: collation.NumericOrdering,
+		Alternate:       collation.Alternate,
+		MaxVariable:     collation.MaxVariable,
+		Normalization:   collation.Normalization,
+		Backwards:       collation.Backwards,
+	}
+}
@@ -0,0 +1,191 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/types"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MaxBulkOperations caps the number of operations accepted in a single bulk write
+const MaxBulkOperations = 10000
+
+// BulkWrite executes the operations with collection.BulkWrite and reports counts, the IDs of
+// inserted and upserted documents, and every per-operation write error. Write errors are part of
+// the report rather than the returned error, which is reserved for requests that could not run at all.
+func BulkWrite(ctx context.Context, request types.BulkWriteRequest) (types.BulkWriteReport, error) {
+	ordered := request.Ordered == nil || *request.Ordered
+	report := types.BulkWriteReport{
+		Ordered:     ordered,
+		InsertedIDs: []types.IndexedID{},
+		UpsertedIDs: []types.IndexedID{},
+		WriteErrors: []types.BulkWriteError{},
+	}
+
+	if len(request.Operations) == 0 {
+		return report, fmt.Errorf("%w: at least one operation is required", ErrInvalidOperation)
+	}
+	if len(request.Operations) > MaxBulkOperations {
+		return report, fmt.Errorf("%w: a bulk write accepts at most %d operations", ErrInvalidOperation, MaxBulkOperations)
+	}
+
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	models := make([]mongo.WriteModel, 0, len(request.Operations))
+	insertedIDs := map[int]interface{}{}
+	for i, operation := range request.Operations {
+		model, insertedID, err := writeModel(request.Database, request.Collection, operation)
+		if err != nil {
+			return report, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if insertedID != nil {
+			insertedIDs[i] = insertedID
+		}
+		models = append(models, model)
+	}
+
+	result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		log.Printf("Error running bulk write: %v", err)
+		return report, err
+	}
+
+	if result != nil {
+		report.InsertedCount = result.InsertedCount
+		report.MatchedCount = result.MatchedCount
+		report.ModifiedCount = result.ModifiedCount
+		report.DeletedCount = result.DeletedCount
+		report.UpsertedCount = result.UpsertedCount
+		for index, id := range result.UpsertedIDs {
+			report.UpsertedIDs = append(report.UpsertedIDs, types.IndexedID{Index: int(index), ID: id})
+		}
+	}
+
+	failed := map[int]bool{}
+	firstFailure := len(request.Operations)
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = true
+		if writeErr.Index < firstFailure {
+			firstFailure = writeErr.Index
+		}
+		report.WriteErrors = append(report.WriteErrors, types.BulkWriteError{
+			Index:   writeErr.Index,
+			Code:    writeErr.Code,
+			Message: writeErr.Message,
+		})
+	}
+	if bulkErr.WriteConcernError != nil {
+		report.WriteConcernError = bulkErr.WriteConcernError.Message
+	}
+
+	for index, id := range insertedIDs {
+		// Ordered writes stop at the first failure, so later inserts never ran
+		if failed[index] || (ordered && index > firstFailure) {
+			continue
+		}
+		report.InsertedIDs = append(report.InsertedIDs, types.IndexedID{Index: index, ID: id})
+	}
+
+	sort.Slice(report.InsertedIDs, func(i, j int) bool { return report.InsertedIDs[i].Index < report.InsertedIDs[j].Index })
+	sort.Slice(report.UpsertedIDs, func(i, j int) bool { return report.UpsertedIDs[i].Index < report.UpsertedIDs[j].Index })
+	sort.Slice(report.WriteErrors, func(i, j int) bool { return report.WriteErrors[i].Index < report.WriteErrors[j].Index })
+
+	return report, nil
+}
+
+// writeModel converts an operation into a driver write model. Documents inserted without an _id
+// get one generated here so the report can tell clients which ID each inserted row received.
+func writeModel(database, collection string, operation types.WriteOperation) (mongo.WriteModel, interface{}, error) {
+	filter, err := NormalizeFilter(database, collection, operation.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	if (operation.Type == OperationDeleteOne || operation.Type == OperationDeleteMany) && SoftDeleteEnabled(database, collection) {
+		// Bulk writes cannot move documents to the trash atomically, so they must not bypass it
+		return nil, nil, fmt.Errorf("%w: %s.%s uses soft delete, use delete-one, delete-many or a transaction instead", ErrInvalidOperation, database, collection)
+	}
+
+	if operation.Type != OperationInsertOne && HistoryEnabled(database, collection) {
+		// Nor can they record the prior versions of the documents they change
+		return nil, nil, fmt.Errorf("%w: %s.%s records revision history, use the single-document endpoints or a transaction instead", ErrInvalidOperation, database, collection)
+	}
+
+	switch operation.Type {
+	case OperationInsertOne:
+		if operation.Document == nil {
+			return nil, nil, fmt.Errorf("%w: insertOne requires a document", ErrInvalidOperation)
+		}
+		id, ok := operation.Document["_id"]
+		if !ok {
+			id = bson.NewObjectID()
+			operation.Document["_id"] = id
+		}
+		return mongo.NewInsertOneModel().SetDocument(operation.Document), id, nil
+
+	case OperationUpdateOne:
+		update, err := BuildUpdate(nil, operation.Update)
+		if err != nil {
+			return nil, nil, err
+		}
+		model := mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(operation.Upsert)
+		if len(operation.ArrayFilters) > 0 {
+			model.SetArrayFilters(arrayFilters(operation.ArrayFilters))
+		}
+		if operation.Collation != nil {
+			model.SetCollation(toCollation(operation.Collation))
+		}
+		return model, nil, nil
+
+	case OperationUpdateMany:
+		update, err := BuildUpdate(nil, operation.Update)
+		if err != nil {
+			return nil, nil, err
+		}
+		model := mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(update).SetUpsert(operation.Upsert)
+		if len(operation.ArrayFilters) > 0 {
+			model.SetArrayFilters(arrayFilters(operation.ArrayFilters))
+		}
+		if operation.Collation != nil {
+			model.SetCollation(toCollation(operation.Collation))
+		}
+		return model, nil, nil
+
+	case OperationReplaceOne:
+		if err := validateReplacement(operation.Replacement); err != nil {
+			return nil, nil, err
+		}
+		model := mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(operation.Replacement).SetUpsert(operation.Upsert)
+		if operation.Collation != nil {
+			model.SetCollation(toCollation(operation.Collation))
+		}
+		return model, nil, nil
+
+	case OperationDeleteOne:
+		model := mongo.NewDeleteOneModel().SetFilter(filter)
+		if operation.Collation != nil {
+			model.SetCollation(toCollation(operation.Collation))
+		}
+		return model, nil, nil
+
+	case OperationDeleteMany:
+		model := mongo.NewDeleteManyModel().SetFilter(filter)
+		if operation.Collation != nil {
+			model.SetCollation(toCollation(operation.Collation))
+		}
+		return model, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: operation type %q is not supported in bulk writes", ErrInvalidOperation, operation.Type)
+}
@@ -2,9 +2,11 @@ package mongo
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"mongo-manager/types"
 	"os"
+	"testing"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -16,10 +18,20 @@ import (
 var Client *mongo.Client
 
 func init() {
-	if err := godotenv.Load(); err != nil {
+	// Unit tests cover the helpers that don't talk to the server, so they run without .env or a database
+	unitTest := testing.Testing()
+	if err := godotenv.Load(); err != nil && !unitTest {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	loadTypeHints()
+	loadUpdateOperators()
+	loadSoftDeleteConfig()
+	loadHistoryConfig()
+	if unitTest {
+		return
+	}
+
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 	opts := options.Client().ApplyURI(os.Getenv("MONGO_URI")).SetServerAPIOptions(serverAPI)
 
@@ -34,44 +46,131 @@ func init() {
 	}
 }
 
-func GetAll(request types.Request) ([]bson.M, error) {
+// GetAll returns one page of the documents matching the request filter.
+// Pages are ordered by the requested sort with _id as a tiebreaker, and the returned
+// NextCursor continues after the last document using a keyset condition rather than a skip,
+// so deep pages stay as cheap as the first one. When the sort fields hold values a keyset
+// condition can't order, such as arrays, the cursor continues by position instead.
+func GetAll(request types.Request) (types.Page, error) {
 	collection := Client.Database(request.Database).Collection(request.Collection)
 
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return types.Page{}, err
+	}
 	// Ensure we never pass a nil top-level filter; MongoDB requires a document, not null
-	filter := request.Filter
 	if filter == nil {
 		filter = bson.D{}
 	}
-	cursor, err := collection.Find(context.TODO(), filter)
+
+	if request.Limit < 0 || request.Skip < 0 {
+		return types.Page{}, fmt.Errorf("%w: limit and skip must not be negative", ErrInvalidPagination)
+	}
+	if request.Cursor != "" && request.Skip > 0 {
+		// The cursor already marks where the page starts; skipping on top of it would drop
+		// documents from every page after the first
+		return types.Page{}, fmt.Errorf("%w: skip can't be combined with cursor", ErrInvalidPagination)
+	}
+	limit := request.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	keys, err := normalizeSort(request.Sort)
+	if err != nil {
+		return types.Page{}, err
+	}
+
+	page := types.Page{Items: []bson.M{}}
+
+	if request.IncludeTotal {
+		total, err := collection.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			log.Printf("Error counting documents: %v", err)
+			return types.Page{}, err
+		}
+		page.Total = &total
+	}
+
+	// start is the position of the page's first document, which positional cursors continue from
+	pageFilter, start := filter, request.Skip
+	var previous *pageCursor
+	if request.Cursor != "" {
+		cursor, err := decodeCursor(request.Cursor, keys)
+		if err != nil {
+			return types.Page{}, err
+		}
+		if cursor.positional() {
+			start = cursor.Offset
+		} else {
+			pageFilter = bson.D{{Key: "$and", Value: bson.A{filter, keysetFilter(keys, cursor)}}}
+		}
+		previous = &cursor
+	}
+
+	projection, added := ensureSortFieldsProjected(request.Projection, keys)
+
+	// Fetch one extra document to find out whether another page exists
+	opts := options.Find().
+		SetSort(sortDocument(keys)).
+		SetSkip(start).
+		SetLimit(limit + 1)
+	if len(projection) > 0 {
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := collection.Find(context.TODO(), pageFilter, opts)
 
 	if err != nil {
 		log.Printf("Error finding documents: %v", err)
-		return nil, err
+		return types.Page{}, err
 	}
 
 	var docs []bson.M
 	if err := cursor.All(context.TODO(), &docs); err != nil {
 		log.Printf("Error decoding documents: %v", err)
-		return nil, err
+		return types.Page{}, err
 	}
 
-	if len(docs) == 0 {
-		return []bson.M{}, nil
+	if int64(len(docs)) > limit {
+		docs = docs[:limit]
+		next, err := nextCursor(context.TODO(), collection, filter, keys, previous, docs[len(docs)-1], start+limit)
+		if err != nil {
+			log.Printf("Error encoding cursor: %v", err)
+			return types.Page{}, err
+		}
+		page.NextCursor = &next
+	}
+
+	for _, doc := range docs {
+		for _, field := range added {
+			removePath(doc, field)
+		}
 	}
 
-	return docs, nil
+	if len(docs) > 0 {
+		page.Items = docs
+	}
+
+	return page, nil
 }
 
 func GetOne(request types.Request) (bson.M, error) {
 	collection := Client.Database(request.Database).Collection(request.Collection)
 
-	filter := request.Filter
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return bson.M{}, err
+	}
 	if filter == nil {
 		filter = bson.D{}
 	}
 
 	doc := bson.M{}
-	err := collection.FindOne(context.TODO(), filter).Decode(&doc)
+	err = collection.FindOne(context.TODO(), filter).Decode(&doc)
 
 	if err == mongo.ErrNoDocuments {
 		return bson.M{}, nil
@@ -113,11 +212,34 @@ func UpdateOne(request types.UpdateOneRequest) (*mongo.UpdateResult, error) {
 	objId, err := bson.ObjectIDFromHex(request.ObjectId)
 	if err != nil {
 		log.Printf("Error converting object ID: %v", err)
+		return nil, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+	}
+	update, err := BuildUpdate(request.Data, request.Update)
+	if err != nil {
 		return nil, err
 	}
-	update := bson.D{{Key: "$set", Value: request.Data}}
+	filter := append(bson.D{{Key: "_id", Value: objId}}, request.Scope...)
+
+	opts := options.UpdateOne().SetUpsert(request.Upsert)
+	if len(request.ArrayFilters) > 0 {
+		opts.SetArrayFilters(arrayFilters(request.ArrayFilters))
+	}
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		result, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, nil, true, HistoryUpdate, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return collection.UpdateOne(ctx, filter, update, opts)
+		})
+		if err != nil {
+			log.Printf("Error updating document: %v", err)
+			return nil, err
+		}
+		return result.(*mongo.UpdateResult), nil
+	}
 
-	result, err := collection.UpdateOne(context.TODO(), bson.M{"_id": objId}, update)
+	result, err := collection.UpdateOne(context.TODO(), filter, update, opts)
 	if err != nil {
 		log.Printf("Error updating document: %v", err)
 		return nil, err
@@ -128,13 +250,38 @@ func UpdateOne(request types.UpdateOneRequest) (*mongo.UpdateResult, error) {
 func UpdateMany(request types.UpdateManyRequest) (*mongo.UpdateResult, error) {
 	collection := Client.Database(request.Database).Collection(request.Collection)
 
-	filter := request.Filter
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
 	if filter == nil {
 		filter = bson.D{}
 	}
-	update := bson.D{{Key: "$set", Value: request.Data}}
+	update, err := BuildUpdate(request.Data, request.Update)
+	if err != nil {
+		return nil, err
+	}
 
-	result, err := collection.UpdateMany(context.TODO(), filter, update)
+	opts := options.UpdateMany().SetUpsert(request.Upsert)
+	if len(request.ArrayFilters) > 0 {
+		opts.SetArrayFilters(arrayFilters(request.ArrayFilters))
+	}
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		result, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, nil, false, HistoryUpdate, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return collection.UpdateMany(ctx, filter, update, opts)
+		})
+		if err != nil {
+			log.Printf("Error updating documents: %v", err)
+			return nil, err
+		}
+		return result.(*mongo.UpdateResult), nil
+	}
+
+	result, err := collection.UpdateMany(context.TODO(), filter, update, opts)
 	if err != nil {
 		log.Printf("Error updating documents: %v", err)
 		return nil, err
@@ -148,10 +295,26 @@ func DeleteOne(request types.DeleteOneRequest) (*mongo.DeleteResult, error) {
 	objId, err := bson.ObjectIDFromHex(request.ObjectId)
 	if err != nil {
 		log.Printf("Error converting object ID: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+	}
+
+	filter := append(bson.D{{Key: "_id", Value: objId}}, request.Scope...)
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		result, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, nil, nil, true, HistoryDelete, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return deleteInTransaction(ctx, request.Database, request.Collection, filter, true, request.Actor)
+		})
+		if err != nil {
+			log.Printf("Error deleting documents: %v", err)
+			return nil, err
+		}
+		return result.(*mongo.DeleteResult), nil
+	}
+	if SoftDeleteEnabled(request.Database, request.Collection) {
+		return softDelete(context.TODO(), request.Database, request.Collection, filter, true, request.Actor)
 	}
 
-	result, err := collection.DeleteOne(context.TODO(), bson.M{"_id": objId})
+	result, err := collection.DeleteOne(context.TODO(), filter)
 	if err != nil {
 		log.Printf("Error deleting document: %v", err)
 		return nil, err
@@ -162,33 +325,26 @@ func DeleteOne(request types.DeleteOneRequest) (*mongo.DeleteResult, error) {
 func DeleteMany(request types.DeleteManyRequest) (*mongo.DeleteResult, error) {
 	collection := Client.Database(request.Database).Collection(request.Collection)
 
-	filter := request.Filter
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
 	if filter == nil {
 		filter = bson.D{}
 	}
 
-	// Convert string _id to ObjectID if present
-	// Convert bson.D to bson.M for easier manipulation
-	filterMap := bson.M{}
-	for _, elem := range filter {
-		filterMap[elem.Key] = elem.Value
-	}
-
-	if idValue, exists := filterMap["_id"]; exists {
-		if idStr, ok := idValue.(string); ok {
-			objId, err := bson.ObjectIDFromHex(idStr)
-			if err != nil {
-				log.Printf("Error converting object ID: %v", err)
-				return nil, err
-			}
-			filterMap["_id"] = objId
+	if HistoryEnabled(request.Database, request.Collection) {
+		result, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, nil, nil, false, HistoryDelete, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return deleteInTransaction(ctx, request.Database, request.Collection, filter, false, request.Actor)
+		})
+		if err != nil {
+			log.Printf("Error deleting documents: %v", err)
+			return nil, err
 		}
+		return result.(*mongo.DeleteResult), nil
 	}
-
-	// Convert back to bson.D
-	filter = bson.D{}
-	for key, value := range filterMap {
-		filter = append(filter, bson.E{Key: key, Value: value})
+	if SoftDeleteEnabled(request.Database, request.Collection) {
+		return softDelete(context.TODO(), request.Database, request.Collection, filter, false, request.Actor)
 	}
 
 	result, err := collection.DeleteMany(context.TODO(), filter)
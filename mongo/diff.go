@@ -0,0 +1,73 @@
+package mongo
+
+import (
+	"mongo-manager/types"
+	"reflect"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DiffDocuments lists the fields that differ between two versions of a document, with dotted paths
+// for fields of embedded documents. Arrays and other values are compared as a whole. Either side may
+// be nil, in which case every field of the other side is reported as added or removed.
+func DiffDocuments(before, after map[string]interface{}) []types.FieldChange {
+	changes := []types.FieldChange{}
+	diffFields(before, after, "", &changes)
+	return changes
+}
+
+func diffFields(before, after map[string]interface{}, prefix string, changes *[]types.FieldChange) {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := prefix + key
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+
+		oldDoc, oldIsDoc := asMap(oldValue)
+		newDoc, newIsDoc := asMap(newValue)
+		if hadOld && hasNew && oldIsDoc && newIsDoc {
+			diffFields(oldDoc, newDoc, path+".", changes)
+			continue
+		}
+
+		if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		change := types.FieldChange{Path: path}
+		if hadOld {
+			change.Before = oldValue
+		}
+		if hasNew {
+			change.After = newValue
+		}
+		*changes = append(*changes, change)
+	}
+}
+
+// asMap returns the fields of an embedded document regardless of how it was decoded
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case bson.M:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	case bson.D:
+		fields := make(map[string]interface{}, len(v))
+		for _, elem := range v {
+			fields[elem.Key] = elem.Value
+		}
+		return fields, true
+	}
+	return nil, false
+}
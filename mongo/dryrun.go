@@ -0,0 +1,338 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mongo-manager/types"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// DryRunUpdateOne previews UpdateOne without writing
+func DryRunUpdateOne(ctx context.Context, request types.UpdateOneRequest) (types.DryRunResult, error) {
+	objId, err := bson.ObjectIDFromHex(request.ObjectId)
+	if err != nil {
+		return types.DryRunResult{}, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+	}
+	update, err := BuildUpdate(request.Data, request.Update)
+	if err != nil {
+		return types.DryRunResult{}, err
+	}
+
+	return dryRun(ctx, request.Database, types.WriteOperation{
+		Type:         OperationUpdateOne,
+		Collection:   request.Collection,
+		Filter:       append(bson.D{{Key: "_id", Value: objId}}, request.Scope...),
+		Update:       update,
+		ArrayFilters: request.ArrayFilters,
+		Upsert:       request.Upsert,
+		Collation:    request.Collation,
+	})
+}
+
+// DryRunUpdateMany previews UpdateMany without writing
+func DryRunUpdateMany(ctx context.Context, request types.UpdateManyRequest) (types.DryRunResult, error) {
+	update, err := BuildUpdate(request.Data, request.Update)
+	if err != nil {
+		return types.DryRunResult{}, err
+	}
+
+	return dryRun(ctx, request.Database, types.WriteOperation{
+		Type:         OperationUpdateMany,
+		Collection:   request.Collection,
+		Filter:       request.Filter,
+		Update:       update,
+		ArrayFilters: request.ArrayFilters,
+		Upsert:       request.Upsert,
+		Collation:    request.Collation,
+	})
+}
+
+// DryRunDeleteOne previews DeleteOne without writing
+func DryRunDeleteOne(ctx context.Context, request types.DeleteOneRequest) (types.DryRunResult, error) {
+	objId, err := bson.ObjectIDFromHex(request.ObjectId)
+	if err != nil {
+		return types.DryRunResult{}, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+	}
+
+	return dryRun(ctx, request.Database, types.WriteOperation{
+		Type:       OperationDeleteOne,
+		Collection: request.Collection,
+		Filter:     append(bson.D{{Key: "_id", Value: objId}}, request.Scope...),
+	})
+}
+
+// DryRunDeleteMany previews DeleteMany without writing
+func DryRunDeleteMany(ctx context.Context, request types.DeleteManyRequest) (types.DryRunResult, error) {
+	return dryRun(ctx, request.Database, types.WriteOperation{
+		Type:       OperationDeleteMany,
+		Collection: request.Collection,
+		Filter:     request.Filter,
+	})
+}
+
+// DryRunBulkWrite previews every operation of a bulk write without writing. Each operation is
+// previewed against the current data, so the effect of earlier operations in the same request on
+// later ones is not reflected.
+func DryRunBulkWrite(ctx context.Context, request types.BulkWriteRequest) (types.BulkDryRunReport, error) {
+	report := types.BulkDryRunReport{DryRun: true, Operations: []types.DryRunResult{}}
+
+	if len(request.Operations) == 0 {
+		return report, fmt.Errorf("%w: at least one operation is required", ErrInvalidOperation)
+	}
+	if len(request.Operations) > MaxBulkOperations {
+		return report, fmt.Errorf("%w: a bulk write accepts at most %d operations", ErrInvalidOperation, MaxBulkOperations)
+	}
+
+	for i, operation := range request.Operations {
+		operation.Collection = request.Collection
+		result, err := dryRun(ctx, request.Database, operation)
+		if err != nil {
+			return report, fmt.Errorf("operation %d: %w", i, err)
+		}
+		report.Operations = append(report.Operations, result)
+	}
+	return report, nil
+}
+
+// dryRun previews a single write operation. Matching documents are counted and sampled with
+// PreviewImpact, or with sampleOne for single-document operations; simulateUpdate then computes
+// how updates and replacements would change the sample.
+func dryRun(ctx context.Context, database string, operation types.WriteOperation) (types.DryRunResult, error) {
+	result := types.DryRunResult{DryRun: true, Type: operation.Type, SampleIDs: []interface{}{}}
+
+	single := false
+	switch operation.Type {
+	case OperationInsertOne:
+		if operation.Document == nil {
+			return result, fmt.Errorf("%w: insertOne requires a document", ErrInvalidOperation)
+		}
+		result.Changes = []types.DocumentChange{{ID: operation.Document["_id"], Fields: DiffDocuments(nil, operation.Document)}}
+		return result, nil
+	case OperationUpdateOne, OperationReplaceOne, OperationDeleteOne:
+		single = true
+	case OperationUpdateMany, OperationDeleteMany:
+	default:
+		return result, fmt.Errorf("%w: operation type %q does not support dry runs", ErrInvalidOperation, operation.Type)
+	}
+
+	filter, err := NormalizeFilter(database, operation.Collection, operation.Filter)
+	if err != nil {
+		return result, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	if single {
+		result.MatchedCount, result.SampleIDs, result.Approximate, err = sampleOne(ctx, database, operation.Collection, filter, operation.Collation)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		preview, err := PreviewImpact(ctx, database, operation.Collection, filter, operation.Collation)
+		if err != nil {
+			return result, err
+		}
+		result.MatchedCount = preview.MatchedCount
+		result.SampleIDs = preview.SampleIDs
+	}
+
+	if operation.Type == OperationDeleteOne || operation.Type == OperationDeleteMany {
+		return result, nil
+	}
+
+	changes, upserted, err := simulateUpdate(ctx, database, filter, result.SampleIDs, operation)
+	if err != nil {
+		return result, err
+	}
+	result.Changes = changes
+	result.WouldUpsert = upserted
+	if operation.Type != OperationReplaceOne {
+		result.Approximate = true
+	}
+	return result, nil
+}
+
+// sampleOne picks the document a single-document write would most likely affect: the first one a
+// find with the same filter and collation and no sort returns, as UpdateOne, ReplaceOne and
+// DeleteOne don't sort either. ambiguous is set when several documents match, since the write's
+// own query plan may then pick another one.
+func sampleOne(ctx context.Context, database, collection string, filter bson.D, collation *types.Collation) (matched int64, ids []interface{}, ambiguous bool, err error) {
+	coll := Client.Database(database).Collection(collection)
+
+	countOpts := options.Count().SetLimit(2)
+	findOpts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	if collation != nil {
+		countOpts.SetCollation(toCollation(collation))
+		findOpts.SetCollation(toCollation(collation))
+	}
+
+	count, err := coll.CountDocuments(ctx, filter, countOpts)
+	if err != nil {
+		log.Printf("Error counting documents: %v", err)
+		return 0, nil, false, err
+	}
+	doc, err := decodeSingleResult(coll.FindOne(ctx, filter, findOpts))
+	if err != nil {
+		log.Printf("Error sampling documents: %v", err)
+		return 0, nil, false, err
+	}
+	if doc == nil {
+		return 0, []interface{}{}, false, nil
+	}
+	return 1, []interface{}{doc["_id"]}, count > 1, nil
+}
+
+// simulateUpdate reports how the documents with the given _ids would change under an update or
+// replacement, without writing anything. Replacements are applied in memory; updates are translated
+// by updateStages into an aggregation over the sampled documents, so no write locks are taken.
+// The translation approximates the update operators, and updates it would get wrong, such as
+// dotted paths through arrays of the sampled documents, are refused. When nothing matched and the
+// operation upserts, the document that would be inserted is reported instead, computed with
+// $documents from the filter's equality conditions.
+func simulateUpdate(ctx context.Context, database string, filter bson.D, ids []interface{}, operation types.WriteOperation) ([]types.DocumentChange, bool, error) {
+	replace := operation.Type == OperationReplaceOne
+	var update interface{}
+	var stages []bson.D
+	if replace {
+		if err := validateReplacement(operation.Replacement); err != nil {
+			return nil, false, err
+		}
+	} else {
+		var err error
+		if update, err = BuildUpdate(nil, operation.Update); err != nil {
+			return nil, false, err
+		}
+		if len(operation.ArrayFilters) > 0 {
+			return nil, false, fmt.Errorf("%w: dry runs can't preview updates with arrayFilters", ErrInvalidOperation)
+		}
+		if stages, err = updateStages(update, len(ids) == 0); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if len(ids) == 0 && !operation.Upsert {
+		return []types.DocumentChange{}, false, nil
+	}
+
+	opts := options.Aggregate()
+	if operation.Collation != nil {
+		opts.SetCollation(toCollation(operation.Collation))
+	}
+
+	if len(ids) == 0 {
+		seed := upsertDocument(filter)
+		var after bson.M
+		if replace {
+			after = replacedDocument(operation.Replacement, seed["_id"])
+		} else {
+			if err := checkArrayPaths([]bson.M{seed}, updatePaths(update)); err != nil {
+				return nil, false, err
+			}
+			pipeline := append([]bson.D{{{Key: "$documents", Value: bson.A{seed}}}}, stages...)
+			cursor, err := Client.Database(database).Aggregate(ctx, pipeline, opts)
+			if err != nil {
+				log.Printf("Error previewing upsert: %v", err)
+				return nil, false, err
+			}
+			docs := []bson.M{}
+			if err := cursor.All(ctx, &docs); err != nil {
+				return nil, false, err
+			}
+			if len(docs) != 1 {
+				return nil, false, fmt.Errorf("previewing the upsert returned %d documents", len(docs))
+			}
+			after = docs[0]
+		}
+		return []types.DocumentChange{{ID: seed["_id"], Fields: DiffDocuments(nil, after)}}, true, nil
+	}
+
+	collection := Client.Database(database).Collection(operation.Collection)
+	before, err := findByIDs(ctx, collection, ids, operation.Collation)
+	if err != nil {
+		return nil, false, err
+	}
+	if !replace {
+		if err := checkArrayPaths(before, updatePaths(update)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	after := make([]bson.M, 0, len(before))
+	if replace {
+		for _, doc := range before {
+			after = append(after, replacedDocument(operation.Replacement, doc["_id"]))
+		}
+	} else {
+		// Both reads sort on _id with the same collation, and the update stages keep one document
+		// per input, so the after-images line up with before
+		pipeline := append([]bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		}, stages...)
+		cursor, err := collection.Aggregate(ctx, pipeline, opts)
+		if err != nil {
+			log.Printf("Error previewing update: %v", err)
+			return nil, false, err
+		}
+		if err := cursor.All(ctx, &after); err != nil {
+			return nil, false, err
+		}
+		if len(after) != len(before) {
+			return nil, false, fmt.Errorf("documents changed while previewing the update, %d read and %d updated", len(before), len(after))
+		}
+		// Updates never change _id, even when a pipeline drops it
+		for i := range after {
+			after[i]["_id"] = before[i]["_id"]
+		}
+	}
+
+	changes := make([]types.DocumentChange, 0, len(before))
+	for i, doc := range before {
+		changes = append(changes, types.DocumentChange{ID: doc["_id"], Fields: DiffDocuments(doc, after[i])})
+	}
+	return changes, false, nil
+}
+
+// replacedDocument returns the document a replacement leaves behind, which keeps the original _id
+func replacedDocument(replacement map[string]interface{}, id interface{}) bson.M {
+	doc := bson.M{}
+	for key, value := range replacement {
+		doc[key] = value
+	}
+	doc["_id"] = id
+	return doc
+}
+
+func findByIDs(ctx context.Context, collection *mongo.Collection, ids []interface{}, collation *types.Collation) ([]bson.M, error) {
+	docs := []bson.M{}
+	if len(ids) == 0 {
+		return docs, nil
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if collation != nil {
+		opts.SetCollation(toCollation(collation))
+	}
+	cursor, err := collection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func documentWithID(docs []bson.M, id interface{}) bson.M {
+	for _, doc := range docs {
+		if reflect.DeepEqual(doc["_id"], id) {
+			return doc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/types"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// returnDocument converts the request value into the driver option, defaulting to the document before the change
+func returnDocument(value string) (options.ReturnDocument, error) {
+	switch strings.ToLower(value) {
+	case "", "before":
+		return options.Before, nil
+	case "after":
+		return options.After, nil
+	}
+	return options.Before, fmt.Errorf("%w: returnDocument must be \"before\" or \"after\"", ErrInvalidUpdate)
+}
+
+// validateReplacement rejects replacement documents that contain update operators
+func validateReplacement(replacement map[string]interface{}) error {
+	if replacement == nil {
+		return fmt.Errorf("%w: replacement is required", ErrInvalidUpdate)
+	}
+	for key := range replacement {
+		if strings.HasPrefix(key, "$") {
+			return fmt.Errorf("%w: replacement must not contain update operators such as %s", ErrInvalidUpdate, key)
+		}
+	}
+	return nil
+}
+
+// decodeSingleResult decodes a find-and-modify result, returning a nil document when nothing matched
+func decodeSingleResult(result *mongo.SingleResult) (bson.M, error) {
+	doc := bson.M{}
+	err := result.Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func FindOneAndUpdate(request types.FindOneAndUpdateRequest) (bson.M, error) {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	update, err := BuildUpdate(request.Data, request.Update)
+	if err != nil {
+		return nil, err
+	}
+
+	returnDoc, err := returnDocument(request.ReturnDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(request.Upsert).
+		SetReturnDocument(returnDoc)
+	if len(request.ArrayFilters) > 0 {
+		opts.SetArrayFilters(arrayFilters(request.ArrayFilters))
+	}
+	if len(request.Sort) > 0 {
+		opts.SetSort(request.Sort)
+	}
+	if len(request.Projection) > 0 {
+		opts.SetProjection(request.Projection)
+	}
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		doc, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, request.Sort, true, HistoryUpdate, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return decodeSingleResult(collection.FindOneAndUpdate(ctx, filter, update, opts))
+		})
+		if err != nil {
+			log.Printf("Error finding and updating document: %v", err)
+			return nil, err
+		}
+		return doc.(bson.M), nil
+	}
+
+	doc, err := decodeSingleResult(collection.FindOneAndUpdate(context.TODO(), filter, update, opts))
+	if err != nil {
+		log.Printf("Error finding and updating document: %v", err)
+		return nil, err
+	}
+	return doc, nil
+}
+
+func FindOneAndReplace(request types.FindOneAndReplaceRequest) (bson.M, error) {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	if err := validateReplacement(request.Replacement); err != nil {
+		return nil, err
+	}
+
+	returnDoc, err := returnDocument(request.ReturnDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndReplace().
+		SetUpsert(request.Upsert).
+		SetReturnDocument(returnDoc)
+	if len(request.Sort) > 0 {
+		opts.SetSort(request.Sort)
+	}
+	if len(request.Projection) > 0 {
+		opts.SetProjection(request.Projection)
+	}
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		doc, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, request.Sort, true, HistoryReplace, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return decodeSingleResult(collection.FindOneAndReplace(ctx, filter, request.Replacement, opts))
+		})
+		if err != nil {
+			log.Printf("Error finding and replacing document: %v", err)
+			return nil, err
+		}
+		return doc.(bson.M), nil
+	}
+
+	doc, err := decodeSingleResult(collection.FindOneAndReplace(context.TODO(), filter, request.Replacement, opts))
+	if err != nil {
+		log.Printf("Error finding and replacing document: %v", err)
+		return nil, err
+	}
+	return doc, nil
+}
+
+func FindOneAndDelete(request types.FindOneAndDeleteRequest) (bson.M, error) {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	opts := options.FindOneAndDelete()
+	if len(request.Sort) > 0 {
+		opts.SetSort(request.Sort)
+	}
+	if len(request.Projection) > 0 {
+		opts.SetProjection(request.Projection)
+	}
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		doc, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, request.Sort, true, HistoryDelete, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			if !SoftDeleteEnabled(request.Database, request.Collection) {
+				return decodeSingleResult(collection.FindOneAndDelete(ctx, filter, opts))
+			}
+			// filter already names the one document recordWrite picked
+			projectOpts := options.FindOne()
+			if len(request.Projection) > 0 {
+				projectOpts.SetProjection(request.Projection)
+			}
+			if request.Collation != nil {
+				projectOpts.SetCollation(toCollation(request.Collation))
+			}
+			doc, err := decodeSingleResult(collection.FindOne(ctx, filter, projectOpts))
+			if err != nil || doc == nil {
+				return doc, err
+			}
+			if _, err := trashDocuments(ctx, request.Database, request.Collection, filter, true, request.Actor); err != nil {
+				return nil, err
+			}
+			return doc, nil
+		})
+		if err != nil {
+			log.Printf("Error finding and deleting document: %v", err)
+			return nil, err
+		}
+		return doc.(bson.M), nil
+	}
+	if SoftDeleteEnabled(request.Database, request.Collection) {
+		return findOneAndTrash(context.TODO(), request, filter)
+	}
+
+	doc, err := decodeSingleResult(collection.FindOneAndDelete(context.TODO(), filter, opts))
+	if err != nil {
+		log.Printf("Error finding and deleting document: %v", err)
+		return nil, err
+	}
+	return doc, nil
+}
+
+// findOneAndTrash is FindOneAndDelete for soft-delete collections: within one transaction it picks
+// the first matching document, reads it with the requested projection and moves it to the trash
+func findOneAndTrash(ctx context.Context, request types.FindOneAndDeleteRequest, filter bson.D) (bson.M, error) {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	doc, err := withTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		findOpts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
+		if len(request.Sort) > 0 {
+			findOpts.SetSort(request.Sort)
+		}
+		if request.Collation != nil {
+			findOpts.SetCollation(toCollation(request.Collation))
+		}
+		match, err := decodeSingleResult(collection.FindOne(ctx, filter, findOpts))
+		if err != nil || match == nil {
+			return match, err
+		}
+
+		byID := bson.D{{Key: "_id", Value: match["_id"]}}
+		projectOpts := options.FindOne()
+		if len(request.Projection) > 0 {
+			projectOpts.SetProjection(request.Projection)
+		}
+		doc, err := decodeSingleResult(collection.FindOne(ctx, byID, projectOpts))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := trashDocuments(ctx, request.Database, request.Collection, byID, true, request.Actor); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	})
+	if err != nil {
+		log.Printf("Error finding and trashing document: %v", err)
+		return nil, err
+	}
+	return doc.(bson.M), nil
+}
+
+func ReplaceOne(request types.ReplaceOneRequest) (*mongo.UpdateResult, error) {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	if err := validateReplacement(request.Replacement); err != nil {
+		return nil, err
+	}
+
+	opts := options.Replace().SetUpsert(request.Upsert)
+	if request.Collation != nil {
+		opts.SetCollation(toCollation(request.Collation))
+	}
+
+	if HistoryEnabled(request.Database, request.Collection) {
+		result, err := recordedWrite(context.TODO(), request.Database, request.Collection, filter, request.Collation, nil, true, HistoryReplace, request.Actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return collection.ReplaceOne(ctx, filter, request.Replacement, opts)
+		})
+		if err != nil {
+			log.Printf("Error replacing document: %v", err)
+			return nil, err
+		}
+		return result.(*mongo.UpdateResult), nil
+	}
+
+	result, err := collection.ReplaceOne(context.TODO(), filter, request.Replacement, opts)
+	if err != nil {
+		log.Printf("Error replacing document: %v", err)
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,292 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// HistorySuffix is appended to a collection name to form the name of its revision history collection
+const HistorySuffix = "_history"
+
+// Operations recorded in revision history entries
+const (
+	HistoryUpdate  = "update"
+	HistoryReplace = "replace"
+	HistoryDelete  = "delete"
+	HistoryRevert  = "revert"
+	// HistoryRestore is recorded when a revert recreates a deleted document; its entry holds no
+	// prior version
+	HistoryRestore = "restore"
+)
+
+// Fields of a history entry. The discriminator field is copied from the document as well, so
+// history can be scoped to an organization like any other document.
+const (
+	HistoryDocumentIDField = "documentId"
+	HistoryRevisionField   = "revision"
+	HistoryOperationField  = "operation"
+	HistoryActorField      = "actor"
+	HistoryTimestampField  = "timestamp"
+	HistoryDocumentField   = "document"
+	HistoryDiffField       = "diff"
+)
+
+// HistoryConfig lists the collections, as "database.collection", whose writes are recorded
+type HistoryConfig struct {
+	Collections []string `json:"collections"`
+}
+
+var historyCollections = map[string]bool{}
+
+// historyEntry is the shape of a document in a history collection. Document is the version of the
+// document before revision Revision was applied, and Diff lists the changes that revision made.
+type historyEntry struct {
+	DocumentID interface{} `bson:"documentId"`
+	Revision   int64       `bson:"revision"`
+	Document   bson.M      `bson:"document"`
+}
+
+func loadHistoryConfig() {
+	path := os.Getenv("HISTORY_CONFIG_PATH")
+	if path == "" {
+		path = "history.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: could not read history config from %s: %v", path, err)
+		}
+		return
+	}
+	var config HistoryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Printf("Warning: could not parse history config from %s: %v", path, err)
+		return
+	}
+	SetHistoryConfig(config)
+}
+
+// SetHistoryConfig replaces the set of collections whose writes are recorded
+func SetHistoryConfig(config HistoryConfig) {
+	collections := map[string]bool{}
+	for _, namespace := range config.Collections {
+		collections[namespace] = true
+	}
+	historyCollections = collections
+}
+
+// HistoryEnabled reports whether writes to the collection are recorded in its history
+func HistoryEnabled(database, collection string) bool {
+	return historyCollections[database+"."+collection]
+}
+
+// HistoryCollection returns the name of the history collection that belongs to collection
+func HistoryCollection(collection string) string {
+	return collection + HistorySuffix
+}
+
+// IsHistoryCollection reports whether collection is named like a history collection, which only the
+// history endpoints may read or modify
+func IsHistoryCollection(collection string) bool {
+	return strings.HasSuffix(collection, HistorySuffix)
+}
+
+// recordedWrite runs write inside a transaction and records the prior version of every document it
+// affects, see recordWrite
+func recordedWrite(ctx context.Context, database, collection string, filter bson.D, collation *types.Collation, sort bson.D, one bool, operation string, actor string, write func(ctx context.Context, filter bson.D) (interface{}, error)) (interface{}, error) {
+	return withTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return recordWrite(ctx, database, collection, filter, collation, sort, one, operation, actor, write)
+	})
+}
+
+// recordWrite runs write and records the prior version of every document it affects. It must run
+// inside a transaction, carried by ctx. The documents matching filter (only the first one in sort
+// order when one is set) are read first and write receives a filter restricted to exactly those
+// documents, so the recorded versions are the ones that were changed. collation and sort must be
+// the ones write uses, so both pick the same documents. When nothing matched, write receives the
+// original filter so upserts still work; an upserted document has no prior version and gets no
+// history entry.
+func recordWrite(ctx context.Context, database, collection string, filter bson.D, collation *types.Collation, sort bson.D, one bool, operation string, actor string, write func(ctx context.Context, filter bson.D) (interface{}, error)) (interface{}, error) {
+	coll := Client.Database(database).Collection(collection)
+
+	opts := options.Find()
+	if one {
+		opts.SetLimit(1)
+	}
+	if len(sort) > 0 {
+		opts.SetSort(sort)
+	}
+	if collation != nil {
+		opts.SetCollation(toCollation(collation))
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var before []bson.M
+	if err := cursor.All(ctx, &before); err != nil {
+		return nil, err
+	}
+	if len(before) == 0 {
+		return write(ctx, filter)
+	}
+
+	ids := make([]interface{}, 0, len(before))
+	for _, doc := range before {
+		ids = append(ids, doc["_id"])
+	}
+	restricted := bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}}}
+	result, err := write(ctx, restricted)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := findByIDs(ctx, coll, ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, prior := range before {
+		if err := recordRevision(ctx, database, collection, prior, documentWithID(after, prior["_id"]), operation, actor); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// deleteInTransaction deletes the documents matching filter from inside a running transaction,
+// moving them to the trash when the collection uses soft delete
+func deleteInTransaction(ctx context.Context, database, collection string, filter bson.D, one bool, actor string) (*mongo.DeleteResult, error) {
+	if SoftDeleteEnabled(database, collection) {
+		deleted, err := trashDocuments(ctx, database, collection, filter, one, actor)
+		if err != nil {
+			return nil, err
+		}
+		return &mongo.DeleteResult{DeletedCount: deleted, Acknowledged: true}, nil
+	}
+
+	coll := Client.Database(database).Collection(collection)
+	if one {
+		return coll.DeleteOne(ctx, filter)
+	}
+	return coll.DeleteMany(ctx, filter)
+}
+
+// recordRevision appends a history entry holding prior, the version of a document before a write
+// (nil when the write recreated it), and the diff to current, its version after the write (nil when
+// it was deleted). It must run inside the transaction that performed the write.
+func recordRevision(ctx context.Context, database, collection string, prior, current bson.M, operation string, actor string) error {
+	history := Client.Database(database).Collection(HistoryCollection(collection))
+	source := prior
+	if source == nil {
+		source = current
+	}
+	documentID := source["_id"]
+
+	var latest historyEntry
+	opts := options.FindOne().SetSort(bson.D{{Key: HistoryRevisionField, Value: -1}})
+	err := history.FindOne(ctx, bson.D{{Key: HistoryDocumentIDField, Value: documentID}}, opts).Decode(&latest)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	entry := bson.M{
+		HistoryDocumentIDField: documentID,
+		HistoryRevisionField:   latest.Revision + 1,
+		HistoryOperationField:  operation,
+		HistoryActorField:      actor,
+		HistoryTimestampField:  time.Now().UTC(),
+		HistoryDocumentField:   prior,
+		HistoryDiffField:       DiffDocuments(prior, current),
+	}
+	discriminator := tenancy.DiscriminatorField()
+	if organizationID, ok := source[discriminator]; ok {
+		entry[discriminator] = organizationID
+	}
+
+	_, err = history.InsertOne(ctx, entry)
+	return err
+}
+
+// ListHistory returns one page of the revisions of the document with the given ID, oldest first
+// unless the request sorts otherwise. The request filter is combined with the document match.
+func ListHistory(request types.Request, objectId string) (types.Page, error) {
+	objId, err := bson.ObjectIDFromHex(objectId)
+	if err != nil {
+		return types.Page{}, fmt.Errorf("%w: %q", ErrInvalidObjectID, objectId)
+	}
+
+	request.Collection = HistoryCollection(request.Collection)
+	request.Filter = append(bson.D{{Key: HistoryDocumentIDField, Value: objId}}, request.Filter...)
+	return GetAll(request)
+}
+
+// Revert restores a document to the version recorded in the given revision, i.e. the version it had
+// before that revision's change, and returns it. The revert replaces the current version, or
+// recreates the document when it has been deleted once request.AuthorizeRecreate allows it, and is
+// itself recorded as a new revision.
+func Revert(ctx context.Context, request types.RevertRequest) (bson.M, error) {
+	objId, err := bson.ObjectIDFromHex(request.ObjectId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+	}
+
+	restored, err := withTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		history := Client.Database(request.Database).Collection(HistoryCollection(request.Collection))
+		coll := Client.Database(request.Database).Collection(request.Collection)
+
+		entryFilter := append(bson.D{
+			{Key: HistoryDocumentIDField, Value: objId},
+			{Key: HistoryRevisionField, Value: request.Revision},
+		}, request.Scope...)
+		var entry historyEntry
+		if err := history.FindOne(ctx, entryFilter).Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		if entry.Document == nil {
+			return nil, fmt.Errorf("%w: revision %d recreated the document, so no version precedes it", ErrInvalidOperation, request.Revision)
+		}
+
+		filter := append(bson.D{{Key: "_id", Value: objId}}, request.Scope...)
+		current, err := decodeSingleResult(coll.FindOne(ctx, filter))
+		if err != nil {
+			return nil, err
+		}
+		operation := HistoryRevert
+		if current == nil {
+			operation = HistoryRestore
+			if request.AuthorizeRecreate != nil {
+				if err := request.AuthorizeRecreate(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		// Trust the caller's organization rather than whatever the entry holds
+		tenancy.StampDocument(request.OrganizationID, entry.Document)
+		if _, err := coll.ReplaceOne(ctx, filter, entry.Document, options.Replace().SetUpsert(true)); err != nil {
+			return nil, err
+		}
+		if err := recordRevision(ctx, request.Database, request.Collection, current, entry.Document, operation, request.Actor); err != nil {
+			return nil, err
+		}
+		return entry.Document, nil
+	})
+	if err != nil {
+		log.Printf("Error reverting %s.%s %s to revision %d: %v", request.Database, request.Collection, request.ObjectId, request.Revision, err)
+		return nil, err
+	}
+	return restored.(bson.M), nil
+}
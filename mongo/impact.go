@@ -0,0 +1,59 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"mongo-manager/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ImpactSampleSize is the number of matching _ids included in an impact preview
+const ImpactSampleSize = 10
+
+// PreviewImpact counts the documents matching filter and samples their _ids without modifying anything.
+// The count is taken before the write runs, so concurrent writes can still change the final number.
+func PreviewImpact(ctx context.Context, database, collection string, filter bson.D, collation *types.Collation) (types.ImpactPreview, error) {
+	coll := Client.Database(database).Collection(collection)
+
+	normalized, err := NormalizeFilter(database, collection, filter)
+	if err != nil {
+		return types.ImpactPreview{}, err
+	}
+	if normalized == nil {
+		normalized = bson.D{}
+	}
+
+	countOpts := options.Count()
+	findOpts := options.Find().
+		SetProjection(bson.D{{Key: "_id", Value: 1}}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(ImpactSampleSize)
+	if collation != nil {
+		countOpts.SetCollation(toCollation(collation))
+		findOpts.SetCollation(toCollation(collation))
+	}
+
+	count, err := coll.CountDocuments(ctx, normalized, countOpts)
+	if err != nil {
+		log.Printf("Error counting documents: %v", err)
+		return types.ImpactPreview{}, err
+	}
+
+	cursor, err := coll.Find(ctx, normalized, findOpts)
+	if err != nil {
+		log.Printf("Error sampling documents: %v", err)
+		return types.ImpactPreview{}, err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return types.ImpactPreview{}, err
+	}
+
+	preview := types.ImpactPreview{MatchedCount: count, SampleIDs: make([]interface{}, 0, len(docs))}
+	for _, doc := range docs {
+		preview.SampleIDs = append(preview.SampleIDs, doc["_id"])
+	}
+	return preview, nil
+}
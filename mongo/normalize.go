@@ -0,0 +1,274 @@
+package mongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Field types understood by the filter normalizer
+const (
+	TypeObjectID = "objectId"
+	TypeDate     = "date"
+	TypeLong     = "long"
+	TypeDecimal  = "decimal"
+	TypeString   = "string"
+)
+
+// ErrInvalidObjectID is returned when an object ID parameter is not a valid 24 character hex string
+var ErrInvalidObjectID = errors.New("invalid object ID")
+
+// ErrInvalidFilter is returned when a filter value cannot be coerced to the type hinted for its field
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// TypeHints configures how filter values are coerced before they reach MongoDB.
+// Defaults apply to every collection, and Collections entries keyed by "database.collection"
+// are layered on top of them.
+type TypeHints struct {
+	Defaults    CollectionHints            `json:"defaults"`
+	Collections map[string]CollectionHints `json:"collections"`
+}
+
+// CollectionHints maps dotted field paths to one of the Type* constants.
+// CoerceDates additionally converts any RFC 3339 timestamp string into a BSON date.
+type CollectionHints struct {
+	Fields      map[string]string `json:"fields"`
+	CoerceDates bool              `json:"coerceDates"`
+}
+
+var typeHints TypeHints
+
+// dateLayouts are the ISO-8601 forms accepted for date coercion
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+func loadTypeHints() {
+	path := os.Getenv("TYPE_HINTS_PATH")
+	if path == "" {
+		path = "type_hints.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: could not read type hints from %s: %v", path, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &typeHints); err != nil {
+		log.Printf("Warning: could not parse type hints from %s: %v", path, err)
+	}
+}
+
+// SetTypeHints replaces the active type hints
+func SetTypeHints(hints TypeHints) {
+	typeHints = hints
+}
+
+// filterNormalizer coerces filter values for a single collection
+type filterNormalizer struct {
+	fields      map[string]string
+	coerceDates bool
+}
+
+func newFilterNormalizer(database, collection string) filterNormalizer {
+	normalizer := filterNormalizer{
+		fields:      map[string]string{},
+		coerceDates: typeHints.Defaults.CoerceDates,
+	}
+	for field, fieldType := range typeHints.Defaults.Fields {
+		normalizer.fields[field] = fieldType
+	}
+
+	if hints, ok := typeHints.Collections[database+"."+collection]; ok {
+		for field, fieldType := range hints.Fields {
+			normalizer.fields[field] = fieldType
+		}
+		normalizer.coerceDates = normalizer.coerceDates || hints.CoerceDates
+	}
+	return normalizer
+}
+
+// NormalizeFilter walks a filter of arbitrary depth and coerces values to their BSON types:
+//   - _id strings that are valid hex ObjectIDs become ObjectIDs
+//   - fields hinted for the collection are converted to the hinted type
+//   - RFC 3339 strings become dates when date coercion is enabled
+//
+// Coercion follows values through $and/$or/$nor, comparison operators, $in/$nin/$all lists,
+// $not and $elemMatch. Values that are already typed, for example through Extended JSON, are left alone.
+func NormalizeFilter(database, collection string, filter bson.D) (bson.D, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	return newFilterNormalizer(database, collection).document(filter, "")
+}
+
+// document normalizes a query document whose field names are relative to prefix
+func (n filterNormalizer) document(doc bson.D, prefix string) (bson.D, error) {
+	normalized := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		var err error
+		switch elem.Key {
+		case "$and", "$or", "$nor":
+			elem.Value, err = n.documentList(elem.Value, prefix, elem.Key)
+		case "$expr", "$where", "$text", "$comment", "$jsonSchema":
+			// Expressions and special operators are passed through untouched
+		default:
+			path := prefix + elem.Key
+			if operators, ok := elem.Value.(bson.D); ok && isOperatorDocument(operators) {
+				elem.Value, err = n.operators(operators, path)
+			} else {
+				elem.Value, err = n.coerce(elem.Value, path)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, elem)
+	}
+	return normalized, nil
+}
+
+func (n filterNormalizer) documentList(value interface{}, prefix string, operator string) (interface{}, error) {
+	items, ok := asArray(value)
+	if !ok {
+		return value, nil
+	}
+
+	normalized := make(bson.A, 0, len(items))
+	for _, item := range items {
+		doc, ok := item.(bson.D)
+		if !ok {
+			normalized = append(normalized, item)
+			continue
+		}
+		normalizedDoc, err := n.document(doc, prefix)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, normalizedDoc)
+	}
+	return normalized, nil
+}
+
+// operators normalizes an operator document such as {$in: [...], $gte: ...} applied to path
+func (n filterNormalizer) operators(doc bson.D, path string) (bson.D, error) {
+	normalized := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		var err error
+		switch elem.Key {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			elem.Value, err = n.coerce(elem.Value, path)
+		case "$in", "$nin", "$all":
+			elem.Value, err = n.coerceList(elem.Value, path)
+		case "$not":
+			if operators, ok := elem.Value.(bson.D); ok {
+				elem.Value, err = n.operators(operators, path)
+			}
+		case "$elemMatch":
+			if sub, ok := elem.Value.(bson.D); ok {
+				if isOperatorDocument(sub) {
+					elem.Value, err = n.operators(sub, path)
+				} else {
+					elem.Value, err = n.document(sub, path+".")
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, elem)
+	}
+	return normalized, nil
+}
+
+func (n filterNormalizer) coerceList(value interface{}, path string) (interface{}, error) {
+	items, ok := asArray(value)
+	if !ok {
+		return value, nil
+	}
+
+	normalized := make(bson.A, 0, len(items))
+	for _, item := range items {
+		coerced, err := n.coerce(item, path)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, coerced)
+	}
+	return normalized, nil
+}
+
+// coerce converts a single value according to the type configured for path
+func (n filterNormalizer) coerce(value interface{}, path string) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	fieldType, hinted := n.fields[path]
+	if !hinted {
+		if path == "_id" || strings.HasSuffix(path, "._id") {
+			if objID, err := bson.ObjectIDFromHex(str); err == nil {
+				return objID, nil
+			}
+			return value, nil
+		}
+		if n.coerceDates {
+			if date, err := time.Parse(time.RFC3339Nano, str); err == nil {
+				return bson.NewDateTimeFromTime(date), nil
+			}
+		}
+		return value, nil
+	}
+
+	switch fieldType {
+	case TypeObjectID:
+		objID, err := bson.ObjectIDFromHex(str)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be an ObjectID hex string", ErrInvalidFilter, path)
+		}
+		return objID, nil
+	case TypeDate:
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, str); err == nil {
+				return bson.NewDateTimeFromTime(date), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s must be an ISO-8601 date", ErrInvalidFilter, path)
+	case TypeLong:
+		number, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be an integer", ErrInvalidFilter, path)
+		}
+		return number, nil
+	case TypeDecimal:
+		decimal, err := bson.ParseDecimal128(str)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be a decimal", ErrInvalidFilter, path)
+		}
+		return decimal, nil
+	}
+	return value, nil
+}
+
+// isOperatorDocument reports whether a document is a set of query operators rather than an embedded document
+func isOperatorDocument(doc bson.D) bool {
+	return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+func asArray(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
@@ -0,0 +1,181 @@
+package mongo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setTypeHints replaces the type hints for the duration of the test
+func setTypeHints(t *testing.T, hints TypeHints) {
+	t.Helper()
+	saved := typeHints
+	t.Cleanup(func() { typeHints = saved })
+	SetTypeHints(hints)
+}
+
+// parseExtJSON decodes an Extended JSON filter the way request bodies are decoded
+func parseExtJSON(t *testing.T, filter string) bson.D {
+	t.Helper()
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(filter), false, &doc); err != nil {
+		t.Fatalf("parsing %s: %v", filter, err)
+	}
+	return doc
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	setTypeHints(t, TypeHints{
+		Defaults: CollectionHints{Fields: map[string]string{"ownerId": TypeObjectID}},
+		Collections: map[string]CollectionHints{
+			"shop.orders": {
+				Fields: map[string]string{
+					"placedAt":   TypeDate,
+					"total":      TypeDecimal,
+					"sequence":   TypeLong,
+					"items.sku":  TypeObjectID,
+					"externalId": TypeString,
+				},
+				CoerceDates: true,
+			},
+		},
+	})
+
+	id := bson.NewObjectID()
+	other := bson.NewObjectID()
+	placed := bson.NewDateTimeFromTime(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	updated := bson.NewDateTimeFromTime(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC))
+	total, _ := bson.ParseDecimal128("19.99")
+
+	tests := []struct {
+		name       string
+		collection string
+		filter     string
+		want       bson.D
+	}{
+		{
+			name:   "_id hex string",
+			filter: `{"_id": "` + id.Hex() + `"}`,
+			want:   bson.D{{Key: "_id", Value: id}},
+		},
+		{
+			name:   "_id that isn't an ObjectID",
+			filter: `{"_id": "order-1"}`,
+			want:   bson.D{{Key: "_id", Value: "order-1"}},
+		},
+		{
+			name:   "nested _id",
+			filter: `{"customer._id": "` + id.Hex() + `"}`,
+			want:   bson.D{{Key: "customer._id", Value: id}},
+		},
+		{
+			name:   "_id in $in and $or",
+			filter: `{"$or": [{"_id": {"$in": ["` + id.Hex() + `", "` + other.Hex() + `"]}}, {"_id": {"$not": {"$eq": "` + id.Hex() + `"}}}]}`,
+			want: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{id, other}}}}},
+				bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$eq", Value: id}}}}}},
+			}}},
+		},
+		{
+			name:   "typed Extended JSON left alone",
+			filter: `{"_id": {"$oid": "` + id.Hex() + `"}, "total": {"$numberDecimal": "19.99"}, "sequence": {"$numberLong": "7"}}`,
+			want:   bson.D{{Key: "_id", Value: id}, {Key: "total", Value: total}, {Key: "sequence", Value: int64(7)}},
+		},
+		{
+			name:   "default hint",
+			filter: `{"ownerId": "` + id.Hex() + `"}`,
+			want:   bson.D{{Key: "ownerId", Value: id}},
+		},
+		{
+			name:   "collection hints",
+			filter: `{"placedAt": {"$gte": "2024-05-01"}, "total": {"$lt": "19.99"}, "sequence": {"$in": ["7", "8"]}}`,
+			want: bson.D{
+				{Key: "placedAt", Value: bson.D{{Key: "$gte", Value: placed}}},
+				{Key: "total", Value: bson.D{{Key: "$lt", Value: total}}},
+				{Key: "sequence", Value: bson.D{{Key: "$in", Value: bson.A{int64(7), int64(8)}}}},
+			},
+		},
+		{
+			name:   "hinted string not coerced",
+			filter: `{"externalId": "2024-05-01T12:30:00Z"}`,
+			want:   bson.D{{Key: "externalId", Value: "2024-05-01T12:30:00Z"}},
+		},
+		{
+			name:   "coerced dates",
+			filter: `{"updatedAt": {"$lt": "2024-05-01T12:30:00Z"}, "note": "not a date"}`,
+			want:   bson.D{{Key: "updatedAt", Value: bson.D{{Key: "$lt", Value: updated}}}, {Key: "note", Value: "not a date"}},
+		},
+		{
+			name:       "dates not coerced in other collections",
+			collection: "customers",
+			filter:     `{"updatedAt": "2024-05-01T12:30:00Z", "placedAt": "2024-05-01"}`,
+			want:       bson.D{{Key: "updatedAt", Value: "2024-05-01T12:30:00Z"}, {Key: "placedAt", Value: "2024-05-01"}},
+		},
+		{
+			name:   "$elemMatch on embedded documents",
+			filter: `{"items": {"$elemMatch": {"sku": "` + id.Hex() + `", "qty": {"$gt": 1}}}}`,
+			want: bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+				{Key: "sku", Value: id},
+				{Key: "qty", Value: bson.D{{Key: "$gt", Value: int32(1)}}},
+			}}}}},
+		},
+		{
+			name:   "expressions untouched",
+			filter: `{"$expr": {"$eq": ["$_id", "` + id.Hex() + `"]}}`,
+			want:   bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$_id", id.Hex()}}}}},
+		},
+		{
+			name:   "embedded document equality",
+			filter: `{"address": {"city": "Paris"}}`,
+			want:   bson.D{{Key: "address", Value: bson.D{{Key: "city", Value: "Paris"}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection := tt.collection
+			if collection == "" {
+				collection = "orders"
+			}
+			got, err := NormalizeFilter("shop", collection, parseExtJSON(t, tt.filter))
+			if err != nil {
+				t.Fatalf("NormalizeFilter() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeFilter(%s) =\n%#v\nwant\n%#v", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	if got, err := NormalizeFilter("shop", "orders", nil); got != nil || err != nil {
+		t.Errorf("NormalizeFilter(nil) = %v, %v, want nil", got, err)
+	}
+}
+
+func TestNormalizeFilterInvalid(t *testing.T) {
+	setTypeHints(t, TypeHints{Defaults: CollectionHints{Fields: map[string]string{
+		"ownerId":  TypeObjectID,
+		"placedAt": TypeDate,
+		"total":    TypeDecimal,
+		"sequence": TypeLong,
+	}}})
+
+	tests := []string{
+		`{"ownerId": "not-an-id"}`,
+		`{"placedAt": {"$gte": "yesterday"}}`,
+		`{"total": "a lot"}`,
+		`{"sequence": {"$in": ["1", "two"]}}`,
+		`{"$and": [{"sequence": "1.5"}]}`,
+	}
+
+	for _, filter := range tests {
+		t.Run(filter, func(t *testing.T) {
+			if _, err := NormalizeFilter("shop", "orders", parseExtJSON(t, filter)); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("NormalizeFilter(%s) error = %v, want ErrInvalidFilter", filter, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package mongo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// DefaultPageSize is used when a paginated read does not specify a limit
+const DefaultPageSize int64 = 100
+
+// MaxPageSize caps the number of documents returned in a single page
+const MaxPageSize int64 = 1000
+
+// ErrInvalidPagination is returned when sort, limit, skip or cursor parameters are malformed
+var ErrInvalidPagination = errors.New("invalid pagination parameters")
+
+// sortKey is a single field of a normalized sort specification
+type sortKey struct {
+	Field     string
+	Direction int
+}
+
+// pageCursor is the decoded form of the opaque continuation token handed to clients.
+// Keys are stored alongside values so a token cannot be replayed against a different sort.
+// Positional cursors hold the Offset of the next page instead of values.
+type pageCursor struct {
+	Keys   []string `bson:"k"`
+	Values bson.A   `bson:"v,omitempty"`
+	Offset int64    `bson:"o,omitempty"`
+}
+
+// positional reports whether the cursor continues by position rather than after its sort values
+func (c pageCursor) positional() bool {
+	return len(c.Values) == 0
+}
+
+// unorderableTypes are the $type aliases of the values sortBracket refuses
+var unorderableTypes = bson.A{"array", "regex", "javascript", "javascriptWithScope", "dbPointer"}
+
+// normalizeSort validates the requested sort and appends _id as a tiebreaker so that
+// every document has a unique position, which keyset pagination relies on.
+func normalizeSort(sort bson.D) ([]sortKey, error) {
+	keys := make([]sortKey, 0, len(sort)+1)
+	hasID := false
+
+	for _, elem := range sort {
+		direction, ok := sortDirection(elem.Value)
+		if !ok {
+			return nil, fmt.Errorf("%w: sort direction for %q must be 1 or -1", ErrInvalidPagination, elem.Key)
+		}
+		if elem.Key == "_id" {
+			hasID = true
+		}
+		keys = append(keys, sortKey{Field: elem.Key, Direction: direction})
+	}
+
+	if !hasID {
+		direction := 1
+		if len(keys) > 0 {
+			direction = keys[len(keys)-1].Direction
+		}
+		keys = append(keys, sortKey{Field: "_id", Direction: direction})
+	}
+
+	return keys, nil
+}
+
+func sortDirection(value interface{}) (int, bool) {
+	var direction float64
+	switch v := value.(type) {
+	case int:
+		direction = float64(v)
+	case int32:
+		direction = float64(v)
+	case int64:
+		direction = float64(v)
+	case float64:
+		direction = v
+	default:
+		return 0, false
+	}
+
+	switch direction {
+	case 1:
+		return 1, true
+	case -1:
+		return -1, true
+	}
+	return 0, false
+}
+
+func sortDocument(keys []sortKey) bson.D {
+	sort := bson.D{}
+	for _, key := range keys {
+		sort = append(sort, bson.E{Key: key.Field, Value: key.Direction})
+	}
+	return sort
+}
+
+// nextCursor returns the token for the page after last. Pages continue the way the first page
+// chose: with a keyset condition when no document matching filter holds a value a keyset condition
+// can't order in a sort field, and by position, offset being the position of the next page,
+// otherwise. previous is the cursor of the current page, nil on the first one.
+func nextCursor(ctx context.Context, collection *mongo.Collection, filter bson.D, keys []sortKey, previous *pageCursor, last bson.M, offset int64) (string, error) {
+	if previous != nil && previous.positional() {
+		return encodeOffset(keys, offset)
+	}
+	if previous == nil {
+		probe := unorderableFilter(keys)
+		if probe != nil {
+			err := collection.FindOne(ctx, bson.D{{Key: "$and", Value: bson.A{filter, probe}}}, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Err()
+			if err == nil {
+				return encodeOffset(keys, offset)
+			}
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return "", err
+			}
+		}
+	}
+	return encodeCursor(keys, last)
+}
+
+// unorderableFilter matches documents holding a value a keyset condition can't order in one of
+// the sort fields, or is nil when _id is the only sort field
+func unorderableFilter(keys []sortKey) bson.D {
+	conditions := bson.A{}
+	for _, key := range keys {
+		if key.Field != "_id" {
+			conditions = append(conditions, bson.D{{Key: key.Field, Value: bson.D{{Key: "$type", Value: unorderableTypes}}}})
+		}
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return bson.D{{Key: "$or", Value: conditions}}
+}
+
+// encodeOffset builds a positional continuation token for the page starting at offset
+func encodeOffset(keys []sortKey, offset int64) (string, error) {
+	cursor := pageCursor{Offset: offset}
+	for _, key := range keys {
+		cursor.Keys = append(cursor.Keys, key.Field)
+	}
+	return marshalCursor(cursor)
+}
+
+// encodeCursor builds the continuation token from the sort key values of the last document on a page
+func encodeCursor(keys []sortKey, doc bson.M) (string, error) {
+	cursor := pageCursor{}
+	for _, key := range keys {
+		value := lookupPath(doc, key.Field)
+		if _, ok := sortBracket(value); !ok {
+			// Only when such a value was written while paging, since the first page checks for them
+			return "", fmt.Errorf("%w: sort field %q of document %v changed to a value cursors can't page through, start again from the first page", ErrInvalidPagination, key.Field, doc["_id"])
+		}
+		cursor.Keys = append(cursor.Keys, key.Field)
+		cursor.Values = append(cursor.Values, value)
+	}
+	return marshalCursor(cursor)
+}
+
+func marshalCursor(cursor pageCursor) (string, error) {
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor parses a continuation token and checks that it was issued for the same sort
+func decodeCursor(token string, keys []sortKey) (pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPagination)
+	}
+
+	var cursor pageCursor
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPagination)
+	}
+
+	if len(cursor.Keys) != len(keys) {
+		return pageCursor{}, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidPagination)
+	}
+	for i, key := range keys {
+		if cursor.Keys[i] != key.Field {
+			return pageCursor{}, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidPagination)
+		}
+	}
+	if cursor.positional() {
+		if cursor.Offset <= 0 {
+			return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPagination)
+		}
+		return cursor, nil
+	}
+	if len(cursor.Values) != len(keys) || cursor.Offset != 0 {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPagination)
+	}
+	for _, value := range cursor.Values {
+		if _, ok := sortBracket(value); !ok {
+			return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPagination)
+		}
+	}
+
+	return cursor, nil
+}
+
+// Sort brackets, the groups of BSON types in the order MongoDB sorts them. Values of different
+// brackets compare by bracket alone, while $gt and $lt only match values of the same bracket as
+// their operand, so keysetFilter matches the brackets on either side of a cursor value by $type.
+const (
+	bracketMinKey = iota
+	bracketNull
+	bracketNumber
+	bracketString
+	bracketObject
+	bracketBinary
+	bracketObjectID
+	bracketBool
+	bracketDate
+	bracketTimestamp
+	bracketRegex
+	bracketMaxKey
+)
+
+// sortBrackets holds the $type aliases of each sort bracket. Null sorts together with missing
+// fields, which $type can't match, so keysetFilter matches that bracket with {field: null}.
+var sortBrackets = [][]string{
+	bracketMinKey:    {"minKey"},
+	bracketNull:      nil,
+	bracketNumber:    {"double", "int", "long", "decimal"},
+	bracketString:    {"string", "symbol"},
+	bracketObject:    {"object"},
+	bracketBinary:    {"binData"},
+	bracketObjectID:  {"objectId"},
+	bracketBool:      {"bool"},
+	bracketDate:      {"date"},
+	bracketTimestamp: {"timestamp"},
+	bracketRegex:     {"regex"},
+	bracketMaxKey:    {"maxKey"},
+}
+
+// sortBracket returns the sort bracket of a sort key value. Arrays sort by their smallest or
+// largest element and regular expressions can't be matched by equality, so neither can be paged
+// through with a keyset condition.
+func sortBracket(value interface{}) (int, bool) {
+	switch value.(type) {
+	case bson.MinKey:
+		return bracketMinKey, true
+	case nil, bson.Undefined, bson.Null:
+		return bracketNull, true
+	case int, int32, int64, float64, bson.Decimal128:
+		return bracketNumber, true
+	case string, bson.Symbol:
+		return bracketString, true
+	case bson.D, bson.M, map[string]interface{}:
+		return bracketObject, true
+	case bson.Binary:
+		return bracketBinary, true
+	case bson.ObjectID:
+		return bracketObjectID, true
+	case bool:
+		return bracketBool, true
+	case bson.DateTime, time.Time:
+		return bracketDate, true
+	case bson.Timestamp:
+		return bracketTimestamp, true
+	case bson.MaxKey:
+		return bracketMaxKey, true
+	}
+	return 0, false
+}
+
+// keysetFilter matches every document positioned strictly after the cursor in sort order.
+// For keys k1..kn it builds {$or: [{k1: after v1}, {k1: v1, k2: after v2}, ...]}, where "after"
+// is the condition built by afterConditions. Equality on a null cursor value also matches missing
+// fields, which sort alongside null.
+func keysetFilter(keys []sortKey, cursor pageCursor) bson.D {
+	branches := bson.A{}
+	for i, key := range keys {
+		conditions := afterConditions(key, cursor.Values[i])
+		if len(conditions) == 0 {
+			continue
+		}
+
+		branch := bson.D{}
+		for j := 0; j < i; j++ {
+			branch = append(branch, bson.E{Key: keys[j].Field, Value: cursor.Values[j]})
+		}
+		if len(conditions) == 1 {
+			branch = append(branch, conditions[0].(bson.D)...)
+		} else {
+			branch = append(branch, bson.E{Key: "$or", Value: conditions})
+		}
+		branches = append(branches, branch)
+	}
+
+	if len(branches) == 0 {
+		// The cursor sits on the last possible position, so no document follows it
+		return bson.D{{Key: "$expr", Value: false}}
+	}
+	return bson.D{{Key: "$or", Value: branches}}
+}
+
+// afterConditions lists the conditions under which a document's value for key sorts strictly
+// after value: a greater (or, descending, smaller) value of the same bracket, or any value of a
+// bracket that sorts after it
+func afterConditions(key sortKey, value interface{}) bson.A {
+	bracket, _ := sortBracket(value)
+	conditions := bson.A{}
+
+	// MinKey, null and MaxKey are the only values of their brackets
+	if bracket != bracketMinKey && bracket != bracketNull && bracket != bracketMaxKey {
+		operator := "$gt"
+		if key.Direction < 0 {
+			operator = "$lt"
+		}
+		conditions = append(conditions, bson.D{{Key: key.Field, Value: bson.D{{Key: operator, Value: value}}}})
+	}
+
+	first, last := bracket+1, len(sortBrackets)-1
+	if key.Direction < 0 {
+		first, last = 0, bracket-1
+	}
+	types := bson.A{}
+	for other := first; other <= last; other++ {
+		if other == bracketNull {
+			conditions = append(conditions, bson.D{{Key: key.Field, Value: nil}})
+			continue
+		}
+		for _, alias := range sortBrackets[other] {
+			types = append(types, alias)
+		}
+	}
+	if len(types) > 0 {
+		conditions = append(conditions, bson.D{{Key: key.Field, Value: bson.D{{Key: "$type", Value: types}}}})
+	}
+	return conditions
+}
+
+// ensureSortFieldsProjected makes sure the sort keys survive the projection so the next cursor
+// can be computed. It returns the adjusted projection and the fields that must be stripped from
+// the results afterwards because the client did not ask for them.
+func ensureSortFieldsProjected(projection bson.D, keys []sortKey) (bson.D, []string) {
+	if len(projection) == 0 {
+		return projection, nil
+	}
+
+	inclusive := false
+	for _, elem := range projection {
+		if elem.Key != "_id" && isTruthy(elem.Value) {
+			inclusive = true
+			break
+		}
+	}
+
+	adjusted := bson.D{}
+	var added []string
+
+	if inclusive {
+		adjusted = append(adjusted, projection...)
+		for _, key := range keys {
+			if key.Field == "_id" {
+				if idx := indexOf(adjusted, "_id"); idx >= 0 && !isTruthy(adjusted[idx].Value) {
+					adjusted[idx].Value = 1
+					added = append(added, "_id")
+				}
+				continue
+			}
+			if indexOf(adjusted, key.Field) < 0 {
+				adjusted = append(adjusted, bson.E{Key: key.Field, Value: 1})
+				added = append(added, key.Field)
+			}
+		}
+		return adjusted, added
+	}
+
+	for _, elem := range projection {
+		excludedSortKey := false
+		for _, key := range keys {
+			if elem.Key == key.Field {
+				excludedSortKey = true
+				break
+			}
+		}
+		if excludedSortKey {
+			added = append(added, elem.Key)
+			continue
+		}
+		adjusted = append(adjusted, elem)
+	}
+	return adjusted, added
+}
+
+func indexOf(doc bson.D, key string) int {
+	for i, elem := range doc {
+		if elem.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case nil:
+		return false
+	}
+	// Projection expressions such as {$slice: 2} or {$elemMatch: ...} include the field
+	return true
+}
+
+// lookupPath resolves a dotted field path inside a document, returning nil when any segment is missing
+func lookupPath(doc bson.M, path string) interface{} {
+	var current interface{} = doc
+	for _, segment := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case bson.M:
+			current = v[segment]
+		case bson.D:
+			current = nil
+			for _, elem := range v {
+				if elem.Key == segment {
+					current = elem.Value
+					break
+				}
+			}
+		case map[string]interface{}:
+			current = v[segment]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+// removePath deletes a dotted field path from a document
+func removePath(doc bson.M, path string) {
+	segment, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(doc, segment)
+		return
+	}
+
+	switch child := doc[segment].(type) {
+	case bson.M:
+		removePath(child, rest)
+	case bson.D:
+		converted := bson.M{}
+		for _, elem := range child {
+			converted[elem.Key] = elem.Value
+		}
+		removePath(converted, rest)
+		pruned := bson.D{}
+		for _, elem := range child {
+			if value, ok := converted[elem.Key]; ok {
+				pruned = append(pruned, bson.E{Key: elem.Key, Value: value})
+			}
+		}
+		doc[segment] = pruned
+	}
+}
@@ -0,0 +1,340 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// sortedValues holds one value of every sort bracket, in the order MongoDB sorts them ascending.
+// nil stands for both null and a missing field, which sort together.
+var sortedValues = []interface{}{
+	bson.MinKey{},
+	nil,
+	int32(-1),
+	2.5,
+	int64(3),
+	"a",
+	"b",
+	bson.D{{Key: "x", Value: int32(1)}},
+	bson.Binary{Data: []byte{1}},
+	bson.ObjectID{1},
+	false,
+	true,
+	bson.DateTime(1),
+	bson.DateTime(2),
+	bson.Timestamp{T: 1},
+	bson.MaxKey{},
+}
+
+// typeAlias returns the $type alias of the values in sortedValues
+func typeAlias(value interface{}) string {
+	switch value.(type) {
+	case bson.MinKey:
+		return "minKey"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case bson.D:
+		return "object"
+	case bson.Binary:
+		return "binData"
+	case bson.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case bson.DateTime:
+		return "date"
+	case bson.Timestamp:
+		return "timestamp"
+	case bson.MaxKey:
+		return "maxKey"
+	}
+	panic(fmt.Sprintf("no alias for %T", value))
+}
+
+// position orders the values of sortedValues, and the int32 _id of the test documents
+func position(value interface{}) int {
+	for i, candidate := range sortedValues {
+		if reflect.DeepEqual(candidate, value) {
+			return i
+		}
+	}
+	if id, ok := value.(int32); ok {
+		return int(id)
+	}
+	panic(fmt.Sprintf("no position for %v", value))
+}
+
+// matches evaluates the subset of the query language keysetFilter produces against doc
+func matches(t *testing.T, doc bson.M, filter bson.D) bool {
+	t.Helper()
+	for _, elem := range filter {
+		if elem.Key == "$or" {
+			any := false
+			for _, branch := range elem.Value.(bson.A) {
+				if matches(t, doc, branch.(bson.D)) {
+					any = true
+				}
+			}
+			if !any {
+				return false
+			}
+			continue
+		}
+		if elem.Key == "$expr" {
+			if elem.Value != false {
+				t.Fatalf("unexpected $expr %v", elem.Value)
+			}
+			return false
+		}
+		if !matchesField(t, doc, elem.Key, elem.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesField(t *testing.T, doc bson.M, field string, condition interface{}) bool {
+	value, present := doc[field]
+	operators, ok := condition.(bson.D)
+	if !ok || len(operators) == 0 || operators[0].Key[0] != '$' {
+		// Equality, where null also matches missing fields
+		if condition == nil {
+			return value == nil
+		}
+		return present && value != nil && reflect.DeepEqual(value, condition)
+	}
+
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$type":
+			if !present || value == nil {
+				return false
+			}
+			found := false
+			for _, alias := range operator.Value.(bson.A) {
+				if alias == typeAlias(value) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case "$gt", "$lt":
+			if !present || value == nil {
+				return false
+			}
+			valueBracket, _ := sortBracket(value)
+			operandBracket, _ := sortBracket(operator.Value)
+			if valueBracket != operandBracket {
+				return false
+			}
+			if operator.Key == "$gt" && position(value) <= position(operator.Value) {
+				return false
+			}
+			if operator.Key == "$lt" && position(value) >= position(operator.Value) {
+				return false
+			}
+		default:
+			t.Fatalf("unexpected operator %s", operator.Key)
+		}
+	}
+	return true
+}
+
+// checkKeyset checks that for every document of ordered, listed in sort order, the keyset filter
+// built from its cursor matches exactly the documents after it
+func checkKeyset(t *testing.T, sort bson.D, ordered []bson.M) {
+	t.Helper()
+	keys, err := normalizeSort(sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, last := range ordered {
+		token, err := encodeCursor(keys, last)
+		if err != nil {
+			t.Fatalf("encodeCursor(%v) error = %v", last, err)
+		}
+		cursor, err := decodeCursor(token, keys)
+		if err != nil {
+			t.Fatalf("decodeCursor() error = %v", err)
+		}
+		filter := keysetFilter(keys, cursor)
+
+		for j, doc := range ordered {
+			if got, want := matches(t, doc, filter), j > i; got != want {
+				t.Errorf("after %v, filter %v matches %v = %v, want %v", last, filter, doc, got, want)
+			}
+		}
+	}
+}
+
+func TestKeysetFilterMixedTypes(t *testing.T) {
+	ascending := make([]bson.M, 0, len(sortedValues))
+	for i, value := range sortedValues {
+		ascending = append(ascending, bson.M{"_id": int32(100 + i), "a": value})
+	}
+	descending := make([]bson.M, 0, len(ascending))
+	for i := len(ascending) - 1; i >= 0; i-- {
+		descending = append(descending, ascending[i])
+	}
+
+	t.Run("ascending", func(t *testing.T) {
+		checkKeyset(t, bson.D{{Key: "a", Value: 1}}, ascending)
+	})
+	t.Run("descending", func(t *testing.T) {
+		checkKeyset(t, bson.D{{Key: "a", Value: -1}}, descending)
+	})
+}
+
+func TestKeysetFilterNullAndMissing(t *testing.T) {
+	// Null and missing values tie and are ordered by _id, like equal values
+	ascending := []bson.M{
+		{"_id": int32(1)},
+		{"_id": int32(2), "a": nil},
+		{"_id": int32(3)},
+		{"_id": int32(4), "a": int32(-1)},
+		{"_id": int32(5), "a": int32(-1)},
+		{"_id": int32(6), "a": "a"},
+		{"_id": int32(7), "a": true},
+	}
+	descending := make([]bson.M, 0, len(ascending))
+	for i := len(ascending) - 1; i >= 0; i-- {
+		descending = append(descending, ascending[i])
+	}
+
+	t.Run("ascending", func(t *testing.T) {
+		checkKeyset(t, bson.D{{Key: "a", Value: 1}}, ascending)
+	})
+	t.Run("descending", func(t *testing.T) {
+		checkKeyset(t, bson.D{{Key: "a", Value: -1}}, descending)
+	})
+}
+
+func TestKeysetFilterCompoundSort(t *testing.T) {
+	// a ascending, then b descending, then _id descending
+	ordered := []bson.M{
+		{"_id": int32(1), "b": "b"},
+		{"_id": int32(3), "b": "a"},
+		{"_id": int32(2), "b": "a"},
+		{"_id": int32(4), "a": nil},
+		{"_id": int32(5), "a": int32(-1), "b": true},
+		{"_id": int32(6), "a": int32(-1), "b": int64(3)},
+		{"_id": int32(7), "a": int32(-1)},
+		{"_id": int32(8), "a": "a", "b": nil},
+	}
+	checkKeyset(t, bson.D{{Key: "a", Value: 1}, {Key: "b", Value: -1}}, ordered)
+}
+
+func TestKeysetFilterLastPosition(t *testing.T) {
+	keys, err := normalizeSort(bson.D{{Key: "a", Value: 1}, {Key: "_id", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := keysetFilter(keys, pageCursor{Keys: []string{"a", "_id"}, Values: bson.A{bson.MaxKey{}, bson.MaxKey{}}})
+	want := bson.D{{Key: "$expr", Value: false}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("keysetFilter() after the last position = %v, want %v", filter, want)
+	}
+}
+
+func TestCursorEncoding(t *testing.T) {
+	keys, err := normalizeSort(bson.D{{Key: "a", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeys, err := normalizeSort(bson.D{{Key: "b", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("positional", func(t *testing.T) {
+		token, err := encodeOffset(keys, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, err := decodeCursor(token, keys)
+		if err != nil {
+			t.Fatalf("decodeCursor() error = %v", err)
+		}
+		if !cursor.positional() || cursor.Offset != 200 {
+			t.Errorf("decodeCursor() = %+v, want a positional cursor at 200", cursor)
+		}
+	})
+
+	t.Run("array value", func(t *testing.T) {
+		_, err := encodeCursor(keys, bson.M{"_id": int32(1), "a": bson.A{int32(1), int32(2)}})
+		if !errors.Is(err, ErrInvalidPagination) {
+			t.Errorf("encodeCursor() error = %v, want ErrInvalidPagination", err)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		cursor pageCursor
+		keys   []sortKey
+	}{
+		{name: "other sort", cursor: pageCursor{Keys: []string{"a", "_id"}, Values: bson.A{int32(1), int32(1)}}, keys: otherKeys},
+		{name: "missing values", cursor: pageCursor{Keys: []string{"a", "_id"}, Values: bson.A{int32(1)}}, keys: keys},
+		{name: "array value", cursor: pageCursor{Keys: []string{"a", "_id"}, Values: bson.A{bson.A{int32(1)}, int32(1)}}, keys: keys},
+		{name: "no offset", cursor: pageCursor{Keys: []string{"a", "_id"}}, keys: keys},
+		{name: "values and offset", cursor: pageCursor{Keys: []string{"a", "_id"}, Values: bson.A{int32(1), int32(1)}, Offset: 10}, keys: keys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := marshalCursor(tt.cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decodeCursor(token, tt.keys); !errors.Is(err, ErrInvalidPagination) {
+				t.Errorf("decodeCursor() error = %v, want ErrInvalidPagination", err)
+			}
+		})
+	}
+
+	if _, err := decodeCursor("not a cursor!", keys); !errors.Is(err, ErrInvalidPagination) {
+		t.Errorf("decodeCursor() of garbage error = %v, want ErrInvalidPagination", err)
+	}
+}
+
+func TestUnorderableFilter(t *testing.T) {
+	idOnly, err := normalizeSort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter := unorderableFilter(idOnly); filter != nil {
+		t.Errorf("unorderableFilter() for an _id sort = %v, want nil", filter)
+	}
+
+	keys, err := normalizeSort(bson.D{{Key: "a", Value: 1}, {Key: "b.c", Value: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := unorderableFilter(keys)
+	for _, doc := range []bson.M{{"a": bson.A{}}, {"b.c": bson.Regex{Pattern: "x"}}} {
+		for field, value := range doc {
+			if _, ok := sortBracket(value); ok {
+				t.Fatalf("%v is orderable", value)
+			}
+			found := false
+			for _, branch := range filter[0].Value.(bson.A) {
+				if branch.(bson.D)[0].Key == field {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("unorderableFilter() = %v has no condition on %q", filter, field)
+			}
+		}
+	}
+}
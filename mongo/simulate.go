@@ -0,0 +1,439 @@
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// updateStages translates an update into the aggregation stages that compute the updated
+// documents, so dry runs can preview an update without applying it. Pipeline updates already are
+// aggregation stages. Update documents are mapped operator by operator onto one $set stage, whose
+// expressions all see the original document like the operators of an update do, followed by an
+// $unset stage. $setOnInsert only applies when upsert is set. Updates whose outcome can't be
+// expressed faithfully, such as those using positional paths, are refused with ErrInvalidOperation.
+// The operators are reimplemented here rather than run by the server, so the result is an
+// approximation; checkArrayPaths covers the paths it can only tell apart once the documents are read.
+func updateStages(update interface{}, upsert bool) ([]bson.D, error) {
+	if pipeline, ok := update.(bson.A); ok {
+		stages := make([]bson.D, 0, len(pipeline))
+		for _, stage := range pipeline {
+			stages = append(stages, stage.(bson.D))
+		}
+		return stages, nil
+	}
+
+	set := bson.D{}
+	unset := bson.A{}
+	for _, operator := range update.(bson.D) {
+		for _, field := range operator.Value.(bson.D) {
+			if err := checkPreviewPath(field.Key); err != nil {
+				return nil, err
+			}
+
+			var expression interface{}
+			switch operator.Key {
+			case "$set":
+				expression = literal(field.Value)
+			case "$setOnInsert":
+				if !upsert {
+					continue
+				}
+				expression = literal(field.Value)
+			case "$unset":
+				unset = append(unset, field.Key)
+				continue
+			case "$inc":
+				expression = ifMissing(field.Key, literal(field.Value), bson.D{{Key: "$add", Value: bson.A{"$" + field.Key, literal(field.Value)}}})
+			case "$mul":
+				// Multiplying a missing field sets it to zero of the multiplier's type
+				expression = ifMissing(field.Key, bson.D{{Key: "$multiply", Value: bson.A{literal(field.Value), 0}}},
+					bson.D{{Key: "$multiply", Value: bson.A{"$" + field.Key, literal(field.Value)}}})
+			case "$min", "$max":
+				comparison := "$lt"
+				if operator.Key == "$max" {
+					comparison = "$gt"
+				}
+				expression = bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$or", Value: bson.A{
+						isMissing(field.Key),
+						bson.D{{Key: comparison, Value: bson.A{literal(field.Value), "$" + field.Key}}},
+					}}},
+					literal(field.Value),
+					"$" + field.Key,
+				}}}
+			case "$rename":
+				target, ok := field.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("%w: $rename target of %q must be a string", ErrInvalidUpdate, field.Key)
+				}
+				if err := checkPreviewPath(target); err != nil {
+					return nil, err
+				}
+				set = append(set, bson.E{Key: target, Value: ifMissing(field.Key, "$"+target, "$"+field.Key)})
+				unset = append(unset, field.Key)
+				continue
+			case "$currentDate":
+				expression = "$$NOW"
+				if spec, ok := field.Value.(bson.D); ok && reflect.DeepEqual(spec, bson.D{{Key: "$type", Value: "timestamp"}}) {
+					expression = "$$CLUSTER_TIME"
+				}
+			case "$push":
+				pushed, err := pushExpression(field.Key, field.Value)
+				if err != nil {
+					return nil, err
+				}
+				expression = pushed
+			case "$addToSet":
+				values := distinct(eachValues(field.Value))
+				expression = bson.D{{Key: "$concatArrays", Value: bson.A{
+					arrayOf(field.Key),
+					bson.D{{Key: "$filter", Value: bson.D{
+						{Key: "input", Value: literal(values)},
+						{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$in", Value: bson.A{"$$this", arrayOf(field.Key)}}}}}}},
+					}}},
+				}}}
+			case "$pop":
+				size := bson.D{{Key: "$size", Value: "$" + field.Key}}
+				remaining := bson.D{{Key: "$slice", Value: bson.A{"$" + field.Key, bson.D{{Key: "$subtract", Value: bson.A{size, 1}}}}}}
+				if n, _ := integer(field.Value); n == -1 {
+					remaining = bson.D{{Key: "$slice", Value: bson.A{"$" + field.Key, 1, size}}}
+				}
+				expression = ifMissing(field.Key, "$$REMOVE", bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$lte", Value: bson.A{size, 1}}},
+					bson.A{},
+					remaining,
+				}}})
+			case "$pull", "$pullAll":
+				var condition interface{}
+				if operator.Key == "$pullAll" {
+					values, ok := field.Value.(bson.A)
+					if !ok {
+						return nil, fmt.Errorf("%w: $pullAll of %q must be an array", ErrInvalidUpdate, field.Key)
+					}
+					condition = bson.D{{Key: "$in", Value: bson.A{"$$this", literal(values)}}}
+				} else {
+					var err error
+					if condition, err = pullCondition(field.Value); err != nil {
+						return nil, err
+					}
+				}
+				expression = ifMissing(field.Key, "$$REMOVE", bson.D{{Key: "$filter", Value: bson.D{
+					{Key: "input", Value: "$" + field.Key},
+					{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{condition}}}},
+				}}})
+			default:
+				return nil, fmt.Errorf("%w: dry runs can't preview %s", ErrInvalidOperation, operator.Key)
+			}
+			set = append(set, bson.E{Key: field.Key, Value: expression})
+		}
+	}
+
+	stages := []bson.D{}
+	if len(set) > 0 {
+		stages = append(stages, bson.D{{Key: "$set", Value: set}})
+	}
+	if len(unset) > 0 {
+		stages = append(stages, bson.D{{Key: "$unset", Value: unset}})
+	}
+	return stages, nil
+}
+
+// checkPreviewPath refuses the paths aggregation expressions address differently than updates:
+// positional operators and array indexes
+func checkPreviewPath(path string) error {
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" || strings.HasPrefix(segment, "$") || strings.Trim(segment, "0123456789") == "" {
+			return fmt.Errorf("%w: dry runs can't preview updates of positional or array index paths such as %q", ErrInvalidOperation, path)
+		}
+	}
+	return nil
+}
+
+// updatePaths lists the fields an update document writes, including the targets of $rename.
+// Pipeline updates are aggregation stages already and address paths the same way, so they have none.
+func updatePaths(update interface{}) []string {
+	operators, ok := update.(bson.D)
+	if !ok {
+		return nil
+	}
+	paths := []string{}
+	for _, operator := range operators {
+		fields, ok := operator.Value.(bson.D)
+		if !ok {
+			continue
+		}
+		for _, field := range fields {
+			paths = append(paths, field.Key)
+			if target, ok := field.Value.(string); ok && operator.Key == "$rename" {
+				paths = append(paths, target)
+			}
+		}
+	}
+	return paths
+}
+
+// checkArrayPaths refuses dotted paths that go through an array in one of docs. An update fails
+// or addresses the array itself there, while an aggregation applies the path to every element.
+func checkArrayPaths(docs []bson.M, paths []string) error {
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+		for _, doc := range docs {
+			var value interface{} = doc
+			for i, segment := range segments[:len(segments)-1] {
+				value = fieldValue(value, segment)
+				if _, ok := value.(bson.A); ok {
+					return fmt.Errorf("%w: dry runs can't preview %q, %q holds an array in document %v", ErrInvalidOperation, path, strings.Join(segments[:i+1], "."), doc["_id"])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fieldValue returns the field of an embedded document, or nil when value isn't a document
+func fieldValue(value interface{}, field string) interface{} {
+	switch doc := value.(type) {
+	case bson.M:
+		return doc[field]
+	case bson.D:
+		for _, elem := range doc {
+			if elem.Key == field {
+				return elem.Value
+			}
+		}
+	}
+	return nil
+}
+
+// pushExpression appends the values of a $push, applying its $position, $sort and $slice modifiers
+// in the order the server does
+func pushExpression(path string, value interface{}) (interface{}, error) {
+	var expression interface{} = bson.D{{Key: "$concatArrays", Value: bson.A{arrayOf(path), literal(eachValues(value))}}}
+	modifiers, ok := value.(bson.D)
+	if !ok || len(modifiers) == 0 || modifiers[0].Key != "$each" {
+		return expression, nil
+	}
+
+	if position, ok := lookupModifier(modifiers, "$position"); ok {
+		at, ok := integer(position)
+		if !ok || at < 0 {
+			return nil, fmt.Errorf("%w: dry runs can't preview $push with a negative or non-integer $position", ErrInvalidOperation)
+		}
+		var head interface{} = bson.A{}
+		if at > 0 {
+			head = bson.D{{Key: "$slice", Value: bson.A{arrayOf(path), at}}}
+		}
+		tail := bson.D{{Key: "$slice", Value: bson.A{arrayOf(path), at, bson.D{{Key: "$max", Value: bson.A{1, bson.D{{Key: "$size", Value: arrayOf(path)}}}}}}}}
+		expression = bson.D{{Key: "$concatArrays", Value: bson.A{head, literal(eachValues(value)), tail}}}
+	}
+	if sort, ok := lookupModifier(modifiers, "$sort"); ok {
+		expression = bson.D{{Key: "$sortArray", Value: bson.D{{Key: "input", Value: expression}, {Key: "sortBy", Value: sort}}}}
+	}
+	if slice, ok := lookupModifier(modifiers, "$slice"); ok {
+		n, ok := integer(slice)
+		if !ok {
+			return nil, fmt.Errorf("%w: $slice must be an integer", ErrInvalidUpdate)
+		}
+		if n == 0 {
+			expression = bson.A{}
+		} else {
+			expression = bson.D{{Key: "$slice", Value: bson.A{expression, n}}}
+		}
+	}
+	return expression, nil
+}
+
+// pullCondition turns a $pull condition into an expression on $$this, the array element. Plain
+// values are compared for equality; query operators and conditions on the fields of embedded
+// documents are supported as long as they only compare values.
+func pullCondition(value interface{}) (interface{}, error) {
+	condition, ok := value.(bson.D)
+	if !ok {
+		return bson.D{{Key: "$eq", Value: bson.A{"$$this", literal(value)}}}, nil
+	}
+	if isOperatorDocument(condition) {
+		return compareExpression("$$this", condition)
+	}
+
+	all := bson.A{}
+	for _, field := range condition {
+		if strings.HasPrefix(field.Key, "$") {
+			return nil, fmt.Errorf("%w: dry runs can't preview $pull conditions using %s", ErrInvalidOperation, field.Key)
+		}
+		operand := "$$this." + field.Key
+		if nested, ok := field.Value.(bson.D); ok && isOperatorDocument(nested) {
+			expression, err := compareExpression(operand, nested)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, expression)
+			continue
+		}
+		all = append(all, bson.D{{Key: "$eq", Value: bson.A{operand, literal(field.Value)}}})
+	}
+	return bson.D{{Key: "$and", Value: all}}, nil
+}
+
+// compareExpression evaluates query comparison operators on operand. Range comparisons only match
+// values of the same kind, numbers with numbers and otherwise the same BSON type, as they do in queries.
+func compareExpression(operand string, operators bson.D) (interface{}, error) {
+	all := bson.A{}
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$eq", "$ne":
+			all = append(all, bson.D{{Key: operator.Key, Value: bson.A{operand, literal(operator.Value)}}})
+		case "$gt", "$gte", "$lt", "$lte":
+			sameType := bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: operand}}, bson.D{{Key: "$type", Value: literal(operator.Value)}}}}}
+			if isNumber(operator.Value) {
+				sameType = bson.D{{Key: "$isNumber", Value: operand}}
+			}
+			all = append(all, sameType, bson.D{{Key: operator.Key, Value: bson.A{operand, literal(operator.Value)}}})
+		case "$in", "$nin":
+			values, ok := operator.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s must be an array", ErrInvalidUpdate, operator.Key)
+			}
+			var in interface{} = bson.D{{Key: "$in", Value: bson.A{operand, literal(values)}}}
+			if operator.Key == "$nin" {
+				in = bson.D{{Key: "$not", Value: bson.A{in}}}
+			}
+			all = append(all, in)
+		default:
+			return nil, fmt.Errorf("%w: dry runs can't preview $pull conditions using %s", ErrInvalidOperation, operator.Key)
+		}
+	}
+	return bson.D{{Key: "$and", Value: all}}, nil
+}
+
+// upsertDocument builds the document an upsert starts from: the equality conditions of the filter,
+// which the server copies into the inserted document, and a new _id when the filter names none
+func upsertDocument(filter bson.D) bson.M {
+	doc := bson.M{}
+	addEqualities(doc, filter)
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectID()
+	}
+	return doc
+}
+
+func addEqualities(doc bson.M, filter bson.D) {
+	for _, elem := range filter {
+		if elem.Key == "$and" {
+			items, _ := asArray(elem.Value)
+			for _, item := range items {
+				if condition, ok := item.(bson.D); ok {
+					addEqualities(doc, condition)
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(elem.Key, "$") {
+			continue
+		}
+
+		value := elem.Value
+		if condition, ok := value.(bson.D); ok && isOperatorDocument(condition) {
+			eq, ok := lookupModifier(condition, "$eq")
+			if !ok {
+				continue
+			}
+			value = eq
+		}
+		if _, ok := value.(bson.Regex); ok {
+			continue
+		}
+		setPath(doc, elem.Key, value)
+	}
+}
+
+func setPath(doc bson.M, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := doc[segment].(bson.M)
+		if !ok {
+			child = bson.M{}
+			doc[segment] = child
+		}
+		doc = child
+	}
+	doc[segments[len(segments)-1]] = value
+}
+
+func literal(value interface{}) bson.D {
+	return bson.D{{Key: "$literal", Value: value}}
+}
+
+func isMissing(path string) bson.D {
+	return bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$" + path}}, "missing"}}}
+}
+
+// ifMissing evaluates to missing when the field at path does not exist and to present otherwise
+func ifMissing(path string, missing interface{}, present interface{}) bson.D {
+	return bson.D{{Key: "$cond", Value: bson.A{isMissing(path), missing, present}}}
+}
+
+// arrayOf evaluates to the array at path, or an empty array when the field does not exist
+func arrayOf(path string) bson.D {
+	return bson.D{{Key: "$ifNull", Value: bson.A{"$" + path, bson.A{}}}}
+}
+
+// eachValues returns the values a $push or $addToSet adds: the $each list, or the value itself
+func eachValues(value interface{}) bson.A {
+	if modifiers, ok := value.(bson.D); ok && len(modifiers) > 0 && modifiers[0].Key == "$each" {
+		if each, ok := modifiers[0].Value.(bson.A); ok {
+			return each
+		}
+	}
+	return bson.A{value}
+}
+
+func distinct(values bson.A) bson.A {
+	unique := bson.A{}
+	for _, value := range values {
+		seen := false
+		for _, kept := range unique {
+			if reflect.DeepEqual(kept, value) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func lookupModifier(doc bson.D, key string) (interface{}, bool) {
+	for _, elem := range doc {
+		if elem.Key == key {
+			return elem.Value, true
+		}
+	}
+	return nil, false
+}
+
+func integer(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int32, int64, float64, bson.Decimal128:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package mongo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setStage builds the $set stage updateStages produces for a single field
+func setStage(field string, expression interface{}) bson.D {
+	return bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: expression}}}}
+}
+
+func TestUpdateStages(t *testing.T) {
+	pipeline := bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "total", Value: bson.D{{Key: "$add", Value: bson.A{"$a", "$b"}}}}}}}}
+
+	tests := []struct {
+		name   string
+		update interface{}
+		upsert bool
+		want   []bson.D
+	}{
+		{
+			name:   "pipeline",
+			update: pipeline,
+			want:   []bson.D{pipeline[0].(bson.D)},
+		},
+		{
+			name:   "$set",
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "shipped"}, {Key: "meta.by", Value: "$user"}}}},
+			want: []bson.D{{{Key: "$set", Value: bson.D{
+				{Key: "status", Value: literal("shipped")},
+				{Key: "meta.by", Value: literal("$user")},
+			}}}},
+		},
+		{
+			name:   "$unset",
+			update: bson.D{{Key: "$unset", Value: bson.D{{Key: "draft", Value: ""}}}},
+			want:   []bson.D{{{Key: "$unset", Value: bson.A{"draft"}}}},
+		},
+		{
+			name:   "$setOnInsert without upsert",
+			update: bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "createdBy", Value: "api"}}}},
+			want:   []bson.D{},
+		},
+		{
+			name:   "$setOnInsert with upsert",
+			update: bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "createdBy", Value: "api"}}}},
+			upsert: true,
+			want:   []bson.D{setStage("createdBy", literal("api"))},
+		},
+		{
+			name:   "$inc",
+			update: bson.D{{Key: "$inc", Value: bson.D{{Key: "count", Value: int32(2)}}}},
+			want: []bson.D{setStage("count", ifMissing("count", literal(int32(2)),
+				bson.D{{Key: "$add", Value: bson.A{"$count", literal(int32(2))}}}))},
+		},
+		{
+			name:   "$mul",
+			update: bson.D{{Key: "$mul", Value: bson.D{{Key: "price", Value: 1.5}}}},
+			want: []bson.D{setStage("price", ifMissing("price", bson.D{{Key: "$multiply", Value: bson.A{literal(1.5), 0}}},
+				bson.D{{Key: "$multiply", Value: bson.A{"$price", literal(1.5)}}}))},
+		},
+		{
+			name:   "$max",
+			update: bson.D{{Key: "$max", Value: bson.D{{Key: "score", Value: int32(10)}}}},
+			want: []bson.D{setStage("score", bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$or", Value: bson.A{isMissing("score"), bson.D{{Key: "$gt", Value: bson.A{literal(int32(10)), "$score"}}}}}},
+				literal(int32(10)),
+				"$score",
+			}}})},
+		},
+		{
+			name:   "$rename",
+			update: bson.D{{Key: "$rename", Value: bson.D{{Key: "name", Value: "title"}}}},
+			want: []bson.D{
+				setStage("title", ifMissing("name", "$title", "$name")),
+				{{Key: "$unset", Value: bson.A{"name"}}},
+			},
+		},
+		{
+			name:   "$currentDate timestamp",
+			update: bson.D{{Key: "$currentDate", Value: bson.D{{Key: "seen", Value: bson.D{{Key: "$type", Value: "timestamp"}}}}}},
+			want:   []bson.D{setStage("seen", "$$CLUSTER_TIME")},
+		},
+		{
+			name:   "$currentDate date",
+			update: bson.D{{Key: "$currentDate", Value: bson.D{{Key: "seen", Value: true}}}},
+			want:   []bson.D{setStage("seen", "$$NOW")},
+		},
+		{
+			name:   "$push",
+			update: bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: "new"}}}},
+			want:   []bson.D{setStage("tags", bson.D{{Key: "$concatArrays", Value: bson.A{arrayOf("tags"), literal(bson.A{"new"})}}})},
+		},
+		{
+			name: "$push with $each, $sort and $slice",
+			update: bson.D{{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.D{
+				{Key: "$each", Value: bson.A{int32(3), int32(1)}},
+				{Key: "$sort", Value: int32(-1)},
+				{Key: "$slice", Value: int32(2)},
+			}}}}},
+			want: []bson.D{setStage("scores", bson.D{{Key: "$slice", Value: bson.A{
+				bson.D{{Key: "$sortArray", Value: bson.D{
+					{Key: "input", Value: bson.D{{Key: "$concatArrays", Value: bson.A{arrayOf("scores"), literal(bson.A{int32(3), int32(1)})}}}},
+					{Key: "sortBy", Value: int32(-1)},
+				}}},
+				int64(2),
+			}}})},
+		},
+		{
+			name: "$push with $slice of zero",
+			update: bson.D{{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.D{
+				{Key: "$each", Value: bson.A{int32(3)}},
+				{Key: "$slice", Value: int32(0)},
+			}}}}},
+			want: []bson.D{setStage("scores", bson.A{})},
+		},
+		{
+			name:   "$addToSet with $each",
+			update: bson.D{{Key: "$addToSet", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"a", "b", "a"}}}}}}},
+			want: []bson.D{setStage("tags", bson.D{{Key: "$concatArrays", Value: bson.A{
+				arrayOf("tags"),
+				bson.D{{Key: "$filter", Value: bson.D{
+					{Key: "input", Value: literal(bson.A{"a", "b"})},
+					{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$in", Value: bson.A{"$$this", arrayOf("tags")}}}}}}},
+				}}},
+			}}})},
+		},
+		{
+			name:   "$pull with a comparison",
+			update: bson.D{{Key: "$pull", Value: bson.D{{Key: "scores", Value: bson.D{{Key: "$in", Value: bson.A{int32(1), int32(2)}}}}}}},
+			want: []bson.D{setStage("scores", ifMissing("scores", "$$REMOVE", bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: "$scores"},
+				{Key: "cond", Value: bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$and", Value: bson.A{
+					bson.D{{Key: "$in", Value: bson.A{"$$this", literal(bson.A{int32(1), int32(2)})}}},
+				}}}}}}},
+			}}}))},
+		},
+		{
+			name: "operators combined",
+			update: bson.D{
+				{Key: "$set", Value: bson.D{{Key: "status", Value: "done"}}},
+				{Key: "$unset", Value: bson.D{{Key: "draft", Value: ""}}},
+			},
+			want: []bson.D{
+				setStage("status", literal("done")),
+				{{Key: "$unset", Value: bson.A{"draft"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := updateStages(tt.update, tt.upsert)
+			if err != nil {
+				t.Fatalf("updateStages() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("updateStages() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateStagesRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		update bson.D
+		want   error
+	}{
+		{name: "positional path", update: bson.D{{Key: "$set", Value: bson.D{{Key: "items.$.qty", Value: int32(1)}}}}, want: ErrInvalidOperation},
+		{name: "all positional path", update: bson.D{{Key: "$inc", Value: bson.D{{Key: "items.$[].qty", Value: int32(1)}}}}, want: ErrInvalidOperation},
+		{name: "array index", update: bson.D{{Key: "$set", Value: bson.D{{Key: "items.0.qty", Value: int32(1)}}}}, want: ErrInvalidOperation},
+		{name: "$rename into an array index", update: bson.D{{Key: "$rename", Value: bson.D{{Key: "a", Value: "b.1"}}}}, want: ErrInvalidOperation},
+		{name: "$rename to a non-string", update: bson.D{{Key: "$rename", Value: bson.D{{Key: "a", Value: int32(1)}}}}, want: ErrInvalidUpdate},
+		{name: "negative $position", update: bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"a"}}, {Key: "$position", Value: int32(-1)}}}}}}, want: ErrInvalidOperation},
+		{name: "$pull with $elemMatch", update: bson.D{{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "a", Value: int32(1)}}}}}}}}, want: ErrInvalidOperation},
+		{name: "$pull with $regex on a field", update: bson.D{{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^a"}}}}}}}}, want: ErrInvalidOperation},
+		{name: "$bit", update: bson.D{{Key: "$bit", Value: bson.D{{Key: "flags", Value: bson.D{{Key: "and", Value: int32(1)}}}}}}, want: ErrInvalidOperation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := updateStages(tt.update, false); !errors.Is(err, tt.want) {
+				t.Errorf("updateStages() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckArrayPaths(t *testing.T) {
+	docs := []bson.M{
+		{"_id": int32(1), "meta": bson.D{{Key: "by", Value: "a"}}, "tags": bson.A{"x"}},
+		{"_id": int32(2), "items": bson.A{bson.D{{Key: "qty", Value: int32(1)}}}},
+	}
+
+	tests := []struct {
+		name    string
+		update  interface{}
+		wantErr bool
+	}{
+		{name: "top-level field", update: bson.D{{Key: "$set", Value: bson.D{{Key: "items", Value: bson.A{}}}}}},
+		{name: "through an embedded document", update: bson.D{{Key: "$set", Value: bson.D{{Key: "meta.by", Value: "b"}}}}},
+		{name: "through a missing field", update: bson.D{{Key: "$set", Value: bson.D{{Key: "other.by", Value: "b"}}}}},
+		{name: "array as the target", update: bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: "y"}}}}},
+		{name: "through an array", update: bson.D{{Key: "$inc", Value: bson.D{{Key: "items.qty", Value: int32(1)}}}}, wantErr: true},
+		{name: "$rename target through an array", update: bson.D{{Key: "$rename", Value: bson.D{{Key: "meta.by", Value: "tags.by"}}}}, wantErr: true},
+		{name: "pipeline", update: bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "items.qty", Value: int32(1)}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkArrayPaths(docs, updatePaths(tt.update))
+			if tt.wantErr && !errors.Is(err, ErrInvalidOperation) {
+				t.Errorf("checkArrayPaths() error = %v, want ErrInvalidOperation", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkArrayPaths() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestUpsertDocument(t *testing.T) {
+	id := bson.NewObjectID()
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "status", Value: bson.D{{Key: "$eq", Value: "new"}}},
+		{Key: "meta.owner", Value: "a"},
+		{Key: "score", Value: bson.D{{Key: "$gt", Value: int32(1)}}},
+		{Key: "name", Value: bson.Regex{Pattern: "^a"}},
+		{Key: "$and", Value: bson.A{bson.D{{Key: "region", Value: "eu"}}}},
+	}
+
+	want := bson.M{"_id": id, "status": "new", "meta": bson.M{"owner": "a"}, "region": "eu"}
+	if got := upsertDocument(filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("upsertDocument() = %v, want %v", got, want)
+	}
+
+	if got := upsertDocument(bson.D{{Key: "status", Value: "new"}}); got["_id"] == nil {
+		t.Errorf("upsertDocument() = %v, want a generated _id", got)
+	}
+}
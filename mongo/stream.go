@@ -0,0 +1,75 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"mongo-manager/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// StreamBatchSize is the number of documents fetched from the server per cursor round trip while streaming
+const StreamBatchSize int32 = 500
+
+// Stream iterates over every document matching the request and hands each one to fn as it is
+// read from the cursor, so whole collections can be exported with constant memory.
+// Limit is optional here and 0 means no limit. Iteration stops as soon as ctx is cancelled,
+// for example when the HTTP client disconnects, or when fn returns an error.
+func Stream(ctx context.Context, request types.Request, fn func(bson.M) error) error {
+	collection := Client.Database(request.Database).Collection(request.Collection)
+
+	filter, err := NormalizeFilter(request.Database, request.Collection, request.Filter)
+	if err != nil {
+		return err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	opts := options.Find().SetBatchSize(StreamBatchSize)
+	if len(request.Sort) > 0 {
+		opts.SetSort(request.Sort)
+	}
+	if len(request.Projection) > 0 {
+		opts.SetProjection(request.Projection)
+	}
+	if request.Limit > 0 {
+		opts.SetLimit(request.Limit)
+	}
+	if request.Skip > 0 {
+		opts.SetSkip(request.Skip)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error finding documents: %v", err)
+		return err
+	}
+
+	return drainCursor(ctx, cursor, fn)
+}
+
+// drainCursor decodes each document of the cursor into fn and always closes the cursor,
+// which also kills it on the server when iteration stops early.
+func drainCursor(ctx context.Context, cursor *mongo.Cursor, fn func(bson.M) error) error {
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		doc := bson.M{}
+		if err := cursor.Decode(&doc); err != nil {
+			log.Printf("Error decoding document: %v", err)
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error iterating cursor: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MaxTransactionOperations caps the number of steps accepted in a single transaction
+const MaxTransactionOperations = 1000
+
+// Write operation types accepted by transactions and bulk writes
+const (
+	OperationInsertOne        = "insertOne"
+	OperationUpdateOne        = "updateOne"
+	OperationUpdateMany       = "updateMany"
+	OperationReplaceOne       = "replaceOne"
+	OperationDeleteOne        = "deleteOne"
+	OperationDeleteMany       = "deleteMany"
+	OperationFindOneAndUpdate = "findOneAndUpdate"
+)
+
+// ErrInvalidOperation is returned when a transaction or bulk write contains a malformed operation
+var ErrInvalidOperation = errors.New("invalid write operation")
+
+// StepError reports which step of a transaction failed. The whole transaction has been rolled back.
+type StepError struct {
+	Index int
+	Type  string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d (%s) failed: %v", e.Index, e.Type, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// RunTransaction executes the operations in order inside one multi-document transaction.
+// The driver's WithTransaction retries the whole callback on TransientTransactionError and retries
+// the commit on UnknownTransactionCommitResult, so callers only see errors that survived the retries.
+// Either every step is committed or none is.
+func RunTransaction(ctx context.Context, request types.TransactionRequest) (types.TransactionResult, error) {
+	if len(request.Operations) == 0 {
+		return types.TransactionResult{}, fmt.Errorf("%w: at least one operation is required", ErrInvalidOperation)
+	}
+	if len(request.Operations) > MaxTransactionOperations {
+		return types.TransactionResult{}, fmt.Errorf("%w: a transaction accepts at most %d operations", ErrInvalidOperation, MaxTransactionOperations)
+	}
+
+	session, err := Client.StartSession()
+	if err != nil {
+		log.Printf("Error starting session: %v", err)
+		return types.TransactionResult{}, err
+	}
+	defer session.EndSession(context.Background())
+
+	steps, err := session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		// Results are rebuilt on every attempt so a retried transaction never reports stale steps
+		results := make([]types.WriteOperationResult, 0, len(request.Operations))
+		for i, operation := range request.Operations {
+			result, err := executeOperation(ctx, request.Database, operation, request.Actor)
+			if err != nil {
+				return nil, &StepError{Index: i, Type: operation.Type, Err: err}
+			}
+			result.Index = i
+			results = append(results, result)
+		}
+		return results, nil
+	})
+	if err != nil {
+		log.Printf("Error running transaction: %v", err)
+		return types.TransactionResult{}, err
+	}
+
+	return types.TransactionResult{Committed: true, Steps: steps.([]types.WriteOperationResult)}, nil
+}
+
+// executeOperation runs a single write operation against database using ctx, which carries the session.
+// Deletes on soft-delete collections move the documents to the trash on behalf of actor, and writes
+// to collections with revision history record the prior versions in the same transaction.
+func executeOperation(ctx context.Context, database string, operation types.WriteOperation, actor string) (types.WriteOperationResult, error) {
+	result := types.WriteOperationResult{Type: operation.Type, Collection: operation.Collection}
+	if operation.Collection == "" {
+		return result, fmt.Errorf("%w: collection is required", ErrInvalidOperation)
+	}
+
+	filter, err := NormalizeFilter(database, operation.Collection, operation.Filter)
+	if err != nil {
+		return result, err
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	if operation.Type != OperationInsertOne && HistoryEnabled(database, operation.Collection) {
+		// Deletes run without a collation and only findOneAndUpdate takes a sort, so the documents
+		// recorded are the ones the step writes
+		collation, sort, one, historyOperation := operation.Collation, bson.D(nil), true, HistoryUpdate
+		switch operation.Type {
+		case OperationUpdateMany:
+			one = false
+		case OperationReplaceOne:
+			historyOperation = HistoryReplace
+		case OperationDeleteOne, OperationDeleteMany:
+			collation, one, historyOperation = nil, operation.Type == OperationDeleteOne, HistoryDelete
+		case OperationFindOneAndUpdate:
+			sort = operation.Sort
+		}
+		written, err := recordWrite(ctx, database, operation.Collection, filter, collation, sort, one, historyOperation, actor, func(ctx context.Context, filter bson.D) (interface{}, error) {
+			return runOperation(ctx, database, operation, filter, actor)
+		})
+		if err != nil {
+			return result, err
+		}
+		return written.(types.WriteOperationResult), nil
+	}
+	return runOperation(ctx, database, operation, filter, actor)
+}
+
+// runOperation runs a single write operation with the given normalized filter
+func runOperation(ctx context.Context, database string, operation types.WriteOperation, filter bson.D, actor string) (types.WriteOperationResult, error) {
+	result := types.WriteOperationResult{Type: operation.Type, Collection: operation.Collection}
+	collection := Client.Database(database).Collection(operation.Collection)
+
+	switch operation.Type {
+	case OperationInsertOne:
+		if operation.Document == nil {
+			return result, fmt.Errorf("%w: insertOne requires a document", ErrInvalidOperation)
+		}
+		inserted, err := collection.InsertOne(ctx, operation.Document)
+		if err != nil {
+			return result, err
+		}
+		result.InsertedID = inserted.InsertedID
+
+	case OperationUpdateOne, OperationUpdateMany:
+		update, err := BuildUpdate(nil, operation.Update)
+		if err != nil {
+			return result, err
+		}
+
+		var updated *mongo.UpdateResult
+		if operation.Type == OperationUpdateOne {
+			opts := options.UpdateOne().SetUpsert(operation.Upsert)
+			if len(operation.ArrayFilters) > 0 {
+				opts.SetArrayFilters(arrayFilters(operation.ArrayFilters))
+			}
+			if operation.Collation != nil {
+				opts.SetCollation(toCollation(operation.Collation))
+			}
+			updated, err = collection.UpdateOne(ctx, filter, update, opts)
+		} else {
+			opts := options.UpdateMany().SetUpsert(operation.Upsert)
+			if len(operation.ArrayFilters) > 0 {
+				opts.SetArrayFilters(arrayFilters(operation.ArrayFilters))
+			}
+			if operation.Collation != nil {
+				opts.SetCollation(toCollation(operation.Collation))
+			}
+			updated, err = collection.UpdateMany(ctx, filter, update, opts)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.MatchedCount = updated.MatchedCount
+		result.ModifiedCount = updated.ModifiedCount
+		result.UpsertedID = updated.UpsertedID
+
+	case OperationReplaceOne:
+		if err := validateReplacement(operation.Replacement); err != nil {
+			return result, err
+		}
+		opts := options.Replace().SetUpsert(operation.Upsert)
+		if operation.Collation != nil {
+			opts.SetCollation(toCollation(operation.Collation))
+		}
+		replaced, err := collection.ReplaceOne(ctx, filter, operation.Replacement, opts)
+		if err != nil {
+			return result, err
+		}
+		result.MatchedCount = replaced.MatchedCount
+		result.ModifiedCount = replaced.ModifiedCount
+		result.UpsertedID = replaced.UpsertedID
+
+	case OperationDeleteOne, OperationDeleteMany:
+		if SoftDeleteEnabled(database, operation.Collection) {
+			deleted, err := trashDocuments(ctx, database, operation.Collection, filter, operation.Type == OperationDeleteOne, actor)
+			if err != nil {
+				return result, err
+			}
+			result.DeletedCount = deleted
+			break
+		}
+
+		var deleted *mongo.DeleteResult
+		var err error
+		if operation.Type == OperationDeleteOne {
+			deleted, err = collection.DeleteOne(ctx, filter)
+		} else {
+			deleted, err = collection.DeleteMany(ctx, filter)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.DeletedCount = deleted.DeletedCount
+
+	case OperationFindOneAndUpdate:
+		update, err := BuildUpdate(nil, operation.Update)
+		if err != nil {
+			return result, err
+		}
+		returnDoc, err := returnDocument(operation.ReturnDocument)
+		if err != nil {
+			return result, err
+		}
+
+		opts := options.FindOneAndUpdate().
+			SetUpsert(operation.Upsert).
+			SetReturnDocument(returnDoc)
+		if len(operation.ArrayFilters) > 0 {
+			opts.SetArrayFilters(arrayFilters(operation.ArrayFilters))
+		}
+		if len(operation.Sort) > 0 {
+			opts.SetSort(operation.Sort)
+		}
+		if len(operation.Projection) > 0 {
+			opts.SetProjection(operation.Projection)
+		}
+		if operation.Collation != nil {
+			opts.SetCollation(toCollation(operation.Collation))
+		}
+
+		doc, err := decodeSingleResult(collection.FindOneAndUpdate(ctx, filter, update, opts))
+		if err != nil {
+			return result, err
+		}
+		result.Document = doc
+		if doc != nil {
+			result.MatchedCount = 1
+		}
+
+	default:
+		return result, fmt.Errorf("%w: unknown operation type %q", ErrInvalidOperation, operation.Type)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,299 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/tenancy"
+	"mongo-manager/types"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// TrashSuffix is appended to a collection name to form the name of its trash collection
+const TrashSuffix = "_trash"
+
+// DefaultTrashRetentionDays is how long trashed documents are kept when the config does not say
+const DefaultTrashRetentionDays = 30
+
+// TrashPurgeInterval is how often expired trash entries are purged in the background
+const TrashPurgeInterval = time.Hour
+
+// Fields of a trash entry. The discriminator field is copied from the deleted document as well, so
+// trash entries can be scoped to an organization like any other document.
+const (
+	TrashDocumentIDField = "documentId"
+	TrashDocumentField   = "document"
+	TrashDeletedAtField  = "deletedAt"
+	TrashDeletedByField  = "deletedBy"
+)
+
+// SoftDeleteConfig lists the collections whose deletes move documents to the trash instead of
+// removing them. Collections are keyed by "database.collection".
+type SoftDeleteConfig struct {
+	RetentionDays int                         `json:"retentionDays"`
+	Collections   map[string]SoftDeletePolicy `json:"collections"`
+}
+
+// SoftDeletePolicy configures the trash of one collection. A zero RetentionDays falls back to the
+// config-wide retention.
+type SoftDeletePolicy struct {
+	RetentionDays int `json:"retentionDays"`
+}
+
+var softDeleteConfig SoftDeleteConfig
+
+// trashEntry is the shape of a document in a trash collection
+type trashEntry struct {
+	ID         bson.ObjectID `bson:"_id"`
+	DocumentID interface{}   `bson:"documentId"`
+	Document   bson.M        `bson:"document"`
+	DeletedAt  time.Time     `bson:"deletedAt"`
+	DeletedBy  string        `bson:"deletedBy"`
+}
+
+func loadSoftDeleteConfig() {
+	path := os.Getenv("SOFT_DELETE_CONFIG_PATH")
+	if path == "" {
+		path = "soft_delete.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: could not read soft delete config from %s: %v", path, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &softDeleteConfig); err != nil {
+		log.Printf("Warning: could not parse soft delete config from %s: %v", path, err)
+	}
+}
+
+// SetSoftDeleteConfig replaces the active soft delete config
+func SetSoftDeleteConfig(config SoftDeleteConfig) {
+	softDeleteConfig = config
+}
+
+// SoftDeleteEnabled reports whether deletes on the collection move documents to its trash
+func SoftDeleteEnabled(database, collection string) bool {
+	_, ok := softDeleteConfig.Collections[database+"."+collection]
+	return ok
+}
+
+// TrashCollection returns the name of the trash collection that belongs to collection
+func TrashCollection(collection string) string {
+	return collection + TrashSuffix
+}
+
+// IsTrashCollection reports whether collection is named like a trash collection, which only the
+// trash endpoints may read or modify
+func IsTrashCollection(collection string) bool {
+	return strings.HasSuffix(collection, TrashSuffix)
+}
+
+// trashRetention returns how long trashed documents of the collection are kept
+func trashRetention(database, collection string) time.Duration {
+	days := softDeleteConfig.Collections[database+"."+collection].RetentionDays
+	if days == 0 {
+		days = softDeleteConfig.RetentionDays
+	}
+	if days == 0 {
+		days = DefaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// withTransaction runs fn inside a multi-document transaction on a new session
+func withTransaction(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	session, err := Client.StartSession()
+	if err != nil {
+		log.Printf("Error starting session: %v", err)
+		return nil, err
+	}
+	defer session.EndSession(context.Background())
+
+	return session.WithTransaction(ctx, fn)
+}
+
+// softDelete moves the documents matching filter to the trash in one transaction.
+// With one set only the first matching document is moved, like DeleteOne.
+func softDelete(ctx context.Context, database, collection string, filter bson.D, one bool, actor string) (*mongo.DeleteResult, error) {
+	deleted, err := withTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return trashDocuments(ctx, database, collection, filter, one, actor)
+	})
+	if err != nil {
+		log.Printf("Error moving documents to the trash: %v", err)
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: deleted.(int64), Acknowledged: true}, nil
+}
+
+// trashDocuments copies the documents matching filter into the trash and deletes them. It must run
+// inside a transaction, carried by ctx, so a document is never lost between the two steps.
+func trashDocuments(ctx context.Context, database, collection string, filter bson.D, one bool, actor string) (int64, error) {
+	source := Client.Database(database).Collection(collection)
+	trash := Client.Database(database).Collection(TrashCollection(collection))
+
+	opts := options.Find()
+	if one {
+		opts.SetLimit(1)
+	}
+	cursor, err := source.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	deletedAt := time.Now().UTC()
+	discriminator := tenancy.DiscriminatorField()
+	entries := make([]interface{}, 0, len(docs))
+	ids := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		entry := bson.M{
+			"_id":                bson.NewObjectID(),
+			TrashDocumentIDField: doc["_id"],
+			TrashDocumentField:   doc,
+			TrashDeletedAtField:  deletedAt,
+			TrashDeletedByField:  actor,
+		}
+		if organizationID, ok := doc[discriminator]; ok {
+			entry[discriminator] = organizationID
+		}
+		entries = append(entries, entry)
+		ids = append(ids, doc["_id"])
+	}
+
+	if _, err := trash.InsertMany(ctx, entries); err != nil {
+		return 0, err
+	}
+	result, err := source.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// ListTrash returns one page of the trash entries of a collection. The request filter applies to
+// the entries, so fields of the deleted document are addressed as "document.<field>".
+func ListTrash(request types.Request) (types.Page, error) {
+	request.Collection = TrashCollection(request.Collection)
+	return GetAll(request)
+}
+
+// trashFilter selects the trash entries addressed by a TrashRequest
+func trashFilter(request types.TrashRequest) (bson.D, error) {
+	filter := bson.D{}
+	if request.ObjectId != "" {
+		objId, err := bson.ObjectIDFromHex(request.ObjectId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidObjectID, request.ObjectId)
+		}
+		filter = append(filter, bson.E{Key: TrashDocumentIDField, Value: objId})
+	}
+	return append(filter, request.Scope...), nil
+}
+
+// RestoreFromTrash moves the most recently trashed version of a document back into its collection
+// and returns it. Restoring fails with a duplicate key error if a document with the same _id has
+// been created since, and with mongo.ErrNoDocuments when the trash holds no version of it.
+func RestoreFromTrash(ctx context.Context, request types.TrashRequest) (bson.M, error) {
+	if request.ObjectId == "" {
+		return nil, fmt.Errorf("%w: objectId is required to restore a document", ErrInvalidOperation)
+	}
+	filter, err := trashFilter(request)
+	if err != nil {
+		return nil, err
+	}
+
+	restored, err := withTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		trash := Client.Database(request.Database).Collection(TrashCollection(request.Collection))
+		opts := options.FindOneAndDelete().SetSort(bson.D{{Key: TrashDeletedAtField, Value: -1}})
+
+		var entry trashEntry
+		if err := trash.FindOneAndDelete(ctx, filter, opts).Decode(&entry); err != nil {
+			return nil, err
+		}
+		// Trust the caller's organization rather than whatever the entry holds
+		tenancy.StampDocument(request.OrganizationID, entry.Document)
+		if _, err := Client.Database(request.Database).Collection(request.Collection).InsertOne(ctx, entry.Document); err != nil {
+			return nil, err
+		}
+		return entry.Document, nil
+	})
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error restoring document from the trash: %v", err)
+		}
+		return nil, err
+	}
+	return restored.(bson.M), nil
+}
+
+// PurgeTrash permanently deletes trash entries: every trashed version of one document when an
+// objectId is given, otherwise everything in the trash that matches the request scope
+func PurgeTrash(ctx context.Context, request types.TrashRequest) (*mongo.DeleteResult, error) {
+	filter, err := trashFilter(request)
+	if err != nil {
+		return nil, err
+	}
+
+	trash := Client.Database(request.Database).Collection(TrashCollection(request.Collection))
+	result, err := trash.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Printf("Error purging the trash: %v", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// StartTrashPurge runs purgeExpiredTrash every interval until ctx is cancelled, removing trash
+// entries older than their collection's retention period
+func StartTrashPurge(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purgeExpiredTrash(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func purgeExpiredTrash(ctx context.Context) {
+	for namespace := range softDeleteConfig.Collections {
+		database, collection, ok := strings.Cut(namespace, ".")
+		if !ok {
+			log.Printf("Warning: ignoring soft delete collection %q, expected database.collection", namespace)
+			continue
+		}
+
+		cutoff := time.Now().UTC().Add(-trashRetention(database, collection))
+		trash := Client.Database(database).Collection(TrashCollection(collection))
+		result, err := trash.DeleteMany(ctx, bson.D{{Key: TrashDeletedAtField, Value: bson.D{{Key: "$lt", Value: cutoff}}}})
+		if err != nil {
+			log.Printf("Error purging expired trash of %s: %v", namespace, err)
+			continue
+		}
+		if result.DeletedCount > 0 {
+			log.Printf("Purged %d expired documents from the trash of %s", result.DeletedCount, namespace)
+		}
+	}
+}
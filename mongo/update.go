@@ -0,0 +1,118 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrInvalidUpdate is returned when an update document is malformed or uses an operator that is not allowed
+var ErrInvalidUpdate = errors.New("invalid update")
+
+// DefaultUpdateOperators are the update operators accepted when UPDATE_OPERATOR_ALLOWLIST is not set
+var DefaultUpdateOperators = []string{
+	"$set", "$unset", "$setOnInsert", "$inc", "$mul", "$min", "$max", "$rename", "$currentDate",
+	"$push", "$pull", "$pullAll", "$addToSet", "$pop",
+}
+
+// UpdatePipelineStages are the only stages MongoDB accepts in an aggregation-pipeline update
+var UpdatePipelineStages = []string{"$set", "$addFields", "$project", "$unset", "$replaceRoot", "$replaceWith"}
+
+var updateOperators = map[string]bool{}
+
+func loadUpdateOperators() {
+	operators := DefaultUpdateOperators
+	if configured := os.Getenv("UPDATE_OPERATOR_ALLOWLIST"); configured != "" {
+		operators = strings.Split(configured, ",")
+	}
+	SetUpdateOperators(operators)
+}
+
+// SetUpdateOperators replaces the allowlist of update operators
+func SetUpdateOperators(operators []string) {
+	allowed := map[string]bool{}
+	for _, operator := range operators {
+		allowed[strings.TrimSpace(operator)] = true
+	}
+	updateOperators = allowed
+}
+
+// BuildUpdate returns the update to send to MongoDB.
+// data is the legacy shorthand and becomes {$set: data}. Otherwise update must be either an update
+// document whose top-level keys are all allowlisted operators, or an aggregation pipeline made of
+// update-compatible stages.
+func BuildUpdate(data map[string]interface{}, update interface{}) (interface{}, error) {
+	if update == nil {
+		if data == nil {
+			return nil, fmt.Errorf("%w: data or update is required", ErrInvalidUpdate)
+		}
+		return bson.D{{Key: "$set", Value: data}}, nil
+	}
+	if data != nil {
+		return nil, fmt.Errorf("%w: data and update cannot be combined", ErrInvalidUpdate)
+	}
+
+	switch u := update.(type) {
+	case bson.D:
+		return u, validateUpdateDocument(u)
+	case bson.A:
+		return u, validateUpdatePipeline(u)
+	case []interface{}:
+		return bson.A(u), validateUpdatePipeline(u)
+	}
+	return nil, fmt.Errorf("%w: update must be a document or a pipeline array", ErrInvalidUpdate)
+}
+
+func validateUpdateDocument(update bson.D) error {
+	if len(update) == 0 {
+		return fmt.Errorf("%w: update document is empty", ErrInvalidUpdate)
+	}
+	for _, elem := range update {
+		if !strings.HasPrefix(elem.Key, "$") {
+			return fmt.Errorf("%w: %q is not an update operator, use a replace operation to overwrite documents", ErrInvalidUpdate, elem.Key)
+		}
+		if !updateOperators[elem.Key] {
+			return fmt.Errorf("%w: update operator %s is not allowed", ErrInvalidUpdate, elem.Key)
+		}
+		if _, ok := elem.Value.(bson.D); !ok {
+			return fmt.Errorf("%w: %s must be a document", ErrInvalidUpdate, elem.Key)
+		}
+	}
+	return nil
+}
+
+func validateUpdatePipeline(pipeline []interface{}) error {
+	if len(pipeline) == 0 {
+		return fmt.Errorf("%w: update pipeline is empty", ErrInvalidUpdate)
+	}
+	for i, item := range pipeline {
+		stage, ok := item.(bson.D)
+		if !ok || len(stage) != 1 {
+			return fmt.Errorf("%w: pipeline stage %d must be a document with a single stage", ErrInvalidUpdate, i)
+		}
+
+		allowed := false
+		for _, name := range UpdatePipelineStages {
+			if stage[0].Key == name {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: stage %s is not allowed in an update pipeline", ErrInvalidUpdate, stage[0].Key)
+		}
+	}
+	return nil
+}
+
+// arrayFilters converts the request array filters into the form expected by the driver options
+func arrayFilters(filters []bson.D) []any {
+	converted := make([]any, 0, len(filters))
+	for _, filter := range filters {
+		converted = append(converted, filter)
+	}
+	return converted
+}
@@ -0,0 +1,358 @@
+package protection
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mongo-manager/types"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DefaultMaxAffectedDocuments is the threshold used when MAX_AFFECTED_DOCUMENTS is not set
+const DefaultMaxAffectedDocuments int64 = 1000
+
+// ErrConfirmationRequired is returned when a bulk write would match every document and the
+// request did not set confirmAll
+var ErrConfirmationRequired = errors.New("confirmation required")
+
+// ErrAdminRequired is returned when confirmAll is set by a caller without the admin permission
+var ErrAdminRequired = errors.New("admin permission required")
+
+// ThresholdError is returned when a write would affect more documents than the configured
+// threshold. Preview describes the documents that would have been affected.
+type ThresholdError struct {
+	Preview types.ImpactPreview
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("the operation would affect %d documents, more than the limit of %d", e.Preview.MatchedCount, e.Preview.Threshold)
+}
+
+// Config controls the guards applied to update-many and delete-many.
+// A MaxAffectedDocuments of zero disables the threshold.
+type Config struct {
+	MaxAffectedDocuments int64
+}
+
+var config = Config{MaxAffectedDocuments: DefaultMaxAffectedDocuments}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	if configured := os.Getenv("MAX_AFFECTED_DOCUMENTS"); configured != "" {
+		limit, err := strconv.ParseInt(configured, 10, 64)
+		if err != nil || limit < 0 {
+			log.Printf("Warning: ignoring invalid MAX_AFFECTED_DOCUMENTS %q, using %d", configured, DefaultMaxAffectedDocuments)
+		} else {
+			config.MaxAffectedDocuments = limit
+		}
+	}
+}
+
+// SetConfig replaces the active protection config
+func SetConfig(c Config) {
+	config = c
+}
+
+// MaxAffectedDocuments returns the active threshold, zero meaning unlimited
+func MaxAffectedDocuments() int64 {
+	return config.MaxAffectedDocuments
+}
+
+// CheckFilter refuses a match-all filter unless the request set confirmAll and the caller holds the
+// admin permission on the collection. It must run on the filter as sent by the client, before tenancy scoping adds the
+// discriminator condition that makes every filter look selective.
+func CheckFilter(filter bson.D, confirmAll bool, admin bool) error {
+	if !IsMatchAll(filter) {
+		return nil
+	}
+	if !confirmAll {
+		return fmt.Errorf("%w: the filter matches every document, set confirmAll to run it anyway", ErrConfirmationRequired)
+	}
+	if !admin {
+		return fmt.Errorf("%w: only admins may confirm an operation on every document", ErrAdminRequired)
+	}
+	return nil
+}
+
+// CheckThreshold refuses an operation whose preview exceeds the threshold. Admins that set
+// confirmAll have explicitly accepted the impact and are let through.
+func CheckThreshold(preview types.ImpactPreview, confirmAll bool, admin bool) error {
+	if config.MaxAffectedDocuments == 0 || preview.MatchedCount <= config.MaxAffectedDocuments {
+		return nil
+	}
+	if confirmAll && admin {
+		return nil
+	}
+	preview.Threshold = config.MaxAffectedDocuments
+	return &ThresholdError{Preview: preview}
+}
+
+// IsMatchAll reports whether a filter trivially matches every document: no filter at all, an
+// empty document or $comment, a truthy $expr, conditions every _id satisfies such as a truthy
+// $exists, {$ne: null} or a range starting at MinKey, {$exists: false} on any field, an $and of
+// match-all filters, an $or containing one, or a $nor of filters that match nothing.
+//
+// Only literal tautologies are recognized. Filters that select everything because of the data,
+// such as {status: {$ne: "x"}} on a collection without that status, are bounded by the
+// affected-document threshold instead.
+func IsMatchAll(filter bson.D) bool {
+	for _, elem := range filter {
+		if !isMatchAllCondition(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// isMatchNone reports whether a filter trivially matches no document, which makes its $nor or
+// $not match every document
+func isMatchNone(filter bson.D) bool {
+	for _, elem := range filter {
+		if isMatchNoneCondition(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMatchAllCondition(elem bson.E) bool {
+	switch elem.Key {
+	case "$and":
+		items, ok := documents(elem.Value)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if !IsMatchAll(item) {
+				return false
+			}
+		}
+		return true
+	case "$or":
+		items, ok := documents(elem.Value)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if IsMatchAll(item) {
+				return true
+			}
+		}
+		return false
+	case "$nor":
+		items, ok := documents(elem.Value)
+		if !ok || len(items) == 0 {
+			return false
+		}
+		for _, item := range items {
+			if !isMatchNone(item) {
+				return false
+			}
+		}
+		return true
+	case "$expr":
+		return isLiteral(elem.Value) && truthy(elem.Value)
+	case "$comment":
+		return true
+	}
+	if strings.HasPrefix(elem.Key, "$") {
+		return false
+	}
+	return fieldMatchesAll(elem.Key, elem.Value)
+}
+
+func isMatchNoneCondition(elem bson.E) bool {
+	switch elem.Key {
+	case "$and":
+		items, ok := documents(elem.Value)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if isMatchNone(item) {
+				return true
+			}
+		}
+		return false
+	case "$or":
+		items, ok := documents(elem.Value)
+		if !ok || len(items) == 0 {
+			return false
+		}
+		for _, item := range items {
+			if !isMatchNone(item) {
+				return false
+			}
+		}
+		return true
+	case "$nor":
+		items, ok := documents(elem.Value)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if IsMatchAll(item) {
+				return true
+			}
+		}
+		return false
+	case "$expr":
+		return isLiteral(elem.Value) && !truthy(elem.Value)
+	}
+	if strings.HasPrefix(elem.Key, "$") {
+		return false
+	}
+	return fieldMatchesNone(elem.Key, elem.Value)
+}
+
+// fieldMatchesAll reports whether the condition on field holds for every document. Every document
+// has an _id, which is never null, while any other field may be missing from all of them.
+func fieldMatchesAll(field string, condition interface{}) bool {
+	operators, ok := operatorDocument(condition)
+	if !ok {
+		return false
+	}
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$exists":
+			if truthy(operator.Value) != (field == "_id") {
+				return false
+			}
+		case "$ne":
+			if operator.Value != nil || field != "_id" {
+				return false
+			}
+		case "$nin":
+			values, ok := operator.Value.(bson.A)
+			if !ok {
+				return false
+			}
+			for _, value := range values {
+				if value != nil || field != "_id" {
+					return false
+				}
+			}
+		case "$gte", "$gt":
+			if _, ok := operator.Value.(bson.MinKey); !ok || (operator.Key == "$gt" && field != "_id") {
+				return false
+			}
+		case "$lte", "$lt":
+			if _, ok := operator.Value.(bson.MaxKey); !ok || (operator.Key == "$lt" && field != "_id") {
+				return false
+			}
+		case "$not":
+			if !fieldMatchesNone(field, operator.Value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fieldMatchesNone reports whether the condition on field holds for no document
+func fieldMatchesNone(field string, condition interface{}) bool {
+	operators, ok := operatorDocument(condition)
+	if !ok {
+		// Equality with null also matches a missing field, which only _id never is
+		return condition == nil && field == "_id"
+	}
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$exists":
+			if !truthy(operator.Value) && field == "_id" {
+				return true
+			}
+		case "$in":
+			if values, ok := operator.Value.(bson.A); ok && len(values) == 0 {
+				return true
+			}
+		case "$lt":
+			if _, ok := operator.Value.(bson.MinKey); ok {
+				return true
+			}
+		case "$gt":
+			if _, ok := operator.Value.(bson.MaxKey); ok {
+				return true
+			}
+		case "$not":
+			if fieldMatchesAll(field, operator.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// operatorDocument returns condition when it is a non-empty document of query operators
+func operatorDocument(condition interface{}) (bson.D, bool) {
+	operators, ok := condition.(bson.D)
+	if !ok || len(operators) == 0 || !strings.HasPrefix(operators[0].Key, "$") {
+		return nil, false
+	}
+	return operators, true
+}
+
+// isLiteral reports whether value is a constant rather than an aggregation expression
+func isLiteral(value interface{}) bool {
+	switch v := value.(type) {
+	case bson.D, bson.A:
+		return false
+	case string:
+		return !strings.HasPrefix(v, "$")
+	}
+	return true
+}
+
+// truthy applies the truthiness MongoDB uses for $exists and $expr: false, null, undefined and
+// zero are false, everything else is true
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, bson.Undefined:
+		return false
+	case bool:
+		return v
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case bson.Decimal128:
+		return !v.IsZero()
+	}
+	return true
+}
+
+func documents(value interface{}) ([]bson.D, bool) {
+	var items []interface{}
+	switch v := value.(type) {
+	case bson.A:
+		items = v
+	case []interface{}:
+		items = v
+	default:
+		return nil, false
+	}
+
+	docs := make([]bson.D, 0, len(items))
+	for _, item := range items {
+		doc, ok := item.(bson.D)
+		if !ok {
+			return nil, false
+		}
+		docs = append(docs, doc)
+	}
+	return docs, true
+}
@@ -0,0 +1,145 @@
+package protection
+
+import (
+	"errors"
+	"testing"
+
+	"mongo-manager/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// parseFilter decodes an Extended JSON filter the way request bodies are decoded
+func parseFilter(t *testing.T, filter string) bson.D {
+	t.Helper()
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(filter), false, &doc); err != nil {
+		t.Fatalf("parsing %s: %v", filter, err)
+	}
+	return doc
+}
+
+func TestIsMatchAll(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `{}`, want: true},
+		{filter: `{"$expr": true}`, want: true},
+		{filter: `{"$expr": 1}`, want: true},
+		{filter: `{"$comment": "everything"}`, want: true},
+		{filter: `{"_id": {"$exists": true}}`, want: true},
+		{filter: `{"_id": {"$exists": 1}}`, want: true},
+		{filter: `{"_id": {"$exists": {"$numberLong": "2"}}}`, want: true},
+		{filter: `{"_id": {"$ne": null}}`, want: true},
+		{filter: `{"_id": {"$nin": [null]}}`, want: true},
+		{filter: `{"x": {"$nin": []}}`, want: true},
+		{filter: `{"x": {"$exists": false}}`, want: true},
+		{filter: `{"x": {"$exists": 0}}`, want: true},
+		{filter: `{"_id": {"$gte": {"$minKey": 1}}}`, want: true},
+		{filter: `{"_id": {"$gt": {"$minKey": 1}}}`, want: true},
+		{filter: `{"x": {"$lte": {"$maxKey": 1}}}`, want: true},
+		{filter: `{"_id": {"$not": {"$exists": false}}}`, want: true},
+		{filter: `{"x": {"$not": {"$in": []}}}`, want: true},
+		{filter: `{"$nor": [{"_id": null}]}`, want: true},
+		{filter: `{"$nor": [{"_id": {"$exists": false}}, {"$expr": false}]}`, want: true},
+		{filter: `{"$nor": [{"$or": [{"x": {"$in": []}}, {"_id": {"$lt": {"$minKey": 1}}}]}]}`, want: true},
+		{filter: `{"$nor": [{"$nor": [{}]}]}`, want: true},
+		{filter: `{"$and": [{"_id": {"$exists": 1}}, {"$comment": "x"}]}`, want: true},
+		{filter: `{"$or": [{"status": "active"}, {"_id": {"$ne": null}}]}`, want: true},
+		{filter: `{"_id": {"$exists": true}, "x": {"$exists": false}}`, want: true},
+
+		{filter: `{"_id": {"$exists": false}}`, want: false},
+		{filter: `{"_id": {"$exists": 0}}`, want: false},
+		{filter: `{"x": {"$exists": true}}`, want: false},
+		{filter: `{"x": {"$ne": null}}`, want: false},
+		{filter: `{"x": {"$gt": {"$minKey": 1}}}`, want: false},
+		{filter: `{"x": {"$nin": [null]}}`, want: false},
+		{filter: `{"_id": {"$gte": 1}}`, want: false},
+		{filter: `{"_id": null}`, want: false},
+		{filter: `{"status": "active"}`, want: false},
+		{filter: `{"status": {"$ne": "archived"}}`, want: false},
+		{filter: `{"$expr": false}`, want: false},
+		{filter: `{"$expr": "$flag"}`, want: false},
+		{filter: `{"$expr": {"$eq": ["$a", "$b"]}}`, want: false},
+		{filter: `{"$nor": [{"_id": 1}]}`, want: false},
+		{filter: `{"$nor": [{"x": null}]}`, want: false},
+		{filter: `{"$or": [{"status": "active"}, {"status": "new"}]}`, want: false},
+		{filter: `{"$and": [{"_id": {"$exists": true}}, {"status": "active"}]}`, want: false},
+		{filter: `{"_id": {"$exists": true}, "status": "active"}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			if got := IsMatchAll(parseFilter(t, tt.filter)); got != tt.want {
+				t.Errorf("IsMatchAll(%s) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	if !IsMatchAll(nil) {
+		t.Errorf("IsMatchAll(nil) = false, want true")
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	matchAll := bson.D{{Key: "_id", Value: bson.D{{Key: "$exists", Value: int32(1)}}}}
+	selective := bson.D{{Key: "status", Value: "active"}}
+
+	tests := []struct {
+		name       string
+		filter     bson.D
+		confirmAll bool
+		admin      bool
+		want       error
+	}{
+		{name: "selective", filter: selective},
+		{name: "match-all", filter: matchAll, admin: true, want: ErrConfirmationRequired},
+		{name: "confirmed by admin", filter: matchAll, confirmAll: true, admin: true},
+		{name: "confirmed by non-admin", filter: matchAll, confirmAll: true, want: ErrAdminRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckFilter(tt.filter, tt.confirmAll, tt.admin)
+			if tt.want == nil && err != nil {
+				t.Fatalf("CheckFilter() error = %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("CheckFilter() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckThreshold(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	SetConfig(Config{MaxAffectedDocuments: 10})
+
+	tests := []struct {
+		name       string
+		matched    int64
+		confirmAll bool
+		admin      bool
+		wantErr    bool
+	}{
+		{name: "at the threshold", matched: 10},
+		{name: "over the threshold", matched: 11, wantErr: true},
+		{name: "confirmed by admin", matched: 11, confirmAll: true, admin: true},
+		{name: "confirmed by non-admin", matched: 11, confirmAll: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckThreshold(types.ImpactPreview{MatchedCount: tt.matched}, tt.confirmAll, tt.admin)
+			var thresholdErr *ThresholdError
+			if got := errors.As(err, &thresholdErr); got != tt.wantErr {
+				t.Fatalf("CheckThreshold() error = %v, want a threshold error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr && thresholdErr.Preview.Threshold != 10 {
+				t.Errorf("ThresholdError threshold = %d, want 10", thresholdErr.Preview.Threshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// Permission is an action a role may perform on a collection
+type Permission string
+
+const (
+	Read   Permission = "read"
+	Insert Permission = "insert"
+	Update Permission = "update"
+	Delete Permission = "delete"
+	// Admin allows collection-wide writes, emptying the trash and searching the audit log, and
+	// implies every other permission
+	Admin Permission = "admin"
+)
+
+// PerOperation marks routes whose handler checks the permission of every operation it runs, such
+// as bulk writes and transactions, instead of one permission for the whole request
+const PerOperation Permission = ""
+
+// Wildcard matches every database or collection when used as a key in a policy
+const Wildcard = "*"
+
+// PermissionError is returned when a subject lacks the permission an operation needs
+type PermissionError struct {
+	Subject    string
+	Permission Permission
+	Database   string
+	Collection string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s is missing the %q permission on %s.%s", e.Subject, e.Permission, e.Database, e.Collection)
+}
+
+// Policy maps organization roles, such as "org:admin" or custom Clerk roles, to the permissions
+// they hold
+type Policy struct {
+	Roles map[string]Role `json:"roles"`
+}
+
+// Role lists the permissions of a role per collection, keyed by database and then collection.
+// Permissions granted under a Wildcard database or collection add to those granted by name.
+type Role struct {
+	Databases map[string]map[string][]Permission `json:"databases"`
+}
+
+// DefaultPolicy applies when no policy file is found: admins may do everything and members every
+// ordinary read and write
+var DefaultPolicy = Policy{Roles: map[string]Role{
+	"org:admin":  {Databases: map[string]map[string][]Permission{Wildcard: {Wildcard: {Admin}}}},
+	"org:member": {Databases: map[string]map[string][]Permission{Wildcard: {Wildcard: {Read, Insert, Update, Delete}}}},
+}}
+
+var policy = DefaultPolicy
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	path := os.Getenv("RBAC_POLICY_PATH")
+	if path == "" {
+		path = "rbac.json"
+	}
+
+	loaded, err := LoadPolicy(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: could not load RBAC policy from %s, all permissions will be denied: %v", path, err)
+			policy = Policy{}
+		}
+		return
+	}
+	policy = loaded
+}
+
+// LoadPolicy reads an RBAC policy from a JSON file
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	var loaded Policy
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return Policy{}, err
+	}
+	return loaded, nil
+}
+
+// SetPolicy replaces the active RBAC policy
+func SetPolicy(p Policy) {
+	policy = p
+}
+
+// ValidPermission reports whether p names a permission
+func ValidPermission(p Permission) bool {
+	switch p {
+	case Read, Insert, Update, Delete, Admin:
+		return true
+	}
+	return false
+}
+
+// Subject is who permissions are checked for: an organization role resolved through the policy,
+// or an API key carrying its own grants. Name describes it in errors.
+type Subject struct {
+	Name   string
+	Grants Role
+}
+
+// ForRole returns the subject for an organization role as granted by the active policy
+func ForRole(role string) Subject {
+	return Subject{Name: fmt.Sprintf("role %q", role), Grants: policy.Roles[role]}
+}
+
+// Allowed reports whether the subject holds permission on the collection. An empty database or
+// collection, as on requests that don't target one, is only matched by Wildcard grants.
+func (s Subject) Allowed(database, collection string, permission Permission) bool {
+	databases := s.Grants.Databases
+	for _, db := range []string{database, Wildcard} {
+		collections, ok := databases[db]
+		if !ok || (db == database && database == "") {
+			continue
+		}
+		for _, coll := range []string{collection, Wildcard} {
+			if coll == collection && collection == "" {
+				continue
+			}
+			for _, granted := range collections[coll] {
+				if granted == permission || granted == Admin {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Check returns a PermissionError when the subject lacks permission on the collection
+func (s Subject) Check(database, collection string, permission Permission) error {
+	if s.Allowed(database, collection, permission) {
+		return nil
+	}
+	return &PermissionError{Subject: s.Name, Permission: permission, Database: database, Collection: collection}
+}
@@ -0,0 +1,45 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header is the HTTP header used to receive and return request IDs
+const Header = "X-Request-Id"
+
+// RequestIDKey is the context key for storing the request ID
+type RequestIDKey struct{}
+
+// validID limits client supplied IDs to a safe charset so they can be logged and echoed verbatim
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Middleware assigns every request an ID, reusing the caller's X-Request-Id when it is well formed,
+// stores it in the request context and echoes it in the response headers
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !validID.MatchString(id) {
+			id = generate()
+		}
+
+		w.Header().Set(Header, id)
+		ctx := context.WithValue(r.Context(), RequestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Get retrieves the request ID from the request context
+func Get(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDKey{}).(string)
+	return id
+}
+
+func generate() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
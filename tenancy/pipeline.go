@@ -0,0 +1,219 @@
+package tenancy
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrInvalidPipeline is returned when a pipeline stage does not have the shape MongoDB expects
+var ErrInvalidPipeline = errors.New("invalid aggregation pipeline")
+
+// ScopePipeline restricts an aggregation pipeline to the organization's documents.
+// A $match on the discriminator is prepended, and every stage that reads another collection
+// ($lookup, $graphLookup, $unionWith, including those nested in $facet) is scoped in the same way
+// once authorize accepts the collection. authorize is expected to apply Authorize along with any
+// namespace the caller must not read. Stages that write ($out, $merge) are rejected.
+func ScopePipeline(organizationID string, database string, pipeline []bson.D, authorize func(collection string) error) ([]bson.D, error) {
+	scoped := []bson.D{
+		{{Key: "$match", Value: bson.D{{Key: config.DiscriminatorField, Value: organizationID}}}},
+	}
+
+	for _, stage := range pipeline {
+		scopedStage, err := scopeStage(organizationID, database, stage, authorize)
+		if err != nil {
+			return nil, err
+		}
+		scoped = append(scoped, scopedStage)
+	}
+	return scoped, nil
+}
+
+func scopeStage(organizationID string, database string, stage bson.D, authorize func(collection string) error) (bson.D, error) {
+	scoped := bson.D{}
+	for _, elem := range stage {
+		switch elem.Key {
+		case "$out", "$merge":
+			return nil, fmt.Errorf("%w: %s stages are not allowed", ErrForbidden, elem.Key)
+
+		case "$lookup":
+			spec, err := asDocument(elem.Value, elem.Key)
+			if err != nil {
+				return nil, err
+			}
+			spec, err = scopeSubPipeline(organizationID, database, spec, "from", authorize)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = spec
+
+		case "$unionWith":
+			// {$unionWith: "coll"} is shorthand for {$unionWith: {coll: "coll"}}
+			if coll, ok := elem.Value.(string); ok {
+				elem.Value = bson.D{{Key: "coll", Value: coll}}
+			}
+			spec, err := asDocument(elem.Value, elem.Key)
+			if err != nil {
+				return nil, err
+			}
+			spec, err = scopeSubPipeline(organizationID, database, spec, "coll", authorize)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = spec
+
+		case "$graphLookup":
+			spec, err := asDocument(elem.Value, elem.Key)
+			if err != nil {
+				return nil, err
+			}
+			spec, err = scopeGraphLookup(organizationID, database, spec, authorize)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = spec
+
+		case "$facet":
+			facets, err := asDocument(elem.Value, elem.Key)
+			if err != nil {
+				return nil, err
+			}
+			scopedFacets := bson.D{}
+			for _, facet := range facets {
+				stages, err := asPipeline(facet.Value, "$facet."+facet.Key)
+				if err != nil {
+					return nil, err
+				}
+				scopedStages := bson.A{}
+				for _, nested := range stages {
+					scopedNested, err := scopeStage(organizationID, database, nested, authorize)
+					if err != nil {
+						return nil, err
+					}
+					scopedStages = append(scopedStages, scopedNested)
+				}
+				scopedFacets = append(scopedFacets, bson.E{Key: facet.Key, Value: scopedStages})
+			}
+			elem.Value = scopedFacets
+		}
+		scoped = append(scoped, elem)
+	}
+	return scoped, nil
+}
+
+// scopeSubPipeline authorizes the foreign collection named by collectionKey and prepends a
+// discriminator $match to the stage's sub-pipeline
+func scopeSubPipeline(organizationID string, database string, spec bson.D, collectionKey string, authorize func(collection string) error) (bson.D, error) {
+	foreign, _ := lookupString(spec, collectionKey)
+	if err := authorize(foreign); err != nil {
+		return nil, err
+	}
+
+	match := bson.D{{Key: "$match", Value: bson.D{{Key: config.DiscriminatorField, Value: organizationID}}}}
+	sub := bson.A{match}
+
+	scoped := bson.D{}
+	found := false
+	for _, elem := range spec {
+		if elem.Key == "pipeline" {
+			stages, err := asPipeline(elem.Value, "pipeline")
+			if err != nil {
+				return nil, err
+			}
+			for _, stage := range stages {
+				scopedStage, err := scopeStage(organizationID, database, stage, authorize)
+				if err != nil {
+					return nil, err
+				}
+				sub = append(sub, scopedStage)
+			}
+			elem.Value = sub
+			found = true
+		}
+		scoped = append(scoped, elem)
+	}
+	if !found {
+		scoped = append(scoped, bson.E{Key: "pipeline", Value: sub})
+	}
+	return scoped, nil
+}
+
+func scopeGraphLookup(organizationID string, database string, spec bson.D, authorize func(collection string) error) (bson.D, error) {
+	foreign, _ := lookupString(spec, "from")
+	if err := authorize(foreign); err != nil {
+		return nil, err
+	}
+
+	scoped := bson.D{}
+	found := false
+	for _, elem := range spec {
+		if elem.Key == "restrictSearchWithMatch" {
+			restriction, err := asDocument(elem.Value, elem.Key)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = ScopeFilter(organizationID, restriction)
+			found = true
+		}
+		scoped = append(scoped, elem)
+	}
+	if !found {
+		scoped = append(scoped, bson.E{Key: "restrictSearchWithMatch", Value: ScopeFilter(organizationID, nil)})
+	}
+	return scoped, nil
+}
+
+func lookupString(doc bson.D, key string) (string, bool) {
+	for _, elem := range doc {
+		if elem.Key == key {
+			value, ok := elem.Value.(string)
+			return value, ok
+		}
+	}
+	return "", false
+}
+
+func asDocument(value interface{}, name string) (bson.D, error) {
+	switch v := value.(type) {
+	case bson.D:
+		return v, nil
+	case bson.M:
+		doc := bson.D{}
+		for key, val := range v {
+			doc = append(doc, bson.E{Key: key, Value: val})
+		}
+		return doc, nil
+	case map[string]interface{}:
+		doc := bson.D{}
+		for key, val := range v {
+			doc = append(doc, bson.E{Key: key, Value: val})
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%w: %s must be a document", ErrInvalidPipeline, name)
+}
+
+func asPipeline(value interface{}, name string) ([]bson.D, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case bson.A:
+		items = v
+	case []interface{}:
+		items = v
+	case []bson.D:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%w: %s must be an array of stages", ErrInvalidPipeline, name)
+	}
+
+	stages := make([]bson.D, 0, len(items))
+	for _, item := range items {
+		stage, err := asDocument(item, name)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
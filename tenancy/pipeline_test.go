@@ -0,0 +1,177 @@
+package tenancy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestScopePipeline(t *testing.T) {
+	testConfig(t)
+
+	// org_a may only read billing.orders
+	authorize := func(collection string) error {
+		return Authorize("org_a", "billing", collection)
+	}
+	scope := bson.D{{Key: "$match", Value: bson.D{{Key: "organizationId", Value: "org_a"}}}}
+	stolen := bson.D{{Key: "$match", Value: bson.D{{Key: "organizationId", Value: "org_b"}}}}
+
+	tests := []struct {
+		name     string
+		pipeline []bson.D
+		want     []bson.D
+		wantErr  error
+	}{
+		{
+			name: "empty",
+			want: []bson.D{scope},
+		},
+		{
+			name:     "stages kept after the scope",
+			pipeline: []bson.D{stolen, {{Key: "$limit", Value: 5}}},
+			want:     []bson.D{scope, stolen, {{Key: "$limit", Value: 5}}},
+		},
+		{
+			name: "$lookup with fields",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "localField", Value: "orderId"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "order"},
+			}}}},
+			want: []bson.D{scope, {{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "localField", Value: "orderId"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "order"},
+				{Key: "pipeline", Value: bson.A{scope}},
+			}}}},
+		},
+		{
+			name: "cross-tenant $lookup",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "pipeline", Value: bson.A{stolen}}, {Key: "as", Value: "order"},
+			}}}},
+			want: []bson.D{scope, {{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "pipeline", Value: bson.A{scope, stolen}}, {Key: "as", Value: "order"},
+			}}}},
+		},
+		{
+			name:     "cross-tenant $unionWith shorthand",
+			pipeline: []bson.D{{{Key: "$unionWith", Value: "orders"}}},
+			want: []bson.D{scope, {{Key: "$unionWith", Value: bson.D{
+				{Key: "coll", Value: "orders"}, {Key: "pipeline", Value: bson.A{scope}},
+			}}}},
+		},
+		{
+			name: "cross-tenant $unionWith",
+			pipeline: []bson.D{{{Key: "$unionWith", Value: bson.D{
+				{Key: "coll", Value: "orders"}, {Key: "pipeline", Value: bson.A{stolen}},
+			}}}},
+			want: []bson.D{scope, {{Key: "$unionWith", Value: bson.D{
+				{Key: "coll", Value: "orders"}, {Key: "pipeline", Value: bson.A{scope, stolen}},
+			}}}},
+		},
+		{
+			name: "$unionWith nested in a $lookup",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$unionWith", Value: "orders"}}}}, {Key: "as", Value: "order"},
+			}}}},
+			want: []bson.D{scope, {{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "pipeline", Value: bson.A{scope, bson.D{{Key: "$unionWith", Value: bson.D{
+					{Key: "coll", Value: "orders"}, {Key: "pipeline", Value: bson.A{scope}},
+				}}}}},
+				{Key: "as", Value: "order"},
+			}}}},
+		},
+		{
+			name: "$lookup in a $facet",
+			pipeline: []bson.D{{{Key: "$facet", Value: bson.D{
+				{Key: "orders", Value: bson.A{bson.D{{Key: "$unionWith", Value: "orders"}}}},
+			}}}},
+			want: []bson.D{scope, {{Key: "$facet", Value: bson.D{
+				{Key: "orders", Value: bson.A{bson.D{{Key: "$unionWith", Value: bson.D{
+					{Key: "coll", Value: "orders"}, {Key: "pipeline", Value: bson.A{scope}},
+				}}}}},
+			}}}},
+		},
+		{
+			name: "$graphLookup",
+			pipeline: []bson.D{{{Key: "$graphLookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "startWith", Value: "$parent"}, {Key: "connectFromField", Value: "parent"}, {Key: "connectToField", Value: "_id"}, {Key: "as", Value: "tree"},
+			}}}},
+			want: []bson.D{scope, {{Key: "$graphLookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "startWith", Value: "$parent"}, {Key: "connectFromField", Value: "parent"}, {Key: "connectToField", Value: "_id"}, {Key: "as", Value: "tree"},
+				{Key: "restrictSearchWithMatch", Value: bson.D{{Key: "organizationId", Value: "org_a"}}},
+			}}}},
+		},
+		{
+			name: "cross-tenant $graphLookup",
+			pipeline: []bson.D{{{Key: "$graphLookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "restrictSearchWithMatch", Value: bson.D{{Key: "organizationId", Value: "org_b"}, {Key: "open", Value: true}}},
+			}}}},
+			want: []bson.D{scope, {{Key: "$graphLookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "restrictSearchWithMatch", Value: bson.D{{Key: "open", Value: true}, {Key: "organizationId", Value: "org_a"}}},
+			}}}},
+		},
+		{
+			name:     "$lookup of a forbidden collection",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "invoices"}, {Key: "as", Value: "invoice"}}}}},
+			wantErr:  ErrForbidden,
+		},
+		{
+			name:     "$unionWith of a forbidden collection",
+			pipeline: []bson.D{{{Key: "$unionWith", Value: "invoices"}}},
+			wantErr:  ErrForbidden,
+		},
+		{
+			name:     "$graphLookup of a forbidden collection",
+			pipeline: []bson.D{{{Key: "$graphLookup", Value: bson.D{{Key: "from", Value: "invoices"}}}}},
+			wantErr:  ErrForbidden,
+		},
+		{
+			name: "forbidden collection nested in a $facet",
+			pipeline: []bson.D{{{Key: "$facet", Value: bson.D{
+				{Key: "a", Value: bson.A{bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "invoices"}, {Key: "as", Value: "x"}}}}}},
+			}}}},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:     "$out",
+			pipeline: []bson.D{{{Key: "$out", Value: "copy"}}},
+			wantErr:  ErrForbidden,
+		},
+		{
+			name: "$merge nested in a $lookup",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$merge", Value: "copy"}}}},
+			}}}},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:     "$lookup that isn't a document",
+			pipeline: []bson.D{{{Key: "$lookup", Value: "orders"}}},
+			wantErr:  ErrInvalidPipeline,
+		},
+		{
+			name:     "$lookup pipeline that isn't an array",
+			pipeline: []bson.D{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "orders"}, {Key: "pipeline", Value: "x"}}}}},
+			wantErr:  ErrInvalidPipeline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ScopePipeline("org_a", "billing", tt.pipeline, authorize)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ScopePipeline() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ScopePipeline() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScopePipeline() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package tenancy
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Wildcard grants access to every database or collection when used as a key or entry in the config
+const Wildcard = "*"
+
+// DefaultDiscriminatorField is the document field used to tag documents with their owning organization
+const DefaultDiscriminatorField = "organizationId"
+
+// ErrForbidden is returned when an organization tries to access a database or collection it does not own
+var ErrForbidden = errors.New("organization is not allowed to access this database or collection")
+
+// Config maps organizations to the databases and collections they are allowed to access
+type Config struct {
+	DiscriminatorField string                  `json:"discriminatorField"`
+	Organizations      map[string]Organization `json:"organizations"`
+}
+
+// Organization lists the collections an organization may access, keyed by database name
+type Organization struct {
+	Databases map[string][]string `json:"databases"`
+}
+
+var config = Config{DiscriminatorField: DefaultDiscriminatorField}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+
+	path := os.Getenv("TENANCY_CONFIG_PATH")
+	if path == "" {
+		path = "tenancy.json"
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		log.Printf("Warning: could not load tenancy config from %s, all tenant access will be denied: %v", path, err)
+		return
+	}
+	config = loaded
+}
+
+// LoadConfig reads a tenancy config from a JSON file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var loaded Config
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return Config{}, err
+	}
+	if loaded.DiscriminatorField == "" {
+		loaded.DiscriminatorField = DefaultDiscriminatorField
+	}
+	return loaded, nil
+}
+
+// SetConfig replaces the active tenancy config
+func SetConfig(c Config) {
+	if c.DiscriminatorField == "" {
+		c.DiscriminatorField = DefaultDiscriminatorField
+	}
+	config = c
+}
+
+// DiscriminatorField returns the field that stores the owning organization ID on every document
+func DiscriminatorField() string {
+	return config.DiscriminatorField
+}
+
+// Authorize checks whether the organization may access the given database and collection.
+// Unknown organizations are denied by default.
+func Authorize(organizationID, database, collection string) error {
+	if organizationID == "" || database == "" || collection == "" {
+		return ErrForbidden
+	}
+
+	org, ok := config.Organizations[organizationID]
+	if !ok {
+		return ErrForbidden
+	}
+
+	collections, ok := org.Databases[database]
+	if !ok {
+		collections, ok = org.Databases[Wildcard]
+	}
+	if !ok {
+		return ErrForbidden
+	}
+
+	for _, allowed := range collections {
+		if allowed == Wildcard || allowed == collection {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+// ScopeFilter restricts a filter to the documents owned by the organization.
+// Any top-level discriminator condition supplied by the client is replaced, and because
+// top-level conditions are combined with AND the client cannot widen the scope through
+// nested operators such as $or.
+func ScopeFilter(organizationID string, filter bson.D) bson.D {
+	scoped := bson.D{}
+	for _, elem := range filter {
+		if elem.Key == config.DiscriminatorField {
+			continue
+		}
+		scoped = append(scoped, elem)
+	}
+	return append(scoped, bson.E{Key: config.DiscriminatorField, Value: organizationID})
+}
+
+// StampDocument tags a document with the owning organization, overwriting any client supplied value
+func StampDocument(organizationID string, doc map[string]interface{}) {
+	if doc == nil {
+		return
+	}
+	doc[config.DiscriminatorField] = organizationID
+}
+
+// StripDocument removes the discriminator from update data so documents cannot be moved to another tenant
+func StripDocument(doc map[string]interface{}) {
+	delete(doc, config.DiscriminatorField)
+}
@@ -0,0 +1,192 @@
+package tenancy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setConfig replaces the tenancy config for the duration of the test
+func setConfig(t *testing.T, c Config) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	SetConfig(c)
+}
+
+// testConfig lets org_a read every collection of shop and the orders of billing, and org_b
+// everything in every database
+func testConfig(t *testing.T) {
+	setConfig(t, Config{Organizations: map[string]Organization{
+		"org_a": {Databases: map[string][]string{"shop": {Wildcard}, "billing": {"orders"}}},
+		"org_b": {Databases: map[string][]string{Wildcard: {Wildcard}}},
+	}})
+}
+
+func TestAuthorize(t *testing.T) {
+	testConfig(t)
+
+	tests := []struct {
+		name         string
+		organization string
+		database     string
+		collection   string
+		wantErr      bool
+	}{
+		{name: "collection wildcard", organization: "org_a", database: "shop", collection: "products"},
+		{name: "listed collection", organization: "org_a", database: "billing", collection: "orders"},
+		{name: "unlisted collection", organization: "org_a", database: "billing", collection: "invoices", wantErr: true},
+		{name: "unlisted database", organization: "org_a", database: "admin", collection: "users", wantErr: true},
+		{name: "database wildcard", organization: "org_b", database: "admin", collection: "users"},
+		{name: "unknown organization", organization: "org_c", database: "shop", collection: "products", wantErr: true},
+		{name: "no organization", database: "shop", collection: "products", wantErr: true},
+		{name: "no collection", organization: "org_b", database: "shop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.organization, tt.database, tt.collection)
+			if tt.wantErr && !errors.Is(err, ErrForbidden) {
+				t.Errorf("Authorize() error = %v, want ErrForbidden", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Authorize() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestScopeFilter(t *testing.T) {
+	testConfig(t)
+
+	tests := []struct {
+		name   string
+		filter bson.D
+		want   bson.D
+	}{
+		{
+			name: "no filter",
+			want: bson.D{{Key: "organizationId", Value: "org_a"}},
+		},
+		{
+			name:   "conditions kept",
+			filter: bson.D{{Key: "status", Value: "active"}},
+			want:   bson.D{{Key: "status", Value: "active"}, {Key: "organizationId", Value: "org_a"}},
+		},
+		{
+			name:   "overridden organization",
+			filter: bson.D{{Key: "organizationId", Value: "org_b"}, {Key: "status", Value: "active"}},
+			want:   bson.D{{Key: "status", Value: "active"}, {Key: "organizationId", Value: "org_a"}},
+		},
+		{
+			name:   "organization condition with operators",
+			filter: bson.D{{Key: "organizationId", Value: bson.D{{Key: "$in", Value: bson.A{"org_a", "org_b"}}}}},
+			want:   bson.D{{Key: "organizationId", Value: "org_a"}},
+		},
+		{
+			// Nested conditions are ANDed with the scope, so they can only narrow it
+			name:   "organization inside $or",
+			filter: bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "organizationId", Value: "org_b"}}, bson.D{{Key: "public", Value: true}}}}},
+			want: bson.D{
+				{Key: "$or", Value: bson.A{bson.D{{Key: "organizationId", Value: "org_b"}}, bson.D{{Key: "public", Value: true}}}},
+				{Key: "organizationId", Value: "org_a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScopeFilter("org_a", tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScopeFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeFilterCustomDiscriminator(t *testing.T) {
+	setConfig(t, Config{DiscriminatorField: "tenant"})
+
+	filter := bson.D{{Key: "tenant", Value: "org_b"}, {Key: "organizationId", Value: "org_b"}}
+	want := bson.D{{Key: "organizationId", Value: "org_b"}, {Key: "tenant", Value: "org_a"}}
+	if got := ScopeFilter("org_a", filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScopeFilter() = %v, want %v", got, want)
+	}
+}
+
+func TestStampAndStripDocument(t *testing.T) {
+	testConfig(t)
+
+	doc := map[string]interface{}{"name": "a", "organizationId": "org_b"}
+	StampDocument("org_a", doc)
+	if doc["organizationId"] != "org_a" {
+		t.Errorf("StampDocument() left organizationId = %v, want org_a", doc["organizationId"])
+	}
+
+	StripDocument(doc)
+	if _, ok := doc["organizationId"]; ok || doc["name"] != "a" {
+		t.Errorf("StripDocument() = %v, want only name", doc)
+	}
+
+	StampDocument("org_a", nil)
+}
+
+func TestScopeUpdate(t *testing.T) {
+	testConfig(t)
+
+	restamp := bson.D{{Key: "$set", Value: bson.D{{Key: "organizationId", Value: "org_a"}}}}
+
+	tests := []struct {
+		name   string
+		update interface{}
+		want   interface{}
+	}{
+		{
+			name:   "unrelated fields",
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "done"}}}},
+			want:   bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "done"}}}},
+		},
+		{
+			name: "organization set",
+			update: bson.D{
+				{Key: "$set", Value: bson.D{{Key: "organizationId", Value: "org_b"}, {Key: "status", Value: "done"}}},
+				{Key: "$unset", Value: bson.D{{Key: "organizationId", Value: ""}}},
+			},
+			want: bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "done"}}}},
+		},
+		{
+			name:   "organization subfield",
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "organizationId.x", Value: 1}}}},
+			want:   bson.D{},
+		},
+		{
+			name:   "similar field name",
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "organizationIdOld", Value: "org_b"}}}},
+			want:   bson.D{{Key: "$set", Value: bson.D{{Key: "organizationIdOld", Value: "org_b"}}}},
+		},
+		{
+			name:   "renamed onto the organization",
+			update: bson.D{{Key: "$rename", Value: bson.D{{Key: "owner", Value: "organizationId"}, {Key: "a", Value: "b"}}}},
+			want:   bson.D{{Key: "$rename", Value: bson.D{{Key: "a", Value: "b"}}}},
+		},
+		{
+			name:   "renamed away from the organization",
+			update: bson.D{{Key: "$rename", Value: bson.D{{Key: "organizationId", Value: "owner"}}}},
+			want:   bson.D{},
+		},
+		{
+			name:   "pipeline",
+			update: bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "organizationId", Value: "org_b"}}}}},
+			want:   bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "organizationId", Value: "org_b"}}}}, restamp},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScopeUpdate("org_a", tt.update); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScopeUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package tenancy
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ScopeUpdate prevents an update from moving documents to another tenant.
+// Operator updates have every reference to the discriminator removed, including $rename targets,
+// and pipeline updates get a final stage that re-stamps the discriminator with the caller's organization.
+func ScopeUpdate(organizationID string, update interface{}) interface{} {
+	switch u := update.(type) {
+	case bson.D:
+		return scopeUpdateDocument(u)
+	case bson.A:
+		return append(u, bson.D{{Key: "$set", Value: bson.D{{Key: config.DiscriminatorField, Value: organizationID}}}})
+	case []interface{}:
+		return append(bson.A(u), bson.D{{Key: "$set", Value: bson.D{{Key: config.DiscriminatorField, Value: organizationID}}}})
+	}
+	return update
+}
+
+func scopeUpdateDocument(update bson.D) bson.D {
+	scoped := bson.D{}
+	for _, operator := range update {
+		fields, ok := operator.Value.(bson.D)
+		if !ok {
+			scoped = append(scoped, operator)
+			continue
+		}
+
+		kept := bson.D{}
+		for _, field := range fields {
+			if touchesDiscriminator(field.Key) {
+				continue
+			}
+			if target, ok := field.Value.(string); ok && operator.Key == "$rename" && touchesDiscriminator(target) {
+				continue
+			}
+			kept = append(kept, field)
+		}
+
+		if len(kept) > 0 {
+			scoped = append(scoped, bson.E{Key: operator.Key, Value: kept})
+		}
+	}
+	return scoped
+}
+
+func touchesDiscriminator(path string) bool {
+	return path == config.DiscriminatorField || strings.HasPrefix(path, config.DiscriminatorField+".")
+}
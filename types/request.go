@@ -1,11 +1,21 @@
 package types
 
-import "go.mongodb.org/mongo-driver/v2/bson"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
 
 type Request struct {
-	Database   string `json:"database"`
-	Collection string `json:"collection"`
-	Filter     bson.D `json:"filter,omitempty"`
+	Database     string `json:"database"`
+	Collection   string `json:"collection"`
+	Filter       bson.D `json:"filter,omitempty"`
+	Sort         bson.D `json:"sort,omitempty"`
+	Projection   bson.D `json:"projection,omitempty"`
+	Limit        int64  `json:"limit,omitempty"`
+	Skip         int64  `json:"skip,omitempty"`
+	Cursor       string `json:"cursor,omitempty"`
+	IncludeTotal bool   `json:"includeTotal,omitempty"`
 }
 
 type InsertOneRequest struct {
@@ -20,28 +30,239 @@ type InsertManyRequest struct {
 	Data       []map[string]interface{} `json:"data"`
 }
 
+// UpdateOneRequest updates a single document by ID.
+// Data is shorthand for {$set: data}; Update takes a full update document or an aggregation pipeline instead.
 type UpdateOneRequest struct {
-	Database   string                 `json:"database"`
-	Collection string                 `json:"collection"`
-	ObjectId   string                 `json:"objectId"`
-	Data       map[string]interface{} `json:"data"`
+	Database     string                 `json:"database"`
+	Collection   string                 `json:"collection"`
+	ObjectId     string                 `json:"objectId"`
+	Data         map[string]interface{} `json:"data"`
+	Update       interface{}            `json:"update,omitempty"`
+	ArrayFilters []bson.D               `json:"arrayFilters,omitempty"`
+	Upsert       bool                   `json:"upsert,omitempty"`
+	Collation    *Collation             `json:"collation,omitempty"`
+	// Scope holds extra conditions, such as the tenant discriminator, merged with the _id match
+	Scope bson.D `json:"-"`
+	// DryRun previews the update without writing, set from the dryRun query parameter
+	DryRun bool `json:"-"`
+	// Actor is the user performing the update, recorded in revision history
+	Actor string `json:"-"`
 }
 
+// UpdateManyRequest updates every document matching Filter.
+// Data is shorthand for {$set: data}; Update takes a full update document or an aggregation pipeline instead.
 type UpdateManyRequest struct {
-	Database   string                 `json:"database"`
-	Collection string                 `json:"collection"`
-	Filter     bson.D                 `json:"filter,omitempty"`
-	Data       map[string]interface{} `json:"data"`
+	Database     string                 `json:"database"`
+	Collection   string                 `json:"collection"`
+	Filter       bson.D                 `json:"filter,omitempty"`
+	Data         map[string]interface{} `json:"data"`
+	Update       interface{}            `json:"update,omitempty"`
+	ArrayFilters []bson.D               `json:"arrayFilters,omitempty"`
+	Upsert       bool                   `json:"upsert,omitempty"`
+	Collation    *Collation             `json:"collation,omitempty"`
+	// ConfirmAll acknowledges that the filter matches every document or more than the configured threshold
+	ConfirmAll bool `json:"confirmAll,omitempty"`
+	// DryRun previews the update without writing, set from the dryRun query parameter
+	DryRun bool `json:"-"`
+	// Actor is the user performing the update, recorded in revision history
+	Actor string `json:"-"`
 }
 
 type DeleteOneRequest struct {
 	Database   string `json:"database"`
 	Collection string `json:"collection"`
 	ObjectId   string `json:"objectId"`
+	// Scope holds extra conditions, such as the tenant discriminator, merged with the _id match
+	Scope bson.D `json:"-"`
+	// DryRun previews the delete without writing, set from the dryRun query parameter
+	DryRun bool `json:"-"`
+	// Actor is the user performing the delete, recorded on soft-deleted documents and in revision history
+	Actor string `json:"-"`
 }
 
 type DeleteManyRequest struct {
 	Database   string `json:"database"`
 	Collection string `json:"collection"`
 	Filter     bson.D `json:"filter,omitempty"`
+	// ConfirmAll acknowledges that the filter matches every document or more than the configured threshold
+	ConfirmAll bool `json:"confirmAll,omitempty"`
+	// DryRun previews the delete without writing, set from the dryRun query parameter
+	DryRun bool `json:"-"`
+	// Actor is the user performing the delete, recorded on soft-deleted documents and in revision history
+	Actor string `json:"-"`
+}
+
+// TrashRequest addresses the trash of a soft-delete collection. ObjectId selects the trashed
+// versions of one document by its original _id; without it the whole trash is addressed.
+type TrashRequest struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	ObjectId   string `json:"objectId"`
+	// ConfirmAll acknowledges purging the whole trash when no objectId is given
+	ConfirmAll bool `json:"confirmAll,omitempty"`
+	// Scope holds extra conditions, such as the tenant discriminator, applied to the trash entries
+	Scope bson.D `json:"-"`
+	// OrganizationID is stamped onto restored documents
+	OrganizationID string `json:"-"`
+}
+
+// Collation mirrors MongoDB's collation document
+type Collation struct {
+	Locale          string `json:"locale"`
+	CaseLevel       bool   `json:"caseLevel,omitempty"`
+	CaseFirst       string `json:"caseFirst,omitempty"`
+	Strength        int    `json:"strength,omitempty"`
+	NumericOrdering bool   `json:"numericOrdering,omitempty"`
+	Alternate       string `json:"alternate,omitempty"`
+	MaxVariable     string `json:"maxVariable,omitempty"`
+	Normalization   bool   `json:"normalization,omitempty"`
+	Backwards       bool   `json:"backwards,omitempty"`
+}
+
+type AggregateRequest struct {
+	Database     string      `json:"database"`
+	Collection   string      `json:"collection"`
+	Pipeline     []bson.D    `json:"pipeline"`
+	AllowDiskUse bool        `json:"allowDiskUse,omitempty"`
+	MaxTimeMS    int64       `json:"maxTimeMS,omitempty"`
+	Collation    *Collation  `json:"collation,omitempty"`
+	Hint         interface{} `json:"hint,omitempty"`
+}
+
+// FindOneAndUpdateRequest atomically updates the first matching document and returns it.
+// ReturnDocument is "before" (the default) or "after".
+type FindOneAndUpdateRequest struct {
+	Database       string                 `json:"database"`
+	Collection     string                 `json:"collection"`
+	Filter         bson.D                 `json:"filter,omitempty"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+	Update         interface{}            `json:"update,omitempty"`
+	ArrayFilters   []bson.D               `json:"arrayFilters,omitempty"`
+	Sort           bson.D                 `json:"sort,omitempty"`
+	Projection     bson.D                 `json:"projection,omitempty"`
+	Upsert         bool                   `json:"upsert,omitempty"`
+	ReturnDocument string                 `json:"returnDocument,omitempty"`
+	Collation      *Collation             `json:"collation,omitempty"`
+	// Actor is the user performing the update, recorded in revision history
+	Actor string `json:"-"`
+}
+
+// FindOneAndReplaceRequest atomically replaces the first matching document and returns it.
+// ReturnDocument is "before" (the default) or "after".
+type FindOneAndReplaceRequest struct {
+	Database       string                 `json:"database"`
+	Collection     string                 `json:"collection"`
+	Filter         bson.D                 `json:"filter,omitempty"`
+	Replacement    map[string]interface{} `json:"replacement"`
+	Sort           bson.D                 `json:"sort,omitempty"`
+	Projection     bson.D                 `json:"projection,omitempty"`
+	Upsert         bool                   `json:"upsert,omitempty"`
+	ReturnDocument string                 `json:"returnDocument,omitempty"`
+	Collation      *Collation             `json:"collation,omitempty"`
+	// Actor is the user performing the replacement, recorded in revision history
+	Actor string `json:"-"`
+}
+
+// FindOneAndDeleteRequest atomically deletes the first matching document and returns it
+type FindOneAndDeleteRequest struct {
+	Database   string     `json:"database"`
+	Collection string     `json:"collection"`
+	Filter     bson.D     `json:"filter,omitempty"`
+	Sort       bson.D     `json:"sort,omitempty"`
+	Projection bson.D     `json:"projection,omitempty"`
+	Collation  *Collation `json:"collation,omitempty"`
+	// Actor is the user performing the delete, recorded on soft-deleted documents and in revision history
+	Actor string `json:"-"`
+}
+
+type ReplaceOneRequest struct {
+	Database    string                 `json:"database"`
+	Collection  string                 `json:"collection"`
+	Filter      bson.D                 `json:"filter,omitempty"`
+	Replacement map[string]interface{} `json:"replacement"`
+	Upsert      bool                   `json:"upsert,omitempty"`
+	Collation   *Collation             `json:"collation,omitempty"`
+	// Actor is the user performing the replacement, recorded in revision history
+	Actor string `json:"-"`
+}
+
+// RevertRequest restores the document ObjectId to the version recorded in Revision
+type RevertRequest struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	ObjectId   string `json:"objectId"`
+	Revision   int64  `json:"revision"`
+	// Scope holds extra conditions, such as the tenant discriminator, applied to the document and its history
+	Scope bson.D `json:"-"`
+	// Actor is the user performing the revert, recorded in revision history
+	Actor string `json:"-"`
+	// OrganizationID is stamped onto the reverted document
+	OrganizationID string `json:"-"`
+	// AuthorizeRecreate is called before a deleted document is recreated, which inserts it; its
+	// error aborts the revert
+	AuthorizeRecreate func() error `json:"-"`
+}
+
+// IssueAPIKeyRequest issues an API key for the caller's organization. Databases grants permissions
+// per database and collection in the same shape as an RBAC policy role, and a nil ExpiresAt makes
+// a key that never expires.
+type IssueAPIKeyRequest struct {
+	Name      string                         `json:"name"`
+	Databases map[string]map[string][]string `json:"databases"`
+	ExpiresAt *time.Time                     `json:"expiresAt,omitempty"`
+}
+
+// AuditQueryRequest searches the audit log. Empty criteria match every record, and the time range
+// includes From and excludes To.
+type AuditQueryRequest struct {
+	UserID         string     `json:"userId"`
+	OrganizationID string     `json:"organizationId"`
+	Database       string     `json:"database"`
+	Collection     string     `json:"collection"`
+	From           *time.Time `json:"from"`
+	To             *time.Time `json:"to"`
+	Limit          int64      `json:"limit"`
+	Cursor         string     `json:"cursor"`
+}
+
+// WriteOperation is a single write used by transactions and bulk writes.
+// Type is one of insertOne, updateOne, updateMany, replaceOne, deleteOne, deleteMany or findOneAndUpdate.
+// Document is the document to insert, Replacement the document for replaceOne, and Update
+// an update document or pipeline for the update types.
+type WriteOperation struct {
+	Type           string                 `json:"type"`
+	Collection     string                 `json:"collection,omitempty"`
+	Filter         bson.D                 `json:"filter,omitempty"`
+	Document       map[string]interface{} `json:"document,omitempty"`
+	Replacement    map[string]interface{} `json:"replacement,omitempty"`
+	Update         interface{}            `json:"update,omitempty"`
+	ArrayFilters   []bson.D               `json:"arrayFilters,omitempty"`
+	Sort           bson.D                 `json:"sort,omitempty"`
+	Projection     bson.D                 `json:"projection,omitempty"`
+	Upsert         bool                   `json:"upsert,omitempty"`
+	ReturnDocument string                 `json:"returnDocument,omitempty"`
+	Collation      *Collation             `json:"collation,omitempty"`
+	// ConfirmAll acknowledges that an updateMany or deleteMany filter matches every document
+	ConfirmAll bool `json:"confirmAll,omitempty"`
+}
+
+// TransactionRequest runs Operations in order inside a single multi-document transaction.
+// Each operation names its own collection, all within Database.
+type TransactionRequest struct {
+	Database   string           `json:"database"`
+	Operations []WriteOperation `json:"operations"`
+	// Actor is the user running the transaction, recorded on soft-deleted documents
+	Actor string `json:"-"`
+}
+
+// BulkWriteRequest runs mixed write operations against one collection.
+// Ordered defaults to true: execution stops at the first error. When false every operation is
+// attempted and all failures are reported.
+type BulkWriteRequest struct {
+	Database   string           `json:"database"`
+	Collection string           `json:"collection"`
+	Ordered    *bool            `json:"ordered,omitempty"`
+	Operations []WriteOperation `json:"operations"`
+	// DryRun previews every operation without writing, set from the dryRun query parameter
+	DryRun bool `json:"-"`
 }
@@ -0,0 +1,109 @@
+package types
+
+import "go.mongodb.org/mongo-driver/v2/bson"
+
+// Page is the envelope returned by paginated reads.
+// NextCursor is nil once the last page has been reached, and Total is only set when requested.
+type Page struct {
+	Items      []bson.M `json:"items"`
+	NextCursor *string  `json:"nextCursor"`
+	Total      *int64   `json:"total,omitempty"`
+}
+
+// DocumentResult wraps the document returned by find-and-modify operations.
+// Document is nil when no document matched.
+type DocumentResult struct {
+	Document bson.M `json:"document"`
+}
+
+// ImpactPreview describes the documents a write would affect without performing it.
+// SampleIDs holds the _id of the first few matching documents, and Threshold is set when the
+// preview is returned because the operation exceeded the affected-documents limit.
+type ImpactPreview struct {
+	MatchedCount int64         `json:"matchedCount"`
+	SampleIDs    []interface{} `json:"sampleIds"`
+	Threshold    int64         `json:"threshold,omitempty"`
+}
+
+// FieldChange is one field that differs between two versions of a document.
+// Before is omitted for fields that would be added and After for fields that would be removed.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// DocumentChange lists how a single document would change
+type DocumentChange struct {
+	ID     interface{}   `json:"_id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// DryRunResult previews a write without performing it. SampleIDs holds the _id of the first few
+// affected documents and, for updates and replacements, Changes shows how each sampled document
+// would change. WouldUpsert is set when nothing matched and the write would insert a document.
+// Approximate is set when the write may not do exactly what the preview shows: update changes are
+// simulated with an aggregation, and a single-document write matching several documents may pick
+// another one than the sample.
+type DryRunResult struct {
+	DryRun       bool             `json:"dryRun"`
+	Type         string           `json:"type"`
+	MatchedCount int64            `json:"matchedCount"`
+	SampleIDs    []interface{}    `json:"sampleIds"`
+	Changes      []DocumentChange `json:"changes,omitempty"`
+	WouldUpsert  bool             `json:"wouldUpsert,omitempty"`
+	Approximate  bool             `json:"approximate,omitempty"`
+}
+
+// BulkDryRunReport previews every operation of a bulk write, in request order
+type BulkDryRunReport struct {
+	DryRun     bool           `json:"dryRun"`
+	Operations []DryRunResult `json:"operations"`
+}
+
+// WriteOperationResult is the outcome of a single transaction step
+type WriteOperationResult struct {
+	Index         int         `json:"index"`
+	Type          string      `json:"type"`
+	Collection    string      `json:"collection"`
+	InsertedID    interface{} `json:"insertedId,omitempty"`
+	MatchedCount  int64       `json:"matchedCount"`
+	ModifiedCount int64       `json:"modifiedCount"`
+	DeletedCount  int64       `json:"deletedCount"`
+	UpsertedID    interface{} `json:"upsertedId,omitempty"`
+	Document      bson.M      `json:"document,omitempty"`
+}
+
+// TransactionResult reports the per-step results of a committed transaction
+type TransactionResult struct {
+	Committed bool                   `json:"committed"`
+	Steps     []WriteOperationResult `json:"steps"`
+}
+
+// IndexedID associates a document ID with the index of the operation that produced it
+type IndexedID struct {
+	Index int         `json:"index"`
+	ID    interface{} `json:"id"`
+}
+
+// BulkWriteError describes a failed operation of a bulk write
+type BulkWriteError struct {
+	Index   int    `json:"index"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BulkWriteReport summarizes a bulk write. InsertedIDs and UpsertedIDs are keyed by operation
+// index, and WriteErrors lists every failed operation so clients can retry only those rows.
+type BulkWriteReport struct {
+	Ordered           bool             `json:"ordered"`
+	InsertedCount     int64            `json:"insertedCount"`
+	MatchedCount      int64            `json:"matchedCount"`
+	ModifiedCount     int64            `json:"modifiedCount"`
+	DeletedCount      int64            `json:"deletedCount"`
+	UpsertedCount     int64            `json:"upsertedCount"`
+	InsertedIDs       []IndexedID      `json:"insertedIds"`
+	UpsertedIDs       []IndexedID      `json:"upsertedIds"`
+	WriteErrors       []BulkWriteError `json:"writeErrors"`
+	WriteConcernError string           `json:"writeConcernError,omitempty"`
+}
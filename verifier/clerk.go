@@ -0,0 +1,66 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+	clerkjwt "github.com/clerk/clerk-sdk-go/v2/jwt"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// Clerk verifies Clerk session tokens. Signature, lifetime and the Clerk issuer format are checked
+// by the Clerk SDK with the keys of the key set; the configured Options are checked on top.
+type Clerk struct {
+	keys    *KeySet
+	options Options
+}
+
+// NewClerk returns a verifier for Clerk session tokens signed by keys
+func NewClerk(keys *KeySet, options Options) *Clerk {
+	return &Clerk{keys: keys, options: options}
+}
+
+// Verify checks the token and returns its claims. OrganizationID is the session's active
+// organization; the role is left to the membership lookup.
+func (v *Clerk) Verify(ctx context.Context, token string) (*Claims, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, fmt.Errorf("%w: expected one signature", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, parsed.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	custom := map[string]interface{}{}
+	session, err := clerkjwt.Verify(ctx, &clerkjwt.VerifyParams{
+		Token:                   token,
+		JWK:                     &clerk.JSONWebKey{Key: key.Key, KeyID: key.KeyID, Algorithm: key.Algorithm, Use: key.Use},
+		Leeway:                  v.options.Leeway,
+		CustomClaimsConstructor: func(context.Context) any { return &custom },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if v.options.Issuer != "" && session.Issuer != v.options.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q is not accepted", ErrInvalidToken, session.Issuer)
+	}
+
+	claims := &Claims{
+		Subject:         session.Subject,
+		Issuer:          session.Issuer,
+		Audience:        session.Audience,
+		AuthorizedParty: session.AuthorizedParty,
+		OrganizationID:  session.ActiveOrganizationID,
+		Custom:          custom,
+	}
+	if err := v.options.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,183 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+	"github.com/clerk/clerk-sdk-go/v2/jwks"
+	"github.com/go-jose/go-jose/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultKeySetTTL is how long fetched signing keys are kept when JWKS_CACHE_TTL is not set
+const DefaultKeySetTTL = time.Hour
+
+// MinRefreshInterval limits how often a token with an unknown key ID can make a key set refetch
+// its keys, so forged key IDs can't be used to flood the provider
+const MinRefreshInterval = 30 * time.Second
+
+// FetchTimeout bounds a single key set or discovery request
+const FetchTimeout = 10 * time.Second
+
+// ErrUnknownKey is returned when no signing key matches a token's key ID, even after a refetch
+var ErrUnknownKey = errors.New("unknown signing key")
+
+var httpClient = &http.Client{Timeout: FetchTimeout}
+
+// KeySet caches the signing keys of a provider. Keys are refetched once they are older than the
+// TTL, and early when a token names a key ID that isn't cached, which is how rotated keys are
+// picked up. When a refetch fails the keys already cached keep being used.
+type KeySet struct {
+	fetch func(ctx context.Context) ([]jose.JSONWebKey, error)
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	keys      []jose.JSONWebKey
+	fetchedAt time.Time
+	group     singleflight.Group
+}
+
+// NewKeySet returns a key set that gets its keys from fetch
+func NewKeySet(fetch func(ctx context.Context) ([]jose.JSONWebKey, error), ttl time.Duration) *KeySet {
+	return &KeySet{fetch: fetch, ttl: ttl}
+}
+
+// NewRemoteKeySet returns a key set that fetches a JWKS document from url, such as an identity
+// provider's jwks_uri or a local stand-in in tests
+func NewRemoteKeySet(url string, ttl time.Duration) *KeySet {
+	return NewKeySet(func(ctx context.Context) ([]jose.JSONWebKey, error) {
+		var set jose.JSONWebKeySet
+		if err := getJSON(ctx, url, &set); err != nil {
+			return nil, fmt.Errorf("fetching JWKS from %s: %w", url, err)
+		}
+		return set.Keys, nil
+	}, ttl)
+}
+
+// NewClerkKeySet returns a key set that fetches the instance's keys from the Clerk API, using the
+// secret key set by the clerk package
+func NewClerkKeySet(ttl time.Duration) *KeySet {
+	return NewKeySet(func(ctx context.Context) ([]jose.JSONWebKey, error) {
+		client := &jwks.Client{Backend: clerk.GetBackend()}
+		set, err := client.Get(ctx, &jwks.GetParams{})
+		if err != nil {
+			return nil, fmt.Errorf("fetching JWKS from Clerk: %w", err)
+		}
+		keys := make([]jose.JSONWebKey, 0, len(set.Keys))
+		for _, key := range set.Keys {
+			if key != nil {
+				keys = append(keys, jose.JSONWebKey{Key: key.Key, KeyID: key.KeyID, Algorithm: key.Algorithm, Use: key.Use})
+			}
+		}
+		return keys, nil
+	}, ttl)
+}
+
+// Key returns the signing key with the given key ID
+func (s *KeySet) Key(ctx context.Context, keyID string) (jose.JSONWebKey, error) {
+	s.mu.Lock()
+	keys, fetchedAt := s.keys, s.fetchedAt
+	s.mu.Unlock()
+
+	age := time.Since(fetchedAt)
+	key, found := findKey(keys, keyID)
+	if found && age < s.ttl {
+		return key, nil
+	}
+	if !found && !fetchedAt.IsZero() && age < MinRefreshInterval {
+		return jose.JSONWebKey{}, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	keys, err := s.refresh(ctx)
+	if err != nil {
+		if found {
+			return key, nil
+		}
+		return jose.JSONWebKey{}, err
+	}
+	if key, found = findKey(keys, keyID); !found {
+		return jose.JSONWebKey{}, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return key, nil
+}
+
+// refresh fetches the keys again; concurrent callers share one fetch. The fetch runs on its own
+// context bounded by FetchTimeout, so the caller that started it going away doesn't fail it for the
+// others, while each caller still stops waiting when its own ctx is done.
+func (s *KeySet) refresh(ctx context.Context) ([]jose.JSONWebKey, error) {
+	results := s.group.DoChan("", func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.Background(), FetchTimeout)
+		defer cancel()
+		fetched, err := s.fetch(fetchCtx)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.keys, s.fetchedAt = fetched, time.Now()
+		s.mu.Unlock()
+		return fetched, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]jose.JSONWebKey), nil
+	}
+}
+
+func findKey(keys []jose.JSONWebKey, keyID string) (jose.JSONWebKey, bool) {
+	for _, key := range keys {
+		if key.KeyID == keyID && (key.Use == "" || key.Use == "sig") {
+			return key, true
+		}
+	}
+	return jose.JSONWebKey{}, false
+}
+
+// DiscoverJWKSURL reads the jwks_uri of an OpenID Connect issuer from its discovery document
+func DiscoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	var document struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, url, &document); err != nil {
+		return "", fmt.Errorf("fetching OpenID configuration from %s: %w", url, err)
+	}
+	if document.Issuer != issuer {
+		return "", fmt.Errorf("OpenID configuration at %s is for issuer %q, expected %q", url, document.Issuer, issuer)
+	}
+	if document.JWKSURI == "" {
+		return "", fmt.Errorf("OpenID configuration at %s has no jwks_uri", url)
+	}
+	return document.JWKSURI, nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
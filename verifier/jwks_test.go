@@ -0,0 +1,245 @@
+package verifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// jwksServer serves a JWKS document whose keys can be swapped or made to fail mid-test
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jose.JSONWebKey
+	failing bool
+	hits    int
+}
+
+func newJWKSServer(t *testing.T, keys ...jose.JSONWebKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits++
+		if s.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) serve(keys ...jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = true
+}
+
+func (s *jwksServer) hitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+// signingKey is an RSA key with the public half as it is published in the JWKS
+type signingKey struct {
+	private *rsa.PrivateKey
+	public  jose.JSONWebKey
+}
+
+func newSigningKey(t *testing.T, keyID string) signingKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{
+		private: private,
+		public:  jose.JSONWebKey{Key: &private.PublicKey, KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"},
+	}
+}
+
+// sign returns a token with the given claims signed by the key with algorithm
+func (k signingKey) sign(t *testing.T, algorithm jose.SignatureAlgorithm, claims map[string]interface{}) string {
+	t.Helper()
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: k.private},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", k.public.KeyID),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// age makes the key set believe its keys were fetched d earlier than they were
+func (s *KeySet) age(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetchedAt = s.fetchedAt.Add(-d)
+}
+
+func TestKeySetCachesKeys(t *testing.T) {
+	first := newSigningKey(t, "first")
+	server := newJWKSServer(t, first.public)
+	keys := NewRemoteKeySet(server.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		key, err := keys.Key(context.Background(), "first")
+		if err != nil {
+			t.Fatalf("Key() error = %v", err)
+		}
+		if key.KeyID != "first" {
+			t.Fatalf("Key() returned key %q, want %q", key.KeyID, "first")
+		}
+	}
+	if hits := server.hitCount(); hits != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", hits)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	first, second := newSigningKey(t, "first"), newSigningKey(t, "second")
+	server := newJWKSServer(t, first.public)
+	keys := NewRemoteKeySet(server.URL, time.Hour)
+
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key(first) error = %v", err)
+	}
+	server.serve(second.public)
+
+	// A key ID seen right after a fetch doesn't refetch, so forged key IDs can't flood the provider
+	if _, err := keys.Key(context.Background(), "second"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Key(second) within MinRefreshInterval error = %v, want ErrUnknownKey", err)
+	}
+	if hits := server.hitCount(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times within MinRefreshInterval, want 1", hits)
+	}
+
+	// Once MinRefreshInterval has passed the unknown key ID refetches and picks up the rotated key
+	keys.age(MinRefreshInterval)
+	if _, err := keys.Key(context.Background(), "second"); err != nil {
+		t.Fatalf("Key(second) after MinRefreshInterval error = %v", err)
+	}
+	if hits := server.hitCount(); hits != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", hits)
+	}
+
+	// The rotated-out key is gone from the refetched set, and it's too soon to look again
+	if _, err := keys.Key(context.Background(), "first"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Key(first) after rotation error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeySetRefetchesAfterTTL(t *testing.T) {
+	first := newSigningKey(t, "first")
+	server := newJWKSServer(t, first.public)
+	keys := NewRemoteKeySet(server.URL, time.Minute)
+
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	keys.age(time.Minute)
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key() after TTL error = %v", err)
+	}
+	if hits := server.hitCount(); hits != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", hits)
+	}
+}
+
+func TestKeySetKeepsKeysWhenRefetchFails(t *testing.T) {
+	first := newSigningKey(t, "first")
+	server := newJWKSServer(t, first.public)
+	keys := NewRemoteKeySet(server.URL, time.Minute)
+
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	server.fail()
+	keys.age(time.Minute)
+
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key() with failing JWKS error = %v, want the cached key", err)
+	}
+	keys.age(MinRefreshInterval)
+	if _, err := keys.Key(context.Background(), "other"); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Key(other) with failing JWKS error = %v, want the fetch error", err)
+	}
+}
+
+func TestKeySetFetchOutlivesCaller(t *testing.T) {
+	first := newSigningKey(t, "first")
+	started, release := make(chan struct{}), make(chan struct{})
+	var fetchErr error
+	fetches := 0
+	keys := NewKeySet(func(ctx context.Context) ([]jose.JSONWebKey, error) {
+		fetches++
+		close(started)
+		<-release
+		fetchErr = ctx.Err()
+		return []jose.JSONWebKey{first.public}, nil
+	}, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := keys.Key(ctx, "first")
+		done <- err
+	}()
+
+	<-started
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Key() with cancelled context error = %v, want context.Canceled", err)
+	}
+	close(release)
+
+	// The fetch the cancelled caller started still completes and fills the cache for everyone else
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys.mu.Lock()
+		fetched := !keys.fetchedAt.IsZero()
+		keys.mu.Unlock()
+		if fetched {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the fetch never completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fetchErr != nil {
+		t.Fatalf("the fetch saw its context end: %v", fetchErr)
+	}
+	if _, err := keys.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("Key() after the fetch error = %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times, want 1", fetches)
+	}
+}
@@ -0,0 +1,181 @@
+package verifier
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const testIssuer = "https://issuer.example.com"
+
+// validClaims returns the claims of a token the test verifier accepts
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":  testIssuer,
+		"sub":  "user_test",
+		"aud":  []string{"mongo-manager"},
+		"azp":  "dashboard",
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+		"org":  "org_test",
+		"role": "org:admin",
+	}
+}
+
+func newTestOIDC(t *testing.T, key signingKey) *OIDC {
+	t.Helper()
+	server := newJWKSServer(t, key.public)
+	return NewOIDC(NewRemoteKeySet(server.URL, time.Hour), Options{
+		Issuer:            testIssuer,
+		Audiences:         []string{"mongo-manager"},
+		AuthorizedParties: []string{"dashboard"},
+		Leeway:            time.Minute,
+	}, "org", "role")
+}
+
+func TestOIDCVerify(t *testing.T) {
+	key := newSigningKey(t, "first")
+	v := newTestOIDC(t, key)
+
+	claims, err := v.Verify(context.Background(), key.sign(t, jose.RS256, validClaims()))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.Subject != "user_test" || claims.OrganizationID != "org_test" || claims.OrganizationRole != "org:admin" || claims.AuthorizedParty != "dashboard" {
+		t.Errorf("Verify() = %+v", claims)
+	}
+}
+
+func TestOIDCVerifyWithoutAuthorizedParties(t *testing.T) {
+	key := newSigningKey(t, "first")
+	server := newJWKSServer(t, key.public)
+	v := NewOIDC(NewRemoteKeySet(server.URL, time.Hour), Options{Issuer: testIssuer}, "org", "role")
+
+	claims := validClaims()
+	delete(claims, "azp")
+	if _, err := v.Verify(context.Background(), key.sign(t, jose.RS256, claims)); err != nil {
+		t.Fatalf("Verify() without azp and no authorized parties configured error = %v", err)
+	}
+}
+
+func TestOIDCVerifyRejectsClaims(t *testing.T) {
+	key := newSigningKey(t, "first")
+	v := newTestOIDC(t, key)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		valid  bool
+	}{
+		{name: "other issuer", change: func(c map[string]interface{}) { c["iss"] = "https://other.example.com" }},
+		{name: "other audience", change: func(c map[string]interface{}) { c["aud"] = []string{"other"} }},
+		{name: "no audience", change: func(c map[string]interface{}) { delete(c, "aud") }},
+		{name: "other authorized party", change: func(c map[string]interface{}) { c["azp"] = "other" }},
+		{name: "no authorized party", change: func(c map[string]interface{}) { delete(c, "azp") }},
+		{name: "no organization", change: func(c map[string]interface{}) { delete(c, "org") }},
+		{name: "no role", change: func(c map[string]interface{}) { delete(c, "role") }},
+		{name: "expired within leeway", change: func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }, valid: true},
+		{name: "expired beyond leeway", change: func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }},
+		{name: "not yet valid within leeway", change: func(c map[string]interface{}) { c["nbf"] = now.Add(30 * time.Second).Unix() }, valid: true},
+		{name: "not yet valid beyond leeway", change: func(c map[string]interface{}) { c["nbf"] = now.Add(2 * time.Minute).Unix() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.change(claims)
+
+			_, err := v.Verify(context.Background(), key.sign(t, jose.RS256, claims))
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyRejectsAlgorithms(t *testing.T) {
+	key := newSigningKey(t, "first")
+	v := newTestOIDC(t, key)
+
+	t.Run("none", func(t *testing.T) {
+		token := unsignedToken(t, map[string]interface{}{"alg": "none", "typ": "JWT", "kid": "first"}, validClaims())
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+		}
+	})
+
+	t.Run("symmetric", func(t *testing.T) {
+		// Signed with HMAC over the public key, the classic confusion attack on RS256 verifiers
+		secret, err := json.Marshal(key.public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.HS256, Key: secret},
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "first"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Signed(signer).Claims(validClaims()).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+		}
+	})
+
+	t.Run("algorithm other than the key's", func(t *testing.T) {
+		token := key.sign(t, jose.PS256, validClaims())
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		other := newSigningKey(t, "other")
+		token := other.sign(t, jose.RS256, validClaims())
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("Verify() error = %v, want ErrUnknownKey", err)
+		}
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		forger := newSigningKey(t, "first")
+		token := forger.sign(t, jose.RS256, validClaims())
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+// unsignedToken builds a compact token with the given header and claims and an empty signature
+func unsignedToken(t *testing.T, header, claims map[string]interface{}) string {
+	t.Helper()
+	parts := make([]string, 0, 3)
+	for _, part := range []map[string]interface{}{header, claims} {
+		encoded, err := json.Marshal(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(encoded))
+	}
+	return strings.Join(append(parts, ""), ".")
+}
@@ -0,0 +1,209 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Providers selected with JWT_PROVIDER
+const (
+	ProviderClerk = "clerk"
+	ProviderOIDC  = "oidc"
+)
+
+// DefaultLeeway is the clock skew tolerated on exp, nbf and iat when JWT_LEEWAY is not set
+const DefaultLeeway = 5 * time.Second
+
+// DefaultOrganizationClaim and DefaultRoleClaim name the claims OIDC tokens carry the organization
+// and role in when JWT_ORGANIZATION_CLAIM and JWT_ROLE_CLAIM are not set
+const (
+	DefaultOrganizationClaim = "org_id"
+	DefaultRoleClaim         = "org_role"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed, expired or whose claims
+// don't match the configuration
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the verified claims of a token
+type Claims struct {
+	Subject         string
+	Issuer          string
+	Audience        []string
+	AuthorizedParty string
+	// OrganizationID is the organization the token was issued for, if any
+	OrganizationID string
+	// OrganizationRole is the caller's role in OrganizationID as asserted by the token. Clerk
+	// tokens leave it empty: their memberships are looked up through the Clerk API instead, so an
+	// X-Organization-Id selection can be checked too.
+	OrganizationRole string
+	// Custom holds every claim of the token by name
+	Custom map[string]interface{}
+}
+
+// Verifier checks the signature and claims of a bearer token
+type Verifier interface {
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// Options are the claim checks shared by every verifier. Empty fields are not checked.
+type Options struct {
+	// Issuer must equal the iss claim
+	Issuer string
+	// Audiences lists the accepted audiences; the aud claim must contain at least one of them
+	Audiences []string
+	// AuthorizedParties lists the accepted azp claims; when set, tokens without azp are refused
+	AuthorizedParties []string
+	// RequiredClaims lists claims that must be present with the given value, or contain it when
+	// the claim is an array
+	RequiredClaims map[string]string
+	// Leeway is the clock skew tolerated on exp, nbf and iat
+	Leeway time.Duration
+}
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using system environment variables")
+	}
+}
+
+// FromEnv builds the verifier selected by JWT_PROVIDER, "clerk" by default:
+//
+//   - JWT_ISSUER, JWT_AUDIENCES, JWT_AUTHORIZED_PARTIES, JWT_REQUIRED_CLAIMS (comma-separated
+//     claim=value pairs) and JWT_LEEWAY configure the shared Options
+//   - JWT_JWKS_URL overrides where signing keys are fetched from; Clerk keys otherwise come from the
+//     Clerk API and OIDC keys from the issuer's discovery document
+//   - JWKS_CACHE_TTL sets how long fetched keys are kept (default DefaultKeySetTTL)
+//   - JWT_ORGANIZATION_CLAIM and JWT_ROLE_CLAIM name the OIDC organization and role claims
+func FromEnv(ctx context.Context) (Verifier, error) {
+	options := Options{
+		Issuer:            os.Getenv("JWT_ISSUER"),
+		Audiences:         envList("JWT_AUDIENCES"),
+		AuthorizedParties: envList("JWT_AUTHORIZED_PARTIES"),
+		Leeway:            DefaultLeeway,
+	}
+	if configured := os.Getenv("JWT_LEEWAY"); configured != "" {
+		leeway, err := time.ParseDuration(configured)
+		if err != nil || leeway < 0 {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY %q", configured)
+		}
+		options.Leeway = leeway
+	}
+	for _, pair := range envList("JWT_REQUIRED_CLAIMS") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid JWT_REQUIRED_CLAIMS entry %q, expected claim=value", pair)
+		}
+		if options.RequiredClaims == nil {
+			options.RequiredClaims = map[string]string{}
+		}
+		options.RequiredClaims[name] = value
+	}
+
+	ttl := DefaultKeySetTTL
+	if configured := os.Getenv("JWKS_CACHE_TTL"); configured != "" {
+		parsed, err := time.ParseDuration(configured)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid JWKS_CACHE_TTL %q", configured)
+		}
+		ttl = parsed
+	}
+	jwksURL := os.Getenv("JWT_JWKS_URL")
+
+	switch provider := os.Getenv("JWT_PROVIDER"); provider {
+	case "", ProviderClerk:
+		keys := NewClerkKeySet(ttl)
+		if jwksURL != "" {
+			keys = NewRemoteKeySet(jwksURL, ttl)
+		}
+		return NewClerk(keys, options), nil
+	case ProviderOIDC:
+		if options.Issuer == "" {
+			return nil, errors.New("JWT_PROVIDER \"oidc\" requires JWT_ISSUER")
+		}
+		if jwksURL == "" {
+			discovered, err := DiscoverJWKSURL(ctx, options.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			jwksURL = discovered
+		}
+		organizationClaim := os.Getenv("JWT_ORGANIZATION_CLAIM")
+		if organizationClaim == "" {
+			organizationClaim = DefaultOrganizationClaim
+		}
+		roleClaim := os.Getenv("JWT_ROLE_CLAIM")
+		if roleClaim == "" {
+			roleClaim = DefaultRoleClaim
+		}
+		return NewOIDC(NewRemoteKeySet(jwksURL, ttl), options, organizationClaim, roleClaim), nil
+	default:
+		return nil, fmt.Errorf("unknown JWT_PROVIDER %q, expected %q or %q", provider, ProviderClerk, ProviderOIDC)
+	}
+}
+
+// check applies the audience, authorized party and required claim checks to verified claims. The
+// issuer and time claims are checked by the verifiers, which know how their provider encodes them.
+func (o Options) check(claims *Claims) error {
+	if len(o.Audiences) > 0 && !containsAny(claims.Audience, o.Audiences) {
+		return fmt.Errorf("%w: audience %v is not accepted", ErrInvalidToken, claims.Audience)
+	}
+	if len(o.AuthorizedParties) > 0 {
+		if claims.AuthorizedParty == "" {
+			return fmt.Errorf("%w: the token has no authorized party", ErrInvalidToken)
+		}
+		if !containsAny([]string{claims.AuthorizedParty}, o.AuthorizedParties) {
+			return fmt.Errorf("%w: authorized party %q is not accepted", ErrInvalidToken, claims.AuthorizedParty)
+		}
+	}
+	for name, expected := range o.RequiredClaims {
+		if !claimMatches(claims.Custom[name], expected) {
+			return fmt.Errorf("%w: claim %q does not have the required value", ErrInvalidToken, name)
+		}
+	}
+	return nil
+}
+
+func claimMatches(value interface{}, expected string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == expected
+	case bool, float64:
+		return fmt.Sprint(v) == expected
+	case []interface{}:
+		for _, item := range v {
+			if claimMatches(item, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsAny(values, accepted []string) bool {
+	for _, value := range values {
+		for _, candidate := range accepted {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}